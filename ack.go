@@ -0,0 +1,142 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// Acknowledgement states exported by the check-ack operation.
+const (
+	ackAcknowledged   = "acknowledged"
+	ackUnacknowledged = "unacknowledged"
+)
+
+// ackAction returns the Acknowledge button, which posts the token to the ack URL.
+func ackAction(ackURL, token string) (Action, error) {
+	body, err := json.Marshal(struct {
+		Token string `json:"ack_token"`
+	}{token})
+	if err != nil {
+		return Action{}, err
+	}
+	return Action{Type: "HttpPOST", Name: label(locale.ButtonAcknowledge), Target: ackURL, Body: string(body)}, nil
+}
+
+// addAckAction adds the Acknowledge button with a new token to a failure card and returns the
+// token, which is exported once the card is sent.
+func addAckAction(msg *Message, ackURL string) (string, error) {
+	token, err := newUUID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate acknowledgement token: %s", err)
+	}
+	action, err := ackAction(ackURL, token)
+	if err != nil {
+		return "", err
+	}
+	msg.Sections[0].Actions = append(msg.Sections[0].Actions, action)
+	return token, nil
+}
+
+// ackStatusURL substitutes the token for the {token} placeholder of the status URL, or adds it
+// as the token query parameter.
+func ackStatusURL(statusURL, token string) string {
+	if strings.Contains(statusURL, "{token}") {
+		return strings.Replace(statusURL, "{token}", url.PathEscape(token), -1)
+	}
+	sep := "?"
+	if strings.Contains(statusURL, "?") {
+		sep = "&"
+	}
+	return statusURL + sep + "token=" + url.QueryEscape(token)
+}
+
+// checkAck asks the status endpoint whether the token was acknowledged. The endpoint answers
+// with {"acknowledged": true|false}, a 404 means an unknown token which was not acknowledged.
+func checkAck(client *http.Client, statusURL, token string) (bool, error) {
+	resp, err := client.Get(ackStatusURL(statusURL, token))
+	if err != nil {
+		return false, redactURLError(err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warnf("Failed to close response body: %s", err)
+		}
+	}()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, fmt.Errorf("failed to read response: %s", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("server error: %s, response: %s", resp.Status, body)
+	}
+
+	var status struct {
+		Acknowledged bool `json:"acknowledged"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return false, fmt.Errorf("invalid response: %s", err)
+	}
+	return status.Acknowledged, nil
+}
+
+// runCheckAck exports whether the failure notified with the token was acknowledged and returns
+// the exit code of the step.
+func runCheckAck(conf Config) int {
+	if conf.AckStatusURL == "" || conf.AckToken == "" {
+		logger.Errorf("Error: ack_status_url and ack_token are required by the check-ack operation")
+		return 1
+	}
+	client := &http.Client{Transport: transport, Timeout: time.Duration(conf.TimeoutSeconds) * time.Second}
+	acknowledged, err := checkAck(client, conf.AckStatusURL, conf.AckToken)
+	if err != nil {
+		logger.Errorf("Error: %s", err)
+		return 1
+	}
+
+	status := ackUnacknowledged
+	if acknowledged {
+		status = ackAcknowledged
+	}
+	logger.Printf("The failure is %s.", status)
+	if err := exportEnv("TEAMS_ACK_STATUS", status); err != nil {
+		logger.Warnf("%s", err)
+	}
+	return 0
+}
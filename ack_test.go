@@ -0,0 +1,192 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestAddAckAction(t *testing.T) {
+	exported := captureOutputs(t)
+	msg := Message{Sections: []Section{{Actions: []Action{{Type: "OpenUri", Name: "View Build"}}}}}
+
+	token, err := addAckAction(&msg, "https://relay.example.com/ack")
+	if err != nil {
+		t.Fatalf("addAckAction() error = %v", err)
+	}
+	if !uuidPattern.MatchString(token) {
+		t.Errorf("token = %q, want a random UUID", token)
+	}
+	actions := msg.Sections[0].Actions
+	if len(actions) != 2 {
+		t.Fatalf("actions = %+v, want the Acknowledge button appended", actions)
+	}
+	a := actions[1]
+	if a.Type != "HttpPOST" || a.Name != "Acknowledge" || a.Target != "https://relay.example.com/ack" {
+		t.Errorf("action = %+v, want the Acknowledge HttpPOST to the ack URL", a)
+	}
+	var body struct {
+		Token string `json:"ack_token"`
+	}
+	if err := json.Unmarshal([]byte(a.Body), &body); err != nil || body.Token != token {
+		t.Errorf("body = %s, want the token %q", a.Body, token)
+	}
+	if _, ok := exported["TEAMS_ACK_TOKEN"]; ok {
+		t.Errorf("TEAMS_ACK_TOKEN is exported before the card is sent")
+	}
+
+	other, err := addAckAction(&Message{Sections: []Section{{}}}, "https://relay.example.com/ack")
+	if err != nil || other == token {
+		t.Errorf("second token = %q, %v, want a new token", other, err)
+	}
+}
+
+func TestAckStatusURL(t *testing.T) {
+	tests := []struct {
+		statusURL string
+		want      string
+	}{
+		{"https://relay.example.com/ack/{token}", "https://relay.example.com/ack/a%2Fb"},
+		{"https://relay.example.com/ack", "https://relay.example.com/ack?token=a%2Fb"},
+		{"https://relay.example.com/ack?team=ios", "https://relay.example.com/ack?team=ios&token=a%2Fb"},
+	}
+	for _, tt := range tests {
+		if got := ackStatusURL(tt.statusURL, "a/b"); got != tt.want {
+			t.Errorf("ackStatusURL(%q) = %q, want %q", tt.statusURL, got, tt.want)
+		}
+	}
+}
+
+// ackServer answers the status of the tokens: "yes" and "no" are known, "broken" returns an
+// invalid answer and "fail" a server error.
+func ackServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("token") {
+		case "yes":
+			w.Write([]byte(`{"acknowledged": true}`))
+		case "no":
+			w.Write([]byte(`{"acknowledged": false}`))
+		case "broken":
+			w.Write([]byte(`acknowledged`))
+		case "fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCheckAck(t *testing.T) {
+	srv := ackServer(t)
+	tests := []struct {
+		token   string
+		want    bool
+		wantErr bool
+	}{
+		{"yes", true, false},
+		{"no", false, false},
+		{"unknown", false, false},
+		{"broken", false, true},
+		{"fail", false, true},
+	}
+	for _, tt := range tests {
+		got, err := checkAck(srv.Client(), srv.URL, tt.token)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("checkAck(%q) = %t, %v, want %t, error %t", tt.token, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestRunCheckAck(t *testing.T) {
+	srv := ackServer(t)
+	tests := []struct {
+		name   string
+		conf   Config
+		code   int
+		status string
+	}{
+		{"acknowledged", Config{AckStatusURL: srv.URL, AckToken: "yes", TimeoutSeconds: 5}, 0, ackAcknowledged},
+		{"unacknowledged", Config{AckStatusURL: srv.URL, AckToken: "unknown", TimeoutSeconds: 5}, 0, ackUnacknowledged},
+		{"server error", Config{AckStatusURL: srv.URL, AckToken: "fail", TimeoutSeconds: 5}, 1, ""},
+		{"no token", Config{AckStatusURL: srv.URL, TimeoutSeconds: 5}, 1, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exported := captureOutputs(t)
+			if code := runCheckAck(tt.conf); code != tt.code {
+				t.Errorf("runCheckAck() = %d, want %d", code, tt.code)
+			}
+			if got := exported["TEAMS_ACK_STATUS"]; got != tt.status {
+				t.Errorf("TEAMS_ACK_STATUS = %q, want %q", got, tt.status)
+			}
+		})
+	}
+}
+
+func TestAckTokenExportedOnceSent(t *testing.T) {
+	saved := success
+	success = false
+	t.Cleanup(func() { success = saved })
+	t.Setenv("BITRISE_DEPLOY_DIR", t.TempDir())
+	captureLog(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("1"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		conf     Config
+		exported bool
+	}{
+		{"dry run", Config{DryRun: true}, false},
+		{"collected", Config{DigestFile: t.TempDir() + "/digest.jsonl", DigestMode: "collect"}, false},
+		{"sent", Config{TimeoutSeconds: 5}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exported := captureOutputs(t)
+			tt.conf.AckURL = "https://relay.example.com/ack"
+			p := &sendPipeline{conf: tt.conf, report: &RunReport{}, urls: []string{srv.URL}}
+			if code := p.run(); code != 0 {
+				t.Fatalf("run() = %d, want 0", code)
+			}
+			token, ok := exported["TEAMS_ACK_TOKEN"]
+			if ok != tt.exported || ok && token != p.ackToken {
+				t.Errorf("TEAMS_ACK_TOKEN = %q, %t, want exported %t", token, ok, tt.exported)
+			}
+		})
+	}
+}
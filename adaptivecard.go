@@ -0,0 +1,236 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// See also: https://adaptivecards.io/explorer/
+
+const (
+	adaptiveCardSchema      = "http://adaptivecards.io/schemas/adaptive-card.json"
+	adaptiveCardVersion     = "1.4"
+	adaptiveCardContentType = "application/vnd.microsoft.card.adaptive"
+)
+
+// adaptiveElement is an element or an action of an Adaptive Card, only the properties used
+// by the step are declared.
+type adaptiveElement struct {
+	Type      string            `json:"type"`
+	ID        string            `json:"id,omitempty"`
+	Text      string            `json:"text,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	URL       string            `json:"url,omitempty"`
+	AltText   string            `json:"altText,omitempty"`
+	Size      string            `json:"size,omitempty"`
+	Style     string            `json:"style,omitempty"`
+	Weight    string            `json:"weight,omitempty"`
+	Color     string            `json:"color,omitempty"`
+	IsSubtle  bool              `json:"isSubtle,omitempty"`
+	Wrap      bool              `json:"wrap,omitempty"`
+	Separator bool              `json:"separator,omitempty"`
+	Facts     []adaptiveFact    `json:"facts,omitempty"`
+	Images    []adaptiveElement `json:"images,omitempty"`
+	Items     []adaptiveElement `json:"items,omitempty"`
+	Columns   []adaptiveElement `json:"columns,omitempty"`
+	// SelectAction is the action of clicking the element.
+	SelectAction *adaptiveElement `json:"selectAction,omitempty"`
+	// IsVisible is only set to hide an element.
+	IsVisible      *bool    `json:"isVisible,omitempty"`
+	TargetElements []string `json:"targetElements,omitempty"`
+}
+
+type adaptiveFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+type adaptiveCard struct {
+	Schema  string            `json:"$schema,omitempty"`
+	Type    string            `json:"type"`
+	Version string            `json:"version,omitempty"`
+	Body    []adaptiveElement `json:"body"`
+	Actions []adaptiveElement `json:"actions,omitempty"`
+	MSTeams *adaptiveMSTeams  `json:"msteams,omitempty"`
+}
+
+type adaptiveMSTeams struct {
+	Width    string           `json:"width"`
+	Entities []adaptiveEntity `json:"entities,omitempty"`
+}
+
+// adaptiveEntity is a mention, its Text must appear in a text block of the card for Teams to
+// notify the mentioned user.
+type adaptiveEntity struct {
+	Type      string            `json:"type"`
+	Text      string            `json:"text"`
+	Mentioned adaptiveMentioned `json:"mentioned"`
+}
+
+type adaptiveMentioned struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// mentionText is the text which mentions the user.
+func mentionText(m Mention) string {
+	return "<at>" + m.Name + "</at>"
+}
+
+// adaptiveMessage is the envelope of an Adaptive Card posted to an incoming webhook.
+type adaptiveMessage struct {
+	Type        string               `json:"type"`
+	Attachments []adaptiveAttachment `json:"attachments"`
+}
+
+type adaptiveAttachment struct {
+	ContentType string       `json:"contentType"`
+	Content     adaptiveCard `json:"content"`
+}
+
+func textBlock(text string) adaptiveElement {
+	return adaptiveElement{Type: "TextBlock", Text: text, Wrap: true}
+}
+
+// dropHTTPPOSTActions removes the HttpPOST buttons, which have no Adaptive Card equivalent Teams
+// renders, from the message and returns their names.
+func dropHTTPPOSTActions(msg *Message) []string {
+	var names []string
+	for i := range msg.Sections {
+		s := &msg.Sections[i]
+		kept := s.Actions[:0]
+		for _, a := range s.Actions {
+			if a.Type == "HttpPOST" {
+				names = append(names, a.Name)
+				continue
+			}
+			kept = append(kept, a)
+		}
+		s.Actions = kept
+	}
+	return names
+}
+
+// newAdaptiveCard builds the Adaptive Card equivalent of the MessageCard: the sections become
+// consecutive blocks of the body and their actions the actions of the card. The theme color
+// can't be set on an Adaptive Card, the title is colored by the build status instead.
+func newAdaptiveCard(msg Message) adaptiveCard {
+	card := adaptiveCard{
+		Schema:  adaptiveCardSchema,
+		Type:    "AdaptiveCard",
+		Version: adaptiveCardVersion,
+		MSTeams: &adaptiveMSTeams{Width: "Full"},
+	}
+	for _, s := range msg.Sections {
+		if s.HeroImage != nil {
+			card.Body = append(card.Body, adaptiveElement{Type: "Image", URL: s.HeroImage.URL, AltText: s.HeroImage.Title, Size: "Stretch"})
+		}
+	}
+	if msg.Title != "" {
+		title := textBlock(msg.Title)
+		title.Size, title.Weight, title.Color = "Large", "Bolder", selectValue("Good", "Attention")
+		card.Body = append(card.Body, title)
+	}
+
+	for i, s := range msg.Sections {
+		var blocks []adaptiveElement
+		if s.Title != "" {
+			t := textBlock(s.Title)
+			t.Weight = "Bolder"
+			blocks = append(blocks, t)
+		}
+		if s.ActivityImage != "" {
+			blocks = append(blocks, adaptiveElement{Type: "Image", URL: s.ActivityImage, AltText: s.ActivityTitle, Size: "Small", Style: "Person"})
+		}
+		if s.ActivityTitle != "" {
+			t := textBlock(s.ActivityTitle)
+			t.Weight = "Bolder"
+			blocks = append(blocks, t)
+		}
+		if s.ActivityText != "" {
+			t := textBlock(s.ActivityText)
+			t.IsSubtle = true
+			blocks = append(blocks, t)
+		}
+		if s.Text != "" {
+			blocks = append(blocks, textBlock(s.Text))
+		}
+		if len(s.Facts) > 0 {
+			set := adaptiveElement{Type: "FactSet"}
+			for _, f := range s.Facts {
+				set.Facts = append(set.Facts, adaptiveFact{Title: f.Name, Value: f.Value})
+			}
+			blocks = append(blocks, set)
+		}
+		for _, img := range s.Images {
+			e := adaptiveElement{Type: "Image", URL: img.URL, AltText: img.Title}
+			if img.Link != "" {
+				e.SelectAction = &adaptiveElement{Type: "Action.OpenUrl", Title: img.Title, URL: img.Link}
+			}
+			blocks = append(blocks, e)
+		}
+		for _, a := range s.Actions {
+			if len(a.Targets) > 0 {
+				card.Actions = append(card.Actions, adaptiveElement{Type: "Action.OpenUrl", Title: a.Name, URL: a.Targets[0].URI})
+			}
+		}
+
+		if len(blocks) > 0 && s.Collapsed {
+			hidden := false
+			card.Body = append(card.Body, adaptiveElement{Type: "Container", ID: detailsElementID, IsVisible: &hidden, Items: blocks})
+			card.Actions = append(card.Actions, adaptiveElement{Type: "Action.ToggleVisibility", Title: label(locale.ButtonShowDetails), TargetElements: []string{detailsElementID}})
+		} else if len(blocks) > 0 {
+			blocks[0].Separator = i > 0
+			card.Body = append(card.Body, blocks...)
+		}
+	}
+	if len(msg.Mentions) > 0 {
+		var texts []string
+		for _, m := range msg.Mentions {
+			texts = append(texts, mentionText(m))
+			card.MSTeams.Entities = append(card.MSTeams.Entities, adaptiveEntity{
+				Type:      "mention",
+				Text:      mentionText(m),
+				Mentioned: adaptiveMentioned{ID: m.ID, Name: m.Name},
+			})
+		}
+		card.Body = append(card.Body, textBlock(strings.Join(texts, " ")))
+	}
+	return card
+}
+
+// newAdaptiveMessage wraps the Adaptive Card equivalent of the MessageCard in the envelope
+// accepted by the Workflows webhooks.
+func newAdaptiveMessage(msg Message) adaptiveMessage {
+	return adaptiveMessage{
+		Type:        "message",
+		Attachments: []adaptiveAttachment{{ContentType: adaptiveCardContentType, Content: newAdaptiveCard(msg)}},
+	}
+}
@@ -0,0 +1,86 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDropHTTPPOSTActions(t *testing.T) {
+	open := Action{Type: "OpenUri", Name: "Docs", Targets: []Target{{OS: "default", URI: "https://example.com"}}}
+	msg := Message{Sections: []Section{
+		{Actions: []Action{{Type: "HttpPOST", Name: "Rerun", Target: "https://example.com/hook", Body: "{}"}, open}},
+		{Actions: []Action{{Type: "HttpPOST", Name: "Acknowledge", Target: "https://example.com/ack", Body: "{}"}}},
+	}}
+	names := dropHTTPPOSTActions(&msg)
+	if want := []string{"Rerun", "Acknowledge"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("dropHTTPPOSTActions() = %v, want %v", names, want)
+	}
+	if want := []Action{open}; !reflect.DeepEqual(msg.Sections[0].Actions, want) {
+		t.Errorf("actions of section 1 = %v, want %v", msg.Sections[0].Actions, want)
+	}
+	if len(msg.Sections[1].Actions) != 0 {
+		t.Errorf("actions of section 2 = %v, want none", msg.Sections[1].Actions)
+	}
+
+	card := newAdaptiveCard(msg)
+	if len(card.Actions) != 1 || card.Actions[0].Type != "Action.OpenUrl" || card.Actions[0].URL != "https://example.com" {
+		t.Errorf("card actions = %+v, want the Docs button only", card.Actions)
+	}
+}
+
+func TestAdaptiveCardMentions(t *testing.T) {
+	card := newAdaptiveCard(Message{
+		Title:    "Build Failed!",
+		Mentions: []Mention{{Name: "Jane Doe", ID: "jane@company.com"}, {Name: "On-call", ID: "29:1a2b3c"}},
+	})
+	b, err := json.Marshal(card.MSTeams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"width":"Full","entities":[` +
+		`{"type":"mention","text":"\u003cat\u003eJane Doe\u003c/at\u003e","mentioned":{"id":"jane@company.com","name":"Jane Doe"}},` +
+		`{"type":"mention","text":"\u003cat\u003eOn-call\u003c/at\u003e","mentioned":{"id":"29:1a2b3c","name":"On-call"}}]}`
+	if string(b) != want {
+		t.Errorf("msteams = %s, want %s", b, want)
+	}
+
+	// Teams notifies the user only if the text of the entity appears in the card.
+	last := card.Body[len(card.Body)-1]
+	for _, e := range card.MSTeams.Entities {
+		if !strings.Contains(last.Text, e.Text) {
+			t.Errorf("the card text %q does not contain the mention %q", last.Text, e.Text)
+		}
+	}
+
+	if card := newAdaptiveCard(Message{Title: "Build Failed!"}); card.MSTeams.Entities != nil {
+		t.Errorf("entities = %+v, want none without mentions", card.MSTeams.Entities)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// auditRecord is a line of the audit log, the fields recorded depend on the audit detail.
+type auditRecord struct {
+	// minimal
+	Time        string `json:"time"`
+	Host        string `json:"host"`
+	ContentHash string `json:"content_hash"`
+	Outcome     string `json:"outcome"`
+	// standard
+	Attempt       int    `json:"attempt,omitempty"`
+	TriggeredBy   string `json:"triggered_by,omitempty"`
+	BuildSlug     string `json:"build_slug,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	PayloadSize   int    `json:"payload_size,omitempty"`
+	// full
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// auditLog appends a record per send attempt to the audit log file.
+type auditLog struct {
+	Path        string
+	Detail      string
+	TriggeredBy string
+	BuildSlug   string
+}
+
+// redactPayload removes the email addresses from the strings of the JSON payload.
+func redactPayload(b []byte) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redactEmails(v))
+}
+
+// redactEmails removes the email addresses from the strings of the decoded JSON value.
+func redactEmails(v interface{}) interface{} {
+	switch t := v.(type) {
+	case string:
+		return emailPattern.ReplaceAllString(t, "")
+	case []interface{}:
+		for i := range t {
+			t[i] = redactEmails(t[i])
+		}
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = redactEmails(e)
+		}
+	}
+	return v
+}
+
+// newAuditRecord returns the record of an attempt with the fields of the audit detail. The
+// content hash is the SHA-256 of the redacted payload of the attempt, which the full detail
+// records.
+func (a auditLog) newAuditRecord(now time.Time, host string, report *RunReport, sendErr error) auditRecord {
+	r := auditRecord{
+		Time:    now.UTC().Format(time.RFC3339),
+		Host:    host,
+		Outcome: "sent",
+	}
+	if sendErr != nil {
+		r.Outcome = sendErr.Error()
+	}
+	var redacted []byte
+	if len(report.Payload) > 0 {
+		var err error
+		if redacted, err = redactPayload(report.Payload); err != nil {
+			logger.Warnf("Failed to redact the payload for the audit log: %s", err)
+		} else {
+			sum := sha256.Sum256(redacted)
+			r.ContentHash = hex.EncodeToString(sum[:])
+		}
+	}
+	if a.Detail == "minimal" {
+		return r
+	}
+
+	r.Attempt = report.Attempts
+	r.TriggeredBy = a.TriggeredBy
+	r.BuildSlug = a.BuildSlug
+	r.CorrelationID = report.CorrelationID
+	r.PayloadSize = report.PayloadSize
+	if a.Detail == "standard" {
+		return r
+	}
+
+	r.Payload = json.RawMessage(redacted)
+	return r
+}
+
+// record appends the record of an attempt, a failure is only logged as it must never affect
+// the notification.
+func (a auditLog) record(now time.Time, host string, report *RunReport, sendErr error) {
+	if a.Path == "" {
+		return
+	}
+	b, err := json.Marshal(a.newAuditRecord(now, host, report, sendErr))
+	if err != nil {
+		logger.Warnf("Failed to write the audit log: %s", err)
+		return
+	}
+	if err := appendFileLocked(a.Path, append(b, '\n'), 0600); err != nil {
+		logger.Warnf("Failed to write the audit log: %s", err)
+	}
+}
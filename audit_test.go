@@ -0,0 +1,128 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactPayload(t *testing.T) {
+	payload := `{"type":"message","attachments":[{"content":{"body":[{"text":"Fix by <jane@example.com>"}],` +
+		`"msteams":{"entities":[{"mentioned":{"id":"joe@example.com","name":"Joe"}}]},"version":1.4}}]}`
+	want := `{"attachments":[{"content":{"body":[{"text":"Fix by "}],` +
+		`"msteams":{"entities":[{"mentioned":{"id":"","name":"Joe"}}]},"version":1.4}}],"type":"message"}`
+	got, err := redactPayload([]byte(payload))
+	if err != nil {
+		t.Fatalf("redactPayload() error = %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("redactPayload() = %s, want %s", got, want)
+	}
+	if _, err := redactPayload([]byte(`{"title":`)); err == nil {
+		t.Errorf("redactPayload() of a partial payload error = nil, want an error")
+	}
+}
+
+func TestNewAuditRecord(t *testing.T) {
+	now := time.Date(2024, 5, 7, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	report := &RunReport{
+		Attempts:      2,
+		CorrelationID: "id-1",
+		PayloadSize:   48,
+		Payload:       []byte(`{"title":"Build Failed!","text":"by jane@example.com"}`),
+	}
+	sum := sha256.Sum256([]byte(`{"text":"by ","title":"Build Failed!"}`))
+	hash := hex.EncodeToString(sum[:])
+	tests := []struct {
+		detail string
+		err    error
+		want   string
+	}{
+		{"minimal", nil, `{"time":"2024-05-07T10:00:00Z","host":"example.com","content_hash":"` + hash + `","outcome":"sent"}`},
+		{"minimal", errors.New("server error: 500"), `{"time":"2024-05-07T10:00:00Z","host":"example.com","content_hash":"` + hash + `","outcome":"server error: 500"}`},
+		{"standard", nil, `{"time":"2024-05-07T10:00:00Z","host":"example.com","content_hash":"` + hash + `","outcome":"sent",` +
+			`"attempt":2,"triggered_by":"jane","build_slug":"slug","correlation_id":"id-1","payload_size":48}`},
+		{"full", nil, `{"time":"2024-05-07T10:00:00Z","host":"example.com","content_hash":"` + hash + `","outcome":"sent",` +
+			`"attempt":2,"triggered_by":"jane","build_slug":"slug","correlation_id":"id-1","payload_size":48,` +
+			`"payload":{"text":"by ","title":"Build Failed!"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.detail, func(t *testing.T) {
+			a := auditLog{Detail: tt.detail, TriggeredBy: "jane", BuildSlug: "slug"}
+			b, err := json.Marshal(a.newAuditRecord(now, "example.com", report, tt.err))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tt.want {
+				t.Errorf("record =\n%s\nwant\n%s", b, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAuditRecordWithoutPayload(t *testing.T) {
+	a := auditLog{Detail: "full"}
+	b, err := json.Marshal(a.newAuditRecord(time.Unix(0, 0), "example.com", &RunReport{Attempts: 1}, errors.New("invalid payload")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"time":"1970-01-01T00:00:00Z","host":"example.com","content_hash":"","outcome":"invalid payload","attempt":1}`
+	if string(b) != want {
+		t.Errorf("record = %s, want %s", b, want)
+	}
+}
+
+func TestAuditLogRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	a := auditLog{Path: path, Detail: "minimal"}
+	report := &RunReport{Payload: []byte(`{"title":"t"}`)}
+	a.record(time.Unix(0, 0), "example.com", report, nil)
+	a.record(time.Unix(60, 0), "example.com", report, nil)
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], `"time":"1970-01-01T00:01:00Z"`) {
+		t.Errorf("audit log = %q, want the two records in order", lines)
+	}
+
+	log := captureLog(t)
+	auditLog{Path: filepath.Join(t.TempDir(), "missing", "audit.jsonl")}.record(time.Unix(0, 0), "example.com", report, nil)
+	if !strings.Contains(log.String(), "Failed to write the audit log") {
+		t.Errorf("the failed write is not warned about: %s", log)
+	}
+}
@@ -0,0 +1,128 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+)
+
+// webhookPayload holds the commit details of a GitHub or GitLab push webhook payload.
+type webhookPayload struct {
+	HeadCommit *payloadCommit  `json:"head_commit"`
+	Commits    []payloadCommit `json:"commits"`
+}
+
+type payloadCommit struct {
+	Message string `json:"message"`
+	Author  struct {
+		Name string `json:"name"`
+	} `json:"author"`
+}
+
+// readWebhookPayload returns the last commit of the webhook payload file at path.
+func readWebhookPayload(path string) *payloadCommit {
+	if path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.Debugf("Failed to read webhook payload: %s\n", err)
+		return nil
+	}
+	var p webhookPayload
+	if err := json.Unmarshal(b, &p); err != nil {
+		logger.Debugf("Failed to parse webhook payload: %s\n", err)
+		return nil
+	}
+	if p.HeadCommit != nil {
+		return p.HeadCommit
+	}
+	if len(p.Commits) > 0 {
+		return &p.Commits[len(p.Commits)-1]
+	}
+	return nil
+}
+
+// valueSource is a named source of a fallback value.
+type valueSource struct {
+	Name  string
+	Value func() string
+}
+
+// firstValue returns the first non-empty value of the sources in order and the name of its source.
+func firstValue(sources []valueSource) (string, string) {
+	for _, s := range sources {
+		if v := strings.TrimSpace(s.Value()); v != "" {
+			return v, s.Name
+		}
+	}
+	return "", ""
+}
+
+// backfillGitInputs fills the empty author name and subject, eg. if the repository was not
+// cloned with git, from the Bitrise envs and then from the webhook payload.
+func backfillGitInputs(c *Config, getenv func(string) string) {
+	var commit *payloadCommit
+	payload := func(f func(*payloadCommit) string) func() string {
+		return func() string {
+			if commit == nil {
+				commit = readWebhookPayload(getenv("BITRISE_WEBHOOK_PAYLOAD_PATH"))
+			}
+			if commit == nil {
+				return ""
+			}
+			return f(commit)
+		}
+	}
+	env := func(key string) valueSource {
+		return valueSource{Name: "$" + key, Value: func() string { return getenv(key) }}
+	}
+
+	if strings.TrimSpace(c.AuthorName) == "" {
+		v, source := firstValue([]valueSource{
+			env("GIT_CLONE_COMMIT_AUTHOR_NAME"),
+			{Name: "webhook payload", Value: payload(func(p *payloadCommit) string { return p.Author.Name })},
+		})
+		if source != "" {
+			logger.Debugf("Author name taken from %s\n", source)
+		}
+		c.AuthorName = v
+	}
+
+	if strings.TrimSpace(c.Subject) == "" {
+		v, source := firstValue([]valueSource{
+			env("GIT_CLONE_COMMIT_MESSAGE_SUBJECT"),
+			env("BITRISE_GIT_MESSAGE"),
+			{Name: "webhook payload", Value: payload(func(p *payloadCommit) string { return strings.SplitN(p.Message, "\n", 2)[0] })},
+		})
+		if source != "" {
+			logger.Debugf("Subject taken from %s\n", source)
+		}
+		c.Subject = v
+	}
+}
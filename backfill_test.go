@@ -0,0 +1,172 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writePayload(t *testing.T, payload string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "payload.json")
+	if err := ioutil.WriteFile(path, []byte(payload), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadWebhookPayload(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		{"head commit", `{"head_commit":{"message":"Fix the login","author":{"name":"Jane"}},"commits":[{"message":"Other"}]}`, "Fix the login"},
+		{"last commit", `{"commits":[{"message":"First"},{"message":"Last"}]}`, "Last"},
+		{"no commits", `{"ref":"refs/heads/main"}`, ""},
+		{"invalid JSON", `{"head_commit":`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			got := readWebhookPayload(writePayload(t, tt.payload))
+			if (got == nil) != (tt.want == "") || got != nil && got.Message != tt.want {
+				t.Errorf("readWebhookPayload(%s) = %+v, want message %q", tt.payload, got, tt.want)
+			}
+		})
+	}
+	if got := readWebhookPayload(""); got != nil {
+		t.Errorf("readWebhookPayload(\"\") = %+v, want nil", got)
+	}
+	if got := readWebhookPayload(filepath.Join(t.TempDir(), "missing.json")); got != nil {
+		t.Errorf("readWebhookPayload(missing) = %+v, want nil", got)
+	}
+}
+
+func TestBackfillGitInputs(t *testing.T) {
+	payload := writePayload(t, `{"head_commit":{"message":"Payload subject\n\nBody","author":{"name":"Payload Author"}}}`)
+	tests := []struct {
+		name        string
+		conf        Config
+		envs        map[string]string
+		wantAuthor  string
+		wantSubject string
+		wantSources []string
+	}{
+		{
+			name: "inputs kept",
+			conf: Config{AuthorName: "Input Author", Subject: "Input subject"},
+			envs: map[string]string{
+				"GIT_CLONE_COMMIT_AUTHOR_NAME":     "Env Author",
+				"GIT_CLONE_COMMIT_MESSAGE_SUBJECT": "Clone subject",
+				"BITRISE_WEBHOOK_PAYLOAD_PATH":     payload,
+			},
+			wantAuthor: "Input Author", wantSubject: "Input subject",
+		},
+		{
+			name: "clone envs",
+			envs: map[string]string{
+				"GIT_CLONE_COMMIT_AUTHOR_NAME":     "Env Author",
+				"GIT_CLONE_COMMIT_MESSAGE_SUBJECT": "Clone subject",
+				"BITRISE_GIT_MESSAGE":              "Git message",
+				"BITRISE_WEBHOOK_PAYLOAD_PATH":     payload,
+			},
+			wantAuthor: "Env Author", wantSubject: "Clone subject",
+			wantSources: []string{"Author name taken from $GIT_CLONE_COMMIT_AUTHOR_NAME", "Subject taken from $GIT_CLONE_COMMIT_MESSAGE_SUBJECT"},
+		},
+		{
+			name: "Bitrise git message",
+			conf: Config{AuthorName: " \n"},
+			envs: map[string]string{
+				"BITRISE_GIT_MESSAGE":          "Git message",
+				"BITRISE_WEBHOOK_PAYLOAD_PATH": payload,
+			},
+			wantAuthor: "Payload Author", wantSubject: "Git message",
+			wantSources: []string{"Author name taken from webhook payload", "Subject taken from $BITRISE_GIT_MESSAGE"},
+		},
+		{
+			name:       "webhook payload",
+			envs:       map[string]string{"BITRISE_WEBHOOK_PAYLOAD_PATH": payload},
+			wantAuthor: "Payload Author", wantSubject: "Payload subject",
+			wantSources: []string{"Author name taken from webhook payload", "Subject taken from webhook payload"},
+		},
+		{
+			name: "blank envs skipped",
+			envs: map[string]string{
+				"GIT_CLONE_COMMIT_AUTHOR_NAME":     " ",
+				"GIT_CLONE_COMMIT_MESSAGE_SUBJECT": "\n",
+				"BITRISE_WEBHOOK_PAYLOAD_PATH":     payload,
+			},
+			wantAuthor: "Payload Author", wantSubject: "Payload subject",
+		},
+		{
+			name: "nothing available",
+			envs: map[string]string{"BITRISE_WEBHOOK_PAYLOAD_PATH": filepath.Join(t.TempDir(), "missing.json")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var log bytes.Buffer
+			saved := logger
+			logger = jsonLogger{w: &log, debug: true}
+			t.Cleanup(func() { logger = saved })
+
+			c := tt.conf
+			backfillGitInputs(&c, func(key string) string { return tt.envs[key] })
+			if c.AuthorName != tt.wantAuthor || c.Subject != tt.wantSubject {
+				t.Errorf("author, subject = %q, %q, want %q, %q", c.AuthorName, c.Subject, tt.wantAuthor, tt.wantSubject)
+			}
+			for _, source := range tt.wantSources {
+				if !strings.Contains(log.String(), source) {
+					t.Errorf("debug output %q, want %q", log.String(), source)
+				}
+			}
+		})
+	}
+}
+
+func TestBackfillReadsPayloadOnce(t *testing.T) {
+	captureLog(t)
+	reads := 0
+	payload := writePayload(t, `{"head_commit":{"message":"Subject","author":{"name":"Author"}}}`)
+	getenv := func(key string) string {
+		if key == "BITRISE_WEBHOOK_PAYLOAD_PATH" {
+			reads++
+			return payload
+		}
+		return ""
+	}
+	var c Config
+	backfillGitInputs(&c, getenv)
+	if reads != 1 {
+		t.Errorf("payload path read %d times, want once", reads)
+	}
+}
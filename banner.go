@@ -0,0 +1,107 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+const (
+	// bannerTimeout is the deadline of fetching the banner.
+	bannerTimeout = 3 * time.Second
+	// maxBannerLength is the number of characters of the banner kept in the card.
+	maxBannerLength = 500
+	// maxBannerSize is the number of bytes read from the banner source.
+	maxBannerSize = 16 * 1024
+)
+
+var (
+	htmlTagPattern = regexp.MustCompile(`<[^>]*>`)
+	controlPattern = regexp.MustCompile(`[\x00-\x08\x0b-\x1f\x7f]`)
+)
+
+// readBanner reads the banner from an http(s) URL or a file path, an unreachable source or a
+// failed response is returned as an error.
+func readBanner(client *http.Client, source string) (string, error) {
+	var r io.Reader
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := client.Get(source)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			if err := resp.Body.Close(); err != nil {
+				logger.Warnf("Failed to close response body: %s", err)
+			}
+		}()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unexpected response: %s", resp.Status)
+		}
+		r = resp.Body
+	} else {
+		b, err := ioutil.ReadFile(source)
+		if err != nil {
+			return "", err
+		}
+		r = strings.NewReader(string(b))
+	}
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxBannerSize))
+	return string(b), err
+}
+
+// sanitizeBanner removes the HTML tags and the control characters of the markdown banner,
+// converts it to the markdown subset supported by Teams and caps its length.
+func sanitizeBanner(s string) string {
+	s = htmlTagPattern.ReplaceAllString(strings.Replace(s, "\r\n", "\n", -1), "")
+	s = controlPattern.ReplaceAllString(s, "")
+	s, _ = convertMarkdown(s)
+	return truncateText(s, maxBannerLength)
+}
+
+// bannerSection fetches the banner and returns the section announcing it, or nil if the
+// banner can't be fetched or it is empty.
+func bannerSection(source string) *Section {
+	text, err := readBanner(&http.Client{Transport: transport, Timeout: bannerTimeout}, source)
+	if err != nil {
+		logger.Debugf("Banner skipped: %s\n", err)
+		return nil
+	}
+	if text = sanitizeBanner(text); text == "" {
+		logger.Debugf("Banner skipped: it is empty\n")
+		return nil
+	}
+	return &Section{Title: label(locale.SectionAnnouncement), Text: text, Formatted: true}
+}
@@ -0,0 +1,147 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"unicode/utf8"
+)
+
+// bannerServer serves the banner at /banner and counts the requests, any other path fails.
+func bannerServer(t *testing.T, banner string, requests *int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		if r.URL.Path != "/banner" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, banner)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestReadBanner(t *testing.T) {
+	var requests int32
+	srv := bannerServer(t, strings.Repeat("x", 2*maxBannerSize), &requests)
+
+	b, err := readBanner(srv.Client(), srv.URL+"/banner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != maxBannerSize {
+		t.Errorf("read %d bytes, want the %d bytes cap", len(b), maxBannerSize)
+	}
+	if _, err := readBanner(srv.Client(), srv.URL+"/down"); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("readBanner() of a failed response error = %v, want the status", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "banner.md")
+	if err := ioutil.WriteFile(path, []byte("CI migration this weekend"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := readBanner(srv.Client(), path); err != nil || b != "CI migration this weekend" {
+		t.Errorf("readBanner(file) = %q, %v", b, err)
+	}
+	if _, err := readBanner(srv.Client(), path+".missing"); err == nil {
+		t.Error("readBanner() of a missing file: expected an error")
+	}
+}
+
+func TestSanitizeBanner(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"markdown", "## CI migration\r\nthis weekend", "**CI migration**\nthis weekend"},
+		{"HTML", `<script>alert(1)</script><b>Heads up</b>`, "alert(1)Heads up"},
+		{"control characters", "Heads\x00 up\x1b!\ttoday", "Heads up!\ttoday"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeBanner(tt.in); got != tt.want {
+				t.Errorf("sanitizeBanner(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+	if got := sanitizeBanner(strings.Repeat("x", 2*maxBannerLength)); utf8.RuneCountInString(got) != maxBannerLength {
+		t.Errorf("sanitized %d characters, want the %d characters cap", utf8.RuneCountInString(got), maxBannerLength)
+	}
+}
+
+func TestBannerSection(t *testing.T) {
+	captureLog(t)
+	var requests int32
+	srv := bannerServer(t, "CI migration this weekend", &requests)
+
+	section := bannerSection(srv.URL + "/banner")
+	if section == nil || section.Text != "CI migration this weekend" || !section.Formatted {
+		t.Fatalf("bannerSection() = %+v, want the formatted banner", section)
+	}
+	if section.Title == "" {
+		t.Error("the banner section has no title")
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.md")
+	if err := ioutil.WriteFile(empty, []byte("<p></p>"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, source := range []string{srv.URL + "/down", empty} {
+		if section := bannerSection(source); section != nil {
+			t.Errorf("bannerSection(%s) = %+v, want the banner skipped", source, section)
+		}
+	}
+}
+
+func TestAddBannerFetchesOnce(t *testing.T) {
+	captureLog(t)
+	var requests int32
+	srv := bannerServer(t, "CI migration this weekend", &requests)
+	hits := webhookReplies(http.StatusOK, http.StatusOK)
+	defer hits.Close()
+
+	p := &sendPipeline{
+		conf:   Config{Title: "Build Succeeded!", BannerSource: srv.URL + "/banner"},
+		report: &RunReport{},
+		urls:   []string{hits.URL, hits.URL},
+	}
+	if code := p.run(); code != 0 {
+		t.Fatalf("run() = %d, want 0", code)
+	}
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("the banner was fetched %d times, want once per run", n)
+	}
+	if len(p.msg.Sections) == 0 || p.msg.Sections[0].Text != "CI migration this weekend" {
+		t.Errorf("sections = %+v, want the banner first", p.msg.Sections)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFormatBuildDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0s"},
+		{-5 * time.Second, "0s"},
+		{400 * time.Millisecond, "0s"},
+		{42*time.Second + 600*time.Millisecond, "43s"},
+		{time.Minute, "1m 0s"},
+		{12*time.Minute + 34*time.Second, "12m 34s"},
+		{time.Hour + 5*time.Second, "1h 0m 5s"},
+		{26*time.Hour + 3*time.Minute + 9*time.Second, "26h 3m 9s"},
+	}
+	for _, tt := range tests {
+		if got := formatBuildDuration(tt.d); got != tt.want {
+			t.Errorf("formatBuildDuration(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestDurationFact(t *testing.T) {
+	now := time.Unix(1700000754, 0)
+	if f, ok := durationFact(" 1700000000\n", now); !ok || f != (Fact{Name: "Duration", Value: "12m 34s"}) {
+		t.Errorf("durationFact() = %+v, %v, want the 12m 34s Duration fact", f, ok)
+	}
+
+	var buf bytes.Buffer
+	saved := logger
+	logger = jsonLogger{w: &buf, debug: true}
+	t.Cleanup(func() { logger = saved })
+	for _, timestamp := range []string{"", "yesterday", "1700000000.5"} {
+		buf.Reset()
+		if f, ok := durationFact(timestamp, now); ok {
+			t.Errorf("durationFact(%q) = %+v, want the fact skipped", timestamp, f)
+		}
+		if !bytes.Contains(buf.Bytes(), []byte("Duration fact omitted")) {
+			t.Errorf("durationFact(%q) logged %s, want a debug line", timestamp, buf.String())
+		}
+	}
+}
+
+func TestNewMessageBuildTime(t *testing.T) {
+	captureLog(t)
+	start := strconv.FormatInt(time.Now().Add(-90*time.Second).Unix(), 10)
+	t.Setenv("BITRISE_BUILD_TRIGGER_TIMESTAMP", "invalid")
+
+	msg, _ := newMessage(Config{ShowBuildTime: true, BuildStartTime: start, Fields: "App|Login"})
+	facts := msg.Sections[0].Facts
+	if len(facts) != 2 || facts[1].Name != "Duration" {
+		t.Fatalf("facts = %+v, want the Duration fact last", facts)
+	}
+	if v := facts[1].Value; v != "1m 30s" && v != "1m 31s" {
+		t.Errorf("duration = %q, want about 1m 30s", v)
+	}
+
+	t.Setenv("BITRISE_BUILD_TRIGGER_TIMESTAMP", start)
+	if msg, _ := newMessage(Config{ShowBuildTime: true}); len(msg.Sections[0].Facts) != 1 {
+		t.Errorf("facts = %+v, want the Duration fact of the trigger timestamp", msg.Sections[0].Facts)
+	}
+	t.Setenv("BITRISE_BUILD_TRIGGER_TIMESTAMP", "")
+	if msg, _ := newMessage(Config{ShowBuildTime: true}); len(msg.Sections[0].Facts) != 0 {
+		t.Errorf("facts = %+v, want no Duration fact without a timestamp", msg.Sections[0].Facts)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMarshalPayloadIsByteStable(t *testing.T) {
+	t.Setenv("BITRISE_APP_TITLE", "Login")
+	t.Setenv("BITRISE_BUILD_NUMBER", "42")
+	t.Setenv("BITRISE_GIT_BRANCH", "main")
+	t.Setenv("BITRISE_GIT_COMMIT", "0123456789abcdef")
+	conf := Config{
+		Title:               "Build Succeeded!",
+		AuthorName:          "Bitrise",
+		Subject:             "Fix the login\nand the logout",
+		Fields:              "Coverage|80%\nTests|1024 <passed> & 0 failed",
+		Images:              "Icon|https://example.com/icon.png",
+		Buttons:             "Build|https://app.bitrise.io/build/42\nRetry|https://relay.example.com|POST|{\"retry\":true}",
+		IncludeDefaultFacts: true,
+	}
+	for _, c := range []struct {
+		name      string
+		format    string
+		canonical bool
+	}{
+		{"messagecard", "messagecard", false},
+		{"adaptivecard", "adaptivecard", false},
+		{"canonical messagecard", "messagecard", true},
+		{"canonical adaptivecard", "adaptivecard", true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			conf := conf
+			conf.CardFormat, conf.CanonicalJSON = c.format, c.canonical
+			var first []byte
+			for i := 0; i < 100; i++ {
+				msg, errs := newMessage(conf)
+				if len(errs) > 0 {
+					t.Fatal(errs)
+				}
+				b, err := marshalPayload(conf, msg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if i == 0 {
+					first = b
+				} else if !bytes.Equal(b, first) {
+					t.Fatalf("build %d marshaled to\n%s\nwant\n%s", i+1, b, first)
+				}
+			}
+		})
+	}
+}
+
+func TestCanonicalJSON(t *testing.T) {
+	for _, c := range []struct {
+		name, in, want string
+	}{
+		{"sorted keys", `{"b":1,"a":2,"@type":3}`, `{"@type":3,"a":2,"b":1}`},
+		{"nested objects", `{"z":{"y":{"x":1,"w":2},"v":[{"u":1,"t":2}]},"a":null}`, `{"a":null,"z":{"v":[{"t":2,"u":1}],"y":{"w":2,"x":1}}}`},
+		{"array order kept", `[3,1,{"b":true,"a":false},[2,1]]`, `[3,1,{"a":false,"b":true},[2,1]]`},
+		{"numbers as written", `{"f":1.50,"e":1e3,"i":-0,"big":12345678901234567890}`, `{"big":12345678901234567890,"e":1e3,"f":1.50,"i":-0}`},
+		{"whitespace", "{\n  \"b\" : [ 1 , 2 ],\n  \"a\" : \"x y\"\n}\n", `{"a":"x y","b":[1,2]}`},
+		{"escaped as the payload", `{"t":"<b>é</b> & \"q\""}`, `{"t":"\u003cb\u003eé\u003c/b\u003e \u0026 \"q\""}`},
+		{"empty containers", `{"b":{},"a":[]}`, `{"a":[],"b":{}}`},
+		{"scalar", `"text"`, `"text"`},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := canonicalJSON([]byte(c.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != c.want {
+				t.Errorf("canonicalJSON(%q) = %s, want %s", c.in, got, c.want)
+			}
+			again, err := canonicalJSON(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(again, got) {
+				t.Errorf("canonicalJSON(%s) = %s, want it unchanged", got, again)
+			}
+		})
+	}
+}
+
+func TestCanonicalJSONInvalid(t *testing.T) {
+	for _, in := range []string{``, `{"a":`, `{"a":1}{"b":2}`, `{a:1}`} {
+		if got, err := canonicalJSON([]byte(in)); err == nil {
+			t.Errorf("canonicalJSON(%q) = %s, want an error", in, got)
+		}
+	}
+}
+
+func TestCanonicalPayloadKeepsContent(t *testing.T) {
+	msg, _ := newMessage(Config{
+		Title:   "Build Failed!",
+		Subject: "Tests failed",
+		Fields:  "Branch|main",
+		Buttons: "Build|https://app.bitrise.io/build/42",
+	})
+	for _, format := range []string{"messagecard", "adaptivecard"} {
+		t.Run(format, func(t *testing.T) {
+			plain, err := marshalPayload(Config{CardFormat: format}, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			canonical, err := marshalPayload(Config{CardFormat: format, CanonicalJSON: true}, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var want, got interface{}
+			if err := json.Unmarshal(plain, &want); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(canonical, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("canonical payload %s, want the content of %s", canonical, plain)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// capability is an external binary which some optional features of the step depend on.
+type capability struct {
+	Binary   string
+	Features string
+}
+
+var capabilities = []capability{
+	{Binary: "envman", Features: "exporting the outputs"},
+	{Binary: "sh", Features: "command substitutions in the inputs"},
+}
+
+// unavailable holds the binaries found missing by the capability probe, the features
+// depending on them are skipped silently.
+var unavailable = map[string]bool{}
+
+// missingCapabilities returns the capabilities whose binary is not found by lookPath.
+func missingCapabilities(caps []capability, lookPath func(string) (string, error)) []capability {
+	var missing []capability
+	for _, c := range caps {
+		if _, err := lookPath(c.Binary); err != nil {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// disableCapabilities marks the binaries of the capabilities unavailable and returns the
+// warning describing the disabled features.
+func disableCapabilities(caps []capability) string {
+	var parts []string
+	for _, c := range caps {
+		unavailable[c.Binary] = true
+		parts = append(parts, fmt.Sprintf("%s (%s)", c.Binary, c.Features))
+	}
+	return fmt.Sprintf("Missing binaries, the features depending on them are disabled: %s", strings.Join(parts, ", "))
+}
@@ -0,0 +1,90 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// changelogLogFormat is the git log format of the changelog: the short hash, the subject, the
+// author and the body of the commits, separated by the unit and the record separators.
+const changelogLogFormat = "--format=%h%x1f%s%x1f%an%x1f%b%x1e"
+
+// formatChangelog formats the commits of the git log output as a markdown list of their short
+// hash, subject and author, followed by their body if full is set.
+func formatChangelog(out string, full bool) string {
+	var lines []string
+	for _, record := range strings.Split(out, "\x1e") {
+		f := strings.SplitN(strings.TrimLeft(record, "\n"), "\x1f", 4)
+		if len(f) != 4 {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("- `%s` %s (%s)", f[0], markdownEscaper.Replace(f[1]), markdownEscaper.Replace(f[2])))
+		if body := strings.TrimSpace(f[3]); full && body != "" {
+			for _, line := range strings.Split(body, "\n") {
+				lines = append(lines, "  "+markdownEscaper.Replace(line))
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// changelogSection returns the section of the last count commits of the git repo in dir, the
+// working dir if it is empty. A missing git binary or a dir which is not a git repo only omits
+// the changelog with a warning.
+func changelogSection(dir string, count int, full bool, timeout time.Duration, run func(time.Duration, string, ...string) (string, error)) *Section {
+	var args []string
+	if dir != "" {
+		args = append(args, "-C", dir)
+	}
+	out, err := run(timeout, "git", append(args, "log", "-n", strconv.Itoa(count), changelogLogFormat)...)
+	if errors.Is(err, exec.ErrNotFound) {
+		logger.Warnf("git is not installed, the changelog is omitted.")
+		return nil
+	} else if err != nil {
+		logger.Warnf("Failed to read the changelog, it is omitted: %s", err)
+		return nil
+	}
+
+	text := formatChangelog(out, full)
+	if n := strings.Count(out, "\x1e"); n < count {
+		if shallow, err := run(timeout, "git", append(args, "rev-parse", "--is-shallow-repository")...); err == nil && shallow == "true" {
+			logger.Warnf("The changelog shows %d of %d commits, the clone is shallow. Increase the clone depth to show more.", n, count)
+		}
+	}
+	if text == "" {
+		return nil
+	}
+	return &Section{Title: label(locale.SectionChangelog), Text: text, Formatted: true, Excerpt: true}
+}
@@ -0,0 +1,178 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// logRecord returns the git log output of a commit in the changelog format.
+func logRecord(hash, subject, author, body string) string {
+	return hash + "\x1f" + subject + "\x1f" + author + "\x1f" + body + "\x1e\n"
+}
+
+func TestFormatChangelog(t *testing.T) {
+	out := logRecord("a1b2c3d", "Fix *the* login", "Jane_Doe", "Closes #42\n\nSee [the issue].\n") +
+		logRecord("e4f5a6b", "Add the changelog", "John", "")
+	if got, want := formatChangelog(out, false),
+		"- `a1b2c3d` Fix \\*the\\* login (Jane\\_Doe)\n- `e4f5a6b` Add the changelog (John)"; got != want {
+		t.Errorf("oneline changelog =\n%s\nwant\n%s", got, want)
+	}
+	if got, want := formatChangelog(out, true),
+		"- `a1b2c3d` Fix \\*the\\* login (Jane\\_Doe)\n  Closes \\#42\n  \n  See \\[the issue\\].\n- `e4f5a6b` Add the changelog (John)"; got != want {
+		t.Errorf("full changelog =\n%s\nwant\n%s", got, want)
+	}
+	if got := formatChangelog("", false); got != "" {
+		t.Errorf("changelog of no commits = %q, want none", got)
+	}
+}
+
+// gitRunner returns a runner of the git commands replying the outputs by their subcommand,
+// the args of the commands are recorded.
+func gitRunner(outputs map[string]string, err error, calls *[][]string) func(time.Duration, string, ...string) (string, error) {
+	return func(_ time.Duration, name string, args ...string) (string, error) {
+		*calls = append(*calls, append([]string{name}, args...))
+		if err != nil {
+			return "", err
+		}
+		for _, arg := range args {
+			if out, ok := outputs[arg]; ok {
+				return out, nil
+			}
+		}
+		return "", fmt.Errorf("unexpected command: %v", args)
+	}
+}
+
+func TestChangelogSection(t *testing.T) {
+	buf := captureLog(t)
+	var calls [][]string
+	run := gitRunner(map[string]string{"log": logRecord("a1b2c3d", "Fix; rm -rf /", "Jane", "")}, nil, &calls)
+	section := changelogSection("/src/my app", 10, false, time.Second, run)
+	if section == nil || section.Text != "- `a1b2c3d` Fix; rm -rf / (Jane)" || !section.Formatted {
+		t.Fatalf("section = %+v, want the changelog", section)
+	}
+	want := []string{"git", "-C", "/src/my app", "log", "-n", "10", changelogLogFormat}
+	if !reflect.DeepEqual(calls[0], want) {
+		t.Errorf("git args = %q, want %q", calls[0], want)
+	}
+	if strings.Contains(buf.String(), "shallow") {
+		t.Errorf("log = %s, want no shallow clone warning of a full clone", buf)
+	}
+
+	calls = nil
+	changelogSection("", 10, false, time.Second, run)
+	if calls[0][1] != "log" {
+		t.Errorf("git args = %q, want no -C without a dir", calls[0])
+	}
+}
+
+func TestChangelogSectionShallowClone(t *testing.T) {
+	buf := captureLog(t)
+	var calls [][]string
+	run := gitRunner(map[string]string{
+		"log":                     logRecord("a1b2c3d", "Fix the login", "Jane", ""),
+		"--is-shallow-repository": "true",
+	}, nil, &calls)
+	if section := changelogSection("", 10, false, time.Second, run); section == nil {
+		t.Fatal("changelogSection() = nil, want the available commits")
+	}
+	if !strings.Contains(buf.String(), "1 of 10 commits, the clone is shallow") {
+		t.Errorf("log = %s, want a shallow clone warning", buf)
+	}
+}
+
+func TestChangelogSectionOmitted(t *testing.T) {
+	tests := []struct {
+		name    string
+		outputs map[string]string
+		err     error
+		want    string
+	}{
+		{"git not installed", nil, &exec.Error{Name: "git", Err: exec.ErrNotFound}, "git is not installed"},
+		{"not a repo", nil, fmt.Errorf("command `git log` failed: exit status 128"), "Failed to read the changelog"},
+		{"no commits", map[string]string{"log": "", "--is-shallow-repository": "false"}, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf := captureLog(t)
+			var calls [][]string
+			if section := changelogSection("", 10, false, time.Second, gitRunner(tt.outputs, tt.err, &calls)); section != nil {
+				t.Errorf("section = %+v, want the changelog omitted", section)
+			}
+			if !strings.Contains(buf.String(), tt.want) {
+				t.Errorf("log = %s, want %q", buf, tt.want)
+			}
+		})
+	}
+}
+
+func TestChangelogSectionGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	buf := captureLog(t)
+	for _, env := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(env, "Jane")
+	}
+	for _, env := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(env, "jane@example.com")
+	}
+	t.Setenv("GIT_CONFIG_GLOBAL", "/dev/null")
+	repo := filepath.Join(t.TempDir(), "repo")
+	git := func(args ...string) {
+		t.Helper()
+		if _, err := execCommand(0, "git", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git("init", "-q", repo)
+	for _, subject := range []string{"First", "Second", "Third"} {
+		git("-C", repo, "commit", "-q", "--allow-empty", "-m", subject)
+	}
+	shallow := filepath.Join(t.TempDir(), "shallow")
+	git("clone", "-q", "--depth", "2", "file://"+repo, shallow)
+
+	section := changelogSection(repo, 2, false, 0, execCommand)
+	if section == nil || strings.Count(section.Text, "\n") != 1 || !strings.Contains(section.Text, " Third (Jane)") {
+		t.Errorf("section = %+v, want the last 2 commits", section)
+	}
+	section = changelogSection(shallow, 10, false, 0, execCommand)
+	if section == nil || strings.Count(section.Text, "\n") != 1 {
+		t.Errorf("section = %+v, want the 2 commits of the shallow clone", section)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("2 of 10 commits, the clone is shallow")) {
+		t.Errorf("log = %s, want a shallow clone warning", buf)
+	}
+}
@@ -0,0 +1,234 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Command fakehook is a fake Teams incoming webhook server for the end-to-end tests of the
+// step. The first segment of the request path selects the scenario, eg. POST /throttled, and
+// every posted request is recorded to the recordings dir. /ack records the acknowledgements
+// posted by the Acknowledge buttons and answers the check-ack status requests.
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const badPayloadBody = "Bad payload received by generic incoming webhook."
+
+// rejectMarker makes the strict scenario reject the card containing it.
+const rejectMarker = "fakehook-reject"
+
+type server struct {
+	dir string
+
+	mu       sync.Mutex
+	requests int
+	attempts map[string]int
+	acks     map[string]bool
+}
+
+// record writes the request body to the recordings dir and returns it.
+func (s *server) record(scenario string, r *http.Request) ([]byte, int, error) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, 0, err
+		}
+		body = gz
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	s.mu.Lock()
+	s.requests++
+	s.attempts[scenario]++
+	n, attempt := s.requests, s.attempts[scenario]
+	s.mu.Unlock()
+
+	name := filepath.Join(s.dir, fmt.Sprintf("%03d-%s.json", n, scenario))
+	if err := ioutil.WriteFile(name, b, 0600); err != nil {
+		return nil, 0, err
+	}
+	// The host is that of the webhook URL, also if the request was sent through the server as a proxy.
+	headers := fmt.Sprintf("%s %s\nHost: %s\n", r.Method, r.URL.Path, r.Host)
+	for k, vs := range r.Header {
+		headers += fmt.Sprintf("%s: %s\n", k, strings.Join(vs, ", "))
+	}
+	return b, attempt, ioutil.WriteFile(strings.TrimSuffix(name, ".json")+".headers", []byte(headers), 0600)
+}
+
+// isMessageCard reports whether the payload is a MessageCard, as accepted by the connectors.
+func isMessageCard(b []byte) bool {
+	var card struct {
+		Type string `json:"@type"`
+	}
+	return json.Unmarshal(b, &card) == nil && card.Type == "MessageCard"
+}
+
+// isAdaptiveEnvelope reports whether the payload is an Adaptive Card in a message attachment,
+// as accepted by the Workflows webhooks.
+func isAdaptiveEnvelope(b []byte) bool {
+	var msg struct {
+		Type        string `json:"type"`
+		Attachments []struct {
+			ContentType string `json:"contentType"`
+			Content     struct {
+				Type string `json:"type"`
+			} `json:"content"`
+		} `json:"attachments"`
+	}
+	if json.Unmarshal(b, &msg) != nil || msg.Type != "message" || len(msg.Attachments) == 0 {
+		return false
+	}
+	a := msg.Attachments[0]
+	return a.ContentType == "application/vnd.microsoft.card.adaptive" && a.Content.Type == "AdaptiveCard"
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	scenario := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+	if scenario == "ack" && r.Method == http.MethodGet {
+		// The acknowledgement status of a token.
+		s.mu.Lock()
+		acknowledged := s.acks[r.URL.Query().Get("token")]
+		s.mu.Unlock()
+		fmt.Fprintf(w, `{"acknowledged":%t}`, acknowledged)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	b, attempt, err := s.record(scenario, r)
+	if err != nil {
+		log.Printf("Failed to record request: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	switch scenario {
+	case "connector":
+		// An Office 365 connector: MessageCards are accepted.
+		if !isMessageCard(b) {
+			http.Error(w, badPayloadBody, http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "1")
+	case "workflows":
+		// A Workflows webhook: only Adaptive Cards in an envelope are accepted, asynchronously.
+		if !isAdaptiveEnvelope(b) {
+			http.Error(w, `{"error":{"code":"InvalidRequestContent","message":"The input body for trigger 'manual' of type 'Request' did not match its schema definition."}}`, http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case "throttled":
+		// Throttled on the first attempt, accepted afterwards.
+		if attempt == 1 {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Microsoft Teams endpoint returned HTTP error 429", http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, "1")
+	case "ack":
+		// An Acknowledge button was clicked.
+		var ack struct {
+			Token string `json:"ack_token"`
+		}
+		if err := json.Unmarshal(b, &ack); err != nil || ack.Token == "" {
+			http.Error(w, "invalid acknowledgement", http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.acks[ack.Token] = true
+		s.mu.Unlock()
+	case "strict":
+		// A connector rejecting the cards which contain the rejection marker.
+		if strings.Contains(string(b), rejectMarker) {
+			http.Error(w, badPayloadBody, http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "1")
+	case "undelivered":
+		// A connector which accepts the request but fails to deliver the message.
+		fmt.Fprint(w, "Webhook message delivery failed with error: Microsoft Teams endpoint returned HTTP error 429")
+	case "graph":
+		// The Graph API creating a channel message or a reply with an Adaptive Card attachment.
+		var msg struct {
+			Attachments []struct {
+				ContentType string `json:"contentType"`
+			} `json:"attachments"`
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			http.Error(w, `{"error":{"code":"InvalidAuthenticationToken"}}`, http.StatusUnauthorized)
+			return
+		}
+		if err := json.Unmarshal(b, &msg); err != nil || len(msg.Attachments) != 1 || msg.Attachments[0].ContentType != "application/vnd.microsoft.card.adaptive" {
+			http.Error(w, `{"error":{"code":"BadRequest"}}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"id":"%d","webUrl":"https://teams.microsoft.com/l/message/%d"}`, attempt, attempt)
+	case "slow":
+		// A slow endpoint, accepted after a second.
+		time.Sleep(time.Second)
+		fmt.Fprint(w, "1")
+	case "unavailable":
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+	case "gone":
+		http.Error(w, "Webhook not found", http.StatusNotFound)
+	case "configpage":
+		// The connector configuration page, which answers with a web page.
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, "<!DOCTYPE html><html><body>Incoming Webhook</body></html>")
+	default:
+		http.Error(w, "unknown scenario: "+scenario, http.StatusNotFound)
+	}
+}
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8088", "address to listen on")
+	dir := flag.String("dir", "fakehook-recordings", "directory of the recorded requests")
+	flag.Parse()
+
+	if err := os.MkdirAll(*dir, 0700); err != nil {
+		log.Fatalf("Failed to create recordings dir: %s", err)
+	}
+	log.Printf("Listening on %s, recording to %s", *addr, *dir)
+	log.Fatal(http.ListenAndServe(*addr, &server{dir: *dir, attempts: map[string]int{}, acks: map[string]bool{}}))
+}
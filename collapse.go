@@ -0,0 +1,55 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// detailsElementID identifies the hidden container of the details in the Adaptive Cards.
+const detailsElementID = "details"
+
+// splitLines splits the text after n lines, or after the code fence open at that line, and
+// returns the lines after it as the overflow.
+func splitLines(text string, n int) (string, string) {
+	lines := strings.Split(text, "\n")
+	if n <= 0 || len(lines) <= n {
+		return text, ""
+	}
+	cut := n
+	for cut < len(lines) && openFence(strings.Join(lines[:cut], "\n")) >= 0 {
+		cut++
+	}
+	return strings.Join(lines[:cut], "\n"), strings.TrimSpace(strings.Join(lines[cut:], "\n"))
+}
+
+// detailsSection returns the collapsed section of the overflow of the subject.
+func detailsSection(overflow string) Section {
+	return Section{Title: label(locale.SectionDetails), Text: overflow, StartGroup: true, Collapsed: true}
+}
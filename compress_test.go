@@ -0,0 +1,151 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressionSupported(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://relay.example.com/teams", true},
+		{"http://localhost:8080/hook", true},
+		{"https://prod-12.westeurope.logic.azure.com/workflows/1", true},
+		{"https://contoso.webhook.office.com/webhookb2/1", false},
+		{"https://outlook.office.com/webhook/1", false},
+		{"https://OUTLOOK.OFFICE.COM/webhook/1", false},
+		{"https://office.com/webhook/1", false},
+		{"https://notoffice.com/webhook/1", true},
+		{"https://office.com.example.com/hook", true},
+		{"://invalid", false},
+	}
+	for _, tt := range tests {
+		if got := compressionSupported(tt.url); got != tt.want {
+			t.Errorf("compressionSupported(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func gunzip(t *testing.T, b []byte) []byte {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("body is not gzip compressed: %s", err)
+	}
+	plain, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plain
+}
+
+func TestGzipBytesRoundTrip(t *testing.T) {
+	for _, in := range []string{"", `{"title":"Build Succeeded!"}`, strings.Repeat(`{"name":"Coverage","value":"80%"},`, 10000)} {
+		b, err := gzipBytes([]byte(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := gunzip(t, b); string(got) != in {
+			t.Errorf("gunzip(gzipBytes(%d bytes)) = %d bytes, want them unchanged", len(in), len(got))
+		}
+	}
+}
+
+// compressingRelay records the decompressed bodies of the requests, it rejects the compressed
+// requests with 415 if rejectGzip is set.
+type compressingRelay struct {
+	rejectGzip bool
+	encodings  []string
+	bodies     [][]byte
+}
+
+func (c *compressingRelay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := ioutil.ReadAll(r.Body)
+	encoding := r.Header.Get("Content-Encoding")
+	c.encodings = append(c.encodings, encoding)
+	if encoding == "gzip" {
+		if c.rejectGzip {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		b, _ = ioutil.ReadAll(zr)
+	}
+	c.bodies = append(c.bodies, b)
+	w.Write([]byte("1"))
+}
+
+func TestPostMessageCompression(t *testing.T) {
+	msg := Message{Title: "Build Succeeded!", Sections: []Section{{Text: strings.Repeat("All tests passed. ", 10000)}}}
+	tests := []struct {
+		name          string
+		compress      bool
+		rejectGzip    bool
+		wantEncodings []string
+	}{
+		{"disabled", false, false, []string{""}},
+		{"compressed", true, false, []string{"gzip"}},
+		{"fallback on 415", true, true, []string{"gzip", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			relay := &compressingRelay{rejectGzip: tt.rejectGzip}
+			srv := httptest.NewServer(relay)
+			defer srv.Close()
+
+			s := &Sender{Client: srv.Client(), URL: srv.URL}
+			var report RunReport
+			if err := s.postMessage(context.Background(), Config{CompressRequest: tt.compress}, msg, "", &report); err != nil {
+				t.Fatalf("postMessage() error = %v", err)
+			}
+			if strings.Join(relay.encodings, ",") != strings.Join(tt.wantEncodings, ",") {
+				t.Errorf("Content-Encoding of the requests = %q, want %q", relay.encodings, tt.wantEncodings)
+			}
+			if len(relay.bodies) != 1 || !bytes.Equal(relay.bodies[0], report.Payload) {
+				t.Fatalf("relay received %d bodies, want the payload once", len(relay.bodies))
+			}
+			if report.PayloadSize != len(report.Payload) {
+				t.Errorf("PayloadSize = %d, want the uncompressed size %d", report.PayloadSize, len(report.Payload))
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// volatilePlaceholder replaces the volatile values in the normalized message.
+const volatilePlaceholder = "{volatile}"
+
+// normalizeMessage returns a copy of the message without the correlation ID and the facts
+// named in exclude, by their name or by the locale key of the facts the step adds, eg.
+// fact.duration, compared case-insensitively, with the volatile values, eg. the
+// build URL, replaced in the texts and the buttons, and with the facts of every section sorted
+// by name.
+func normalizeMessage(msg Message, exclude, volatile []string) Message {
+	excluded := map[string]bool{strings.ToLower(label(locale.FactCorrelationID)): true}
+	for _, name := range exclude {
+		name = strings.TrimSpace(name)
+		excluded[strings.ToLower(name)] = true
+		excluded[strings.ToLower(label(name))] = true
+	}
+	var pairs []string
+	for _, v := range volatile {
+		if v != "" {
+			pairs = append(pairs, v, volatilePlaceholder)
+		}
+	}
+	replace := strings.NewReplacer(pairs...).Replace
+
+	n := msg
+	n.CorrelationID = ""
+	n.Sections = make([]Section, len(msg.Sections))
+	for i, s := range msg.Sections {
+		s.ActivityTitle, s.ActivityText, s.Text = replace(s.ActivityTitle), replace(s.ActivityText), replace(s.Text)
+		var fs []Fact
+		for _, f := range s.Facts {
+			if !excluded[strings.ToLower(strings.TrimSpace(f.Name))] {
+				f.Value = replace(f.Value)
+				fs = append(fs, f)
+			}
+		}
+		sort.SliceStable(fs, func(a, b int) bool { return fs[a].Name < fs[b].Name })
+		s.Facts = fs
+
+		as := make([]Action, len(s.Actions))
+		for j, a := range s.Actions {
+			a.Target, a.Body = replace(a.Target), replace(a.Body)
+			a.Targets = append([]Target(nil), a.Targets...)
+			for k := range a.Targets {
+				a.Targets[k].URI = replace(a.Targets[k].URI)
+			}
+			as[j] = a
+		}
+		if len(as) > 0 {
+			s.Actions = as
+		}
+		n.Sections[i] = s
+	}
+	return n
+}
+
+// contentHash returns the SHA-256 hex digest of the normalized message and its JSON form.
+func contentHash(msg Message, exclude, volatile []string) (string, []byte, error) {
+	b, err := json.MarshalIndent(normalizeMessage(msg, exclude, volatile), "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), b, nil
+}
+
+// exportContentHash exports the content hash of the message and writes its normalized form to a file.
+func exportContentHash(msg Message, exclude string, volatile []string) error {
+	hash, b, err := contentHash(msg, strings.Split(exclude, "\n"), volatile)
+	if err != nil {
+		return fmt.Errorf("failed to hash the message: %s", err)
+	}
+	if err := writeFileAtomic(outputPath("teams-message-normalized.json"), b, 0600); err != nil {
+		return fmt.Errorf("failed to write the normalized message: %s", err)
+	}
+	return exportEnv("TEAMS_MESSAGE_CONTENT_HASH", hash)
+}
@@ -0,0 +1,156 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// defaultHashExclude is the default of content_hash_exclude in step.yml.
+const defaultHashExclude = "fact.duration\nfact.build\nfact.commit"
+
+// buildMessage returns the message of a build with the default facts, the duration, the
+// correlation ID and the build button.
+func buildMessage(t *testing.T, number int, branch string) Message {
+	t.Setenv("BITRISE_APP_TITLE", "Login")
+	t.Setenv("BITRISE_BUILD_NUMBER", strconv.Itoa(number))
+	t.Setenv("BITRISE_GIT_BRANCH", branch)
+	t.Setenv("BITRISE_GIT_COMMIT", strconv.Itoa(1234567*number))
+	t.Setenv("BITRISE_BUILD_URL", "https://app.bitrise.io/build/"+strconv.Itoa(number))
+	t.Setenv("BITRISE_BUILD_TRIGGER_TIMESTAMP", strconv.Itoa(1700000000-60*number))
+	msg, _ := newMessage(Config{
+		Title:               "Build Succeeded!",
+		Subject:             "Fix the login",
+		Fields:              "Coverage|80%",
+		IncludeDefaultFacts: true,
+		ShowBuildTime:       true,
+		IncludeBuildButton:  true,
+		ShowCorrelationID:   true,
+		CorrelationID:       "id-" + strconv.Itoa(number),
+	})
+	return msg
+}
+
+func hashOf(t *testing.T, msg Message) string {
+	hash, _, err := contentHash(msg, strings.Split(defaultHashExclude, "\n"), []string{os.Getenv("BITRISE_BUILD_URL")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func TestContentHashIgnoresExcludedFields(t *testing.T) {
+	first := hashOf(t, buildMessage(t, 41, "main"))
+	second := hashOf(t, buildMessage(t, 42, "main"))
+	if first != second {
+		t.Errorf("builds differing only in excluded fields: hashes %s and %s, want them equal", first, second)
+	}
+	if other := hashOf(t, buildMessage(t, 42, "feature")); other == second {
+		t.Errorf("builds of different branches: hash %s for both, want them different", other)
+	}
+}
+
+func TestContentHashExcludesByLocaleKey(t *testing.T) {
+	for _, lang := range []string{"en", "de", "fr", "es"} {
+		t.Run(lang, func(t *testing.T) {
+			saved := language
+			language = lang
+			t.Cleanup(func() { language = saved })
+
+			first := hashOf(t, buildMessage(t, 41, "main"))
+			if second := hashOf(t, buildMessage(t, 42, "main")); first != second {
+				t.Errorf("hashes %s and %s, want them equal", first, second)
+			}
+		})
+	}
+}
+
+func TestNormalizeMessage(t *testing.T) {
+	msg := Message{CorrelationID: "id-1", Sections: []Section{{
+		ActivityText: "See https://app.bitrise.io/build/1",
+		Facts: []Fact{
+			{Name: "Coverage", Value: "80%"},
+			{Name: " duration ", Value: "3m 5s"},
+			{Name: "Branch", Value: "main"},
+			{Name: "Correlation ID", Value: "id-1"},
+		},
+		Actions: []Action{
+			{Type: "OpenUri", Name: "View Build", Targets: []Target{{OS: "default", URI: "https://app.bitrise.io/build/1"}}},
+			{Type: "HttpPOST", Name: "Retry", Target: "https://example.com/retry", Body: `{"build":"https://app.bitrise.io/build/1"}`},
+		},
+	}}}
+	want := Message{Sections: []Section{{
+		ActivityText: "See {volatile}",
+		Facts:        []Fact{{Name: "Branch", Value: "main"}, {Name: "Coverage", Value: "80%"}},
+		Actions: []Action{
+			{Type: "OpenUri", Name: "View Build", Targets: []Target{{OS: "default", URI: "{volatile}"}}},
+			{Type: "HttpPOST", Name: "Retry", Target: "https://example.com/retry", Body: `{"build":"{volatile}"}`},
+		},
+	}}}
+
+	got := normalizeMessage(msg, []string{"Duration"}, []string{"https://app.bitrise.io/build/1", ""})
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeMessage() =\n%+v\nwant\n%+v", got, want)
+	}
+	if msg.Sections[0].Actions[0].Targets[0].URI != "https://app.bitrise.io/build/1" {
+		t.Errorf("normalizeMessage() modified the message")
+	}
+}
+
+func TestExportContentHash(t *testing.T) {
+	exported := captureOutputs(t)
+	dir := t.TempDir()
+	t.Setenv("BITRISE_DEPLOY_DIR", dir)
+	msg := Message{Title: "Build Succeeded!", Sections: []Section{{Facts: []Fact{{Name: "Build", Value: "42"}}}}}
+
+	if err := exportContentHash(msg, defaultHashExclude, nil); err != nil {
+		t.Fatalf("exportContentHash() error = %v", err)
+	}
+	hash, b, err := contentHash(msg, strings.Split(defaultHashExclude, "\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := exported["TEAMS_MESSAGE_CONTENT_HASH"]; got != hash {
+		t.Errorf("TEAMS_MESSAGE_CONTENT_HASH = %q, want %q", got, hash)
+	}
+	written, err := ioutil.ReadFile(filepath.Join(dir, "teams-message-normalized.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != string(b) {
+		t.Errorf("normalized file = %s, want %s", written, b)
+	}
+	if strings.Contains(string(written), "42") {
+		t.Errorf("normalized file holds the excluded fact: %s", written)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"strings"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// defaultFactEnvs are the envs of the default facts, in the order of the facts.
+var defaultFactEnvs = []struct {
+	Label string
+	Env   string
+}{
+	{Label: locale.FactApp, Env: "BITRISE_APP_TITLE"},
+	{Label: locale.FactBuild, Env: "BITRISE_BUILD_NUMBER"},
+	{Label: locale.FactBranch, Env: "BITRISE_GIT_BRANCH"},
+	{Label: locale.FactWorkflow, Env: "BITRISE_TRIGGERED_WORKFLOW_TITLE"},
+	{Label: locale.FactCommit, Env: "BITRISE_GIT_COMMIT"},
+	{Label: locale.FactAuthor, Env: "GIT_CLONE_COMMIT_AUTHOR_NAME"},
+}
+
+// shortCommitLength is the length of the abbreviated commit hash.
+const shortCommitLength = 7
+
+// withDefaultFacts prepends the facts of the build to the user's facts. The envs which are not
+// set are omitted and the user's facts override the default facts of the same name.
+func withDefaultFacts(facts []Fact, getenv func(string) string) []Fact {
+	user := map[string]bool{}
+	for _, f := range facts {
+		user[strings.ToLower(f.Name)] = true
+	}
+
+	var fs []Fact
+	for _, d := range defaultFactEnvs {
+		name := label(d.Label)
+		value := strings.TrimSpace(getenv(d.Env))
+		if value == "" || user[strings.ToLower(name)] {
+			continue
+		}
+		if d.Env == "BITRISE_GIT_COMMIT" && len(value) > shortCommitLength {
+			value = value[:shortCommitLength]
+		}
+		fs = append(fs, Fact{Name: name, Value: value})
+	}
+	return append(fs, facts...)
+}
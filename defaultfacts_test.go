@@ -0,0 +1,162 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWithDefaultFacts(t *testing.T) {
+	all := map[string]string{
+		"BITRISE_APP_TITLE":                "Login",
+		"BITRISE_BUILD_NUMBER":             "42",
+		"BITRISE_GIT_BRANCH":               "main",
+		"BITRISE_TRIGGERED_WORKFLOW_TITLE": "primary",
+		"BITRISE_GIT_COMMIT":               "0123456789abcdef",
+		"GIT_CLONE_COMMIT_AUTHOR_NAME":     "Jane Doe",
+	}
+	tests := []struct {
+		name  string
+		envs  map[string]string
+		facts []Fact
+		want  []Fact
+	}{
+		{
+			name: "all envs set",
+			envs: all,
+			want: []Fact{
+				{Name: "App", Value: "Login"},
+				{Name: "Build", Value: "42"},
+				{Name: "Branch", Value: "main"},
+				{Name: "Workflow", Value: "primary"},
+				{Name: "Commit", Value: "0123456"},
+				{Name: "Author", Value: "Jane Doe"},
+			},
+		},
+		{
+			name: "missing and blank envs omitted",
+			envs: map[string]string{"BITRISE_BUILD_NUMBER": "42", "BITRISE_GIT_BRANCH": " \n", "GIT_CLONE_COMMIT_AUTHOR_NAME": ""},
+			want: []Fact{{Name: "Build", Value: "42"}},
+		},
+		{
+			name: "no envs",
+			envs: map[string]string{},
+			want: nil,
+		},
+		{
+			name: "short commit kept",
+			envs: map[string]string{"BITRISE_GIT_COMMIT": "abc12"},
+			want: []Fact{{Name: "Commit", Value: "abc12"}},
+		},
+		{
+			name:  "user facts appended",
+			envs:  map[string]string{"BITRISE_APP_TITLE": "Login"},
+			facts: []Fact{{Name: "Coverage", Value: "80%"}},
+			want:  []Fact{{Name: "App", Value: "Login"}, {Name: "Coverage", Value: "80%"}},
+		},
+		{
+			name:  "user facts override",
+			envs:  all,
+			facts: []Fact{{Name: "branch", Value: "release/1.2"}, {Name: "Author", Value: "Release bot"}},
+			want: []Fact{
+				{Name: "App", Value: "Login"},
+				{Name: "Build", Value: "42"},
+				{Name: "Workflow", Value: "primary"},
+				{Name: "Commit", Value: "0123456"},
+				{Name: "branch", Value: "release/1.2"},
+				{Name: "Author", Value: "Release bot"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(key string) string { return tt.envs[key] }
+			if got := withDefaultFacts(tt.facts, getenv); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withDefaultFacts(%v) = %v, want %v", tt.facts, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMessageDefaultFacts(t *testing.T) {
+	t.Setenv("BITRISE_APP_TITLE", "Login")
+	t.Setenv("BITRISE_BUILD_NUMBER", "42")
+	t.Setenv("BITRISE_GIT_BRANCH", "main")
+	t.Setenv("BITRISE_TRIGGERED_WORKFLOW_TITLE", "")
+	t.Setenv("BITRISE_GIT_COMMIT", "0123456789abcdef")
+	t.Setenv("GIT_CLONE_COMMIT_AUTHOR_NAME", "")
+	tests := []struct {
+		name    string
+		include bool
+		fields  string
+		want    []Fact
+	}{
+		{"disabled", false, "Coverage|80%", []Fact{{Name: "Coverage", Value: "80%"}}},
+		{"enabled without fields", true, "", []Fact{
+			{Name: "App", Value: "Login"},
+			{Name: "Build", Value: "42"},
+			{Name: "Branch", Value: "main"},
+			{Name: "Commit", Value: "0123456"},
+		}},
+		{"enabled with fields", true, "Branch|develop\nCoverage|80%", []Fact{
+			{Name: "App", Value: "Login"},
+			{Name: "Build", Value: "42"},
+			{Name: "Commit", Value: "0123456"},
+			{Name: "Branch", Value: "develop"},
+			{Name: "Coverage", Value: "80%"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, errs := newMessage(Config{Subject: "Fix the login", Fields: tt.fields, IncludeDefaultFacts: tt.include})
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			if got := msg.Sections[0].Facts; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("facts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultFactsLocalized(t *testing.T) {
+	saved := language
+	language = "de"
+	t.Cleanup(func() { language = saved })
+
+	getenv := func(key string) string { return map[string]string{"GIT_CLONE_COMMIT_AUTHOR_NAME": "Jane Doe"}[key] }
+	want := []Fact{{Name: "Autor", Value: "Jane Doe"}}
+	if got := withDefaultFacts(nil, getenv); !reflect.DeepEqual(got, want) {
+		t.Errorf("withDefaultFacts() = %v, want %v", got, want)
+	}
+	// The override matches the localized name.
+	user := []Fact{{Name: "Autor", Value: "Release bot"}}
+	if got := withDefaultFacts(user, getenv); !reflect.DeepEqual(got, user) {
+		t.Errorf("withDefaultFacts(%v) = %v, want %v", user, got, user)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// maxReducedVariants is the number of reduced cards tried after a rejection.
+const maxReducedVariants = 2
+
+// reduction removes a kind of optional elements from the message and returns their number.
+type reduction struct {
+	Name  string
+	Apply func(msg *Message) int
+}
+
+// reductions are applied progressively, from the least to the most important elements.
+var reductions = []reduction{
+	{Name: "buttons", Apply: func(msg *Message) int {
+		n := 0
+		for i := range msg.Sections {
+			n += len(msg.Sections[i].Actions)
+			msg.Sections[i].Actions = nil
+		}
+		return n
+	}},
+	{Name: "images", Apply: func(msg *Message) int {
+		n := 0
+		for i := range msg.Sections {
+			n += len(msg.Sections[i].Images)
+			msg.Sections[i].Images = nil
+			if msg.Sections[i].HeroImage != nil {
+				n++
+				msg.Sections[i].HeroImage = nil
+			}
+		}
+		return n
+	}},
+	{Name: "extra sections", Apply: func(msg *Message) int {
+		// The main section is the one with the activity and the facts.
+		var kept []Section
+		for _, s := range msg.Sections {
+			if s.ActivityTitle != "" || s.ActivityText != "" || len(s.Facts) > 0 {
+				kept = append(kept, s)
+			}
+		}
+		n := len(msg.Sections) - len(kept)
+		msg.Sections = kept
+		return n
+	}},
+}
+
+// reducedVariant is a copy of the message without some of its optional elements.
+type reducedVariant struct {
+	Msg     Message
+	Dropped string
+}
+
+// reducedVariants returns up to max copies of the message with the reductions applied
+// progressively, the reductions which remove nothing are skipped. The copies are annotated.
+func reducedVariants(msg Message, max int) []reducedVariant {
+	var variants []reducedVariant
+	reduced := msg
+	reduced.Sections = append([]Section(nil), msg.Sections...)
+	var dropped []string
+	for _, r := range reductions {
+		if len(variants) == max {
+			break
+		}
+		n := r.Apply(&reduced)
+		if n == 0 {
+			continue
+		}
+		dropped = append(dropped, fmt.Sprintf("%d %s", n, r.Name))
+
+		v := reduced
+		v.Sections = append([]Section(nil), reduced.Sections...)
+		// The note tells that the card was sent without some of its elements.
+		v.Title = strings.TrimSpace(msg.Title + " " + label(locale.NoteOmitted))
+		variants = append(variants, reducedVariant{Msg: v, Dropped: strings.Join(dropped, ", ")})
+	}
+	return variants
+}
@@ -0,0 +1,87 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Deprecation describes an input which has been replaced by another one.
+type Deprecation struct {
+	Old       string
+	New       string
+	Transform func(string) string
+}
+
+// deprecations lists the inputs which are still accepted for compatibility. No input of
+// step.yml has been renamed or removed so far, a renamed input is added here together with a
+// `category: Deprecated` entry of its old name in step.yml.
+var deprecations []Deprecation
+
+// applyDeprecations copies the values of the deprecated inputs in use to their replacements
+// and prints a migration hint for each of them. It fails if failOnDeprecated is set.
+func applyDeprecations(c *Config, ds []Deprecation, getenv func(string) string) error {
+	var used []string
+	for _, d := range ds {
+		value := getenv(d.Old)
+		if value == "" {
+			continue
+		}
+		if d.Transform != nil {
+			value = d.Transform(value)
+		}
+		if err := setInput(c, d.New, value); err != nil {
+			return err
+		}
+		logger.Warnf("Input `%s` is deprecated, rename it to `%s` in your bitrise.yml.", d.Old, d.New)
+		used = append(used, d.Old)
+	}
+
+	if c.FailOnDeprecated && len(used) > 0 {
+		return fmt.Errorf("deprecated inputs are in use: %s", strings.Join(used, ", "))
+	}
+	return nil
+}
+
+// setInput sets the string field of the Config tagged with the given env key.
+func setInput(c *Config, key, value string) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := strings.SplitN(t.Field(i).Tag.Get("env"), ",", 2)[0]
+		if tag != key {
+			continue
+		}
+		if v.Field(i).Kind() != reflect.String {
+			return fmt.Errorf("input %s is not a string", key)
+		}
+		v.Field(i).SetString(value)
+		return nil
+	}
+	return fmt.Errorf("unknown input: %s", key)
+}
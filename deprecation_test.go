@@ -0,0 +1,78 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyDeprecations(t *testing.T) {
+	ds := []Deprecation{
+		{Old: "message_title", New: "title"},
+		{Old: "accent_color", New: "theme_color", Transform: func(s string) string { return strings.TrimPrefix(s, "#") }},
+	}
+	tests := []struct {
+		name    string
+		envs    map[string]string
+		fail    bool
+		want    Config
+		wantErr bool
+	}{
+		{"none in use", nil, false, Config{}, false},
+		{"value copied", map[string]string{"message_title": "Nightly"}, false, Config{Title: "Nightly"}, false},
+		{"value transformed", map[string]string{"accent_color": "#ff0000"}, false, Config{ThemeColor: "ff0000"}, false},
+		{"fail on deprecated", map[string]string{"message_title": "Nightly"}, true, Config{}, true},
+		{"fail on deprecated, none in use", nil, true, Config{FailOnDeprecated: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLog(t)
+			c := Config{FailOnDeprecated: tt.fail}
+			err := applyDeprecations(&c, ds, func(key string) string { return tt.envs[key] })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyDeprecations() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && c != tt.want {
+				t.Errorf("applyDeprecations() = %+v, want %+v", c, tt.want)
+			}
+			for old := range tt.envs {
+				if !strings.Contains(logs.String(), "Input `"+old+"` is deprecated") {
+					t.Errorf("no migration hint for %s in %q", old, logs.String())
+				}
+			}
+		})
+	}
+}
+
+func TestDeprecationsTargetKnownInputs(t *testing.T) {
+	for _, d := range deprecations {
+		if err := setInput(&Config{}, d.New, ""); err != nil {
+			t.Errorf("deprecation of %s: %s", d.Old, err)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// maxDigestFailures is the number of failed builds linked by the summary of the digest.
+const maxDigestFailures = 4
+
+// digestVersion is the version of the entries written to the digest file. The entries without
+// a version hold sanitized sections, the entries of version 1 hold the sections as they were
+// built, with the flags the JSON of the card omits.
+const digestVersion = 1
+
+// digestEntry is a notification collected in the digest file, one JSON object per line.
+type digestEntry struct {
+	Version int    `json:"version,omitempty"`
+	Title   string `json:"title"`
+	Success bool   `json:"success"`
+	digestBuild
+	Sections []digestSection `json:"sections"`
+}
+
+// digestBuild identifies the build of a collected notification.
+type digestBuild struct {
+	Branch      string `json:"branch,omitempty"`
+	BuildNumber string `json:"build_number,omitempty"`
+	BuildURL    string `json:"build_url,omitempty"`
+	Duration    string `json:"duration,omitempty"`
+}
+
+// currentDigestBuild returns the build running the step, its duration is omitted if the
+// trigger timestamp is missing.
+func currentDigestBuild(getenv func(string) string, now time.Time) digestBuild {
+	b := digestBuild{
+		Branch:      getenv("BITRISE_GIT_BRANCH"),
+		BuildNumber: getenv("BITRISE_BUILD_NUMBER"),
+		BuildURL:    getenv("BITRISE_BUILD_URL"),
+	}
+	if d, err := buildAge(getenv("BITRISE_BUILD_TRIGGER_TIMESTAMP"), now); err == nil {
+		b.Duration = formatBuildDuration(d)
+	}
+	return b
+}
+
+// digestSection is a collected section and its flags.
+type digestSection struct {
+	Section
+	Formatted bool `json:"formatted,omitempty"`
+	Excerpt   bool `json:"excerpt,omitempty"`
+	// FormattedFacts are the indexes of the facts whose values are formatted.
+	FormattedFacts []int `json:"formattedFacts,omitempty"`
+}
+
+// newDigestSection returns the collected form of the section.
+func newDigestSection(s Section) digestSection {
+	d := digestSection{Section: s, Formatted: s.Formatted, Excerpt: s.Excerpt}
+	for i, f := range s.Facts {
+		if f.Formatted {
+			d.FormattedFacts = append(d.FormattedFacts, i)
+		}
+	}
+	return d
+}
+
+// section returns the collected section with its flags.
+func (d digestSection) section() Section {
+	s := d.Section
+	s.Formatted, s.Excerpt = d.Formatted, d.Excerpt
+	s.Facts = append([]Fact(nil), s.Facts...)
+	for _, i := range d.FormattedFacts {
+		if i >= 0 && i < len(s.Facts) {
+			s.Facts[i].Formatted = true
+		}
+	}
+	return s
+}
+
+// digestRecord returns the line of the digest file collecting the message. The message is
+// collected before the content policy and the sanitizing, which the step sending the digest
+// applies to every section.
+func digestRecord(msg Message, success bool, build digestBuild) ([]byte, error) {
+	e := digestEntry{Version: digestVersion, Title: msg.Title, Success: success, digestBuild: build}
+	for _, s := range msg.Sections {
+		e.Sections = append(e.Sections, newDigestSection(s))
+	}
+	return json.Marshal(e)
+}
+
+// collectDigest appends the record to the digest file instead of posting the message.
+func collectDigest(path string, record []byte) error {
+	return appendFileLocked(path, append(record, '\n'), 0600)
+}
+
+// readDigest reads the entries collected in the digest file and the number of bytes read,
+// which are dropped once the digest is sent. The corrupt lines are skipped with a warning. A
+// missing file has no entries.
+func readDigest(path string) ([]digestEntry, int, error) {
+	b, err := readFileLocked(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entries []digestEntry
+	for i, line := range bytes.Split(b, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e digestEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			logger.Warnf("Line %d of the digest file is corrupt, it is skipped: %s", i+1, err)
+			continue
+		}
+		if e.Version > digestVersion {
+			logger.Warnf("Line %d of the digest file was written by a newer version of the step, it is skipped.", i+1)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, len(b), nil
+}
+
+// appendDigest appends the sections of the entries to the message, each entry starting a group
+// titled with the title of its message.
+func appendDigest(msg *Message, entries []digestEntry) {
+	for _, e := range entries {
+		if len(e.Sections) == 0 {
+			continue
+		}
+		first := len(msg.Sections)
+		for _, d := range e.Sections {
+			s := d.section()
+			if e.Version == 0 {
+				// The entries without a version were sanitized when they were collected.
+				s.Formatted = true
+			}
+			msg.Sections = append(msg.Sections, s)
+		}
+		msg.Sections[first].StartGroup = true
+		if msg.Sections[first].Title == "" {
+			msg.Sections[first].Title = e.Title
+		}
+	}
+}
+
+// digestSummary returns the section counting the passed and the failed builds of the entries
+// by branch and linking the latest failures, or nil if there is no entry.
+func digestSummary(entries []digestEntry) *Section {
+	if len(entries) == 0 {
+		return nil
+	}
+	type counts struct{ passed, failed int }
+	byBranch := map[string]*counts{}
+	var branches []string
+	for _, e := range entries {
+		c := byBranch[e.Branch]
+		if c == nil {
+			c = &counts{}
+			byBranch[e.Branch] = c
+			branches = append(branches, e.Branch)
+		}
+		if e.Success {
+			c.passed++
+		} else {
+			c.failed++
+		}
+	}
+	sort.Strings(branches)
+
+	s := &Section{Title: label(locale.SectionDigest)}
+	for _, b := range branches {
+		c := byBranch[b]
+		s.Facts = append(s.Facts, Fact{Name: orDash(b), Value: fmt.Sprintf(label(locale.DigestCounts), c.passed, c.failed)})
+	}
+	for i := len(entries) - 1; i >= 0 && len(s.Actions) < maxDigestFailures; i-- {
+		e := entries[i]
+		if e.Success || e.BuildURL == "" {
+			continue
+		}
+		s.Actions = append(s.Actions, Action{Type: "OpenUri", Name: digestBuildName(e), Targets: []Target{{OS: "default", URI: e.BuildURL}}})
+	}
+	return s
+}
+
+// digestBuildName names the build of the entry, eg. #42 main (3m 5s).
+func digestBuildName(e digestEntry) string {
+	name := e.Title
+	if e.BuildNumber != "" {
+		name = "#" + e.BuildNumber
+	}
+	if e.Branch != "" {
+		name += " " + e.Branch
+	}
+	if e.Duration != "" {
+		name += " (" + e.Duration + ")"
+	}
+	return name
+}
+
+// clearDigest removes the sent entries from the digest file.
+func clearDigest(path string, n int) error {
+	if n == 0 {
+		return nil
+	}
+	if err := dropFilePrefixLocked(path, n); err != nil {
+		return fmt.Errorf("failed to clear the digest file: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCollectDigestParallelWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "digest.jsonl")
+	const writers = 16
+	var wg sync.WaitGroup
+	errs := make([]error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// Long sections make the records span several writes of the file.
+			msg := Message{Title: fmt.Sprintf("Build %d", i), Sections: []Section{{Text: strings.Repeat("x", 64*1024)}}}
+			record, err := digestRecord(msg, i%2 == 0, digestBuild{})
+			if err == nil {
+				err = collectDigest(path, record)
+			}
+			errs[i] = err
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("collectDigest() of writer %d error = %v", i, err)
+		}
+	}
+
+	log := captureLog(t)
+	entries, n, err := readDigest(path)
+	if err != nil {
+		t.Fatalf("readDigest() error = %v", err)
+	}
+	if len(entries) != writers {
+		t.Errorf("readDigest() read %d entries, want %d", len(entries), writers)
+	}
+	if log.Len() > 0 {
+		t.Errorf("readDigest() logged %s, want no corrupt line", log)
+	}
+	if info, err := os.Stat(path); err != nil || int64(n) != info.Size() {
+		t.Errorf("readDigest() read %d bytes, want the size of the file", n)
+	}
+	seen := map[string]bool{}
+	for _, e := range entries {
+		seen[e.Title] = true
+	}
+	if len(seen) != writers {
+		t.Errorf("readDigest() read %d distinct entries, want %d", len(seen), writers)
+	}
+}
+
+func TestReadDigestSkipsCorruptLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "digest.jsonl")
+	lines := []string{
+		`{"version":1,"title":"Unit tests","success":true,"sections":[{"activityText":"ok"}]}`,
+		`not json`,
+		``,
+		`{"version":99,"title":"From the future","sections":[]}`,
+		`{"title":"Legacy","success":false,"sections":[{"activityText":"a\\_b"}]}`,
+		`{"version":1,"title":"Partial","sec`,
+	}
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	log := captureLog(t)
+	entries, _, err := readDigest(path)
+	if err != nil {
+		t.Fatalf("readDigest() error = %v", err)
+	}
+	var titles []string
+	for _, e := range entries {
+		titles = append(titles, e.Title)
+	}
+	if want := []string{"Unit tests", "Legacy"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("readDigest() titles = %q, want %q", titles, want)
+	}
+	for _, line := range []string{"Line 2 ", "Line 4 ", "Line 6 "} {
+		if !strings.Contains(log.String(), line) {
+			t.Errorf("readDigest() did not warn about %q: %s", line, log)
+		}
+	}
+}
+
+func TestReadDigestMissingFile(t *testing.T) {
+	entries, n, err := readDigest(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if err != nil || len(entries) != 0 || n != 0 {
+		t.Errorf("readDigest() = %v, %d, %v, want no entries", entries, n, err)
+	}
+}
+
+func TestDigestRecordKeepsFlags(t *testing.T) {
+	sections := []Section{
+		{ActivityText: "a_b", Facts: []Fact{{Name: "Branch", Value: "main"}, {Name: "Coverage", Value: "**80%**", Formatted: true}}},
+		{Title: "Stack trace", Text: "```\nat Login.fix\n```", Formatted: true, Excerpt: true},
+	}
+	b, err := digestRecord(Message{Title: "UI tests", Sections: sections}, false, digestBuild{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "digest.jsonl")
+	if err := collectDigest(path, b); err != nil {
+		t.Fatal(err)
+	}
+	entries, _, err := readDigest(path)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("readDigest() = %v, %v, want one entry", entries, err)
+	}
+
+	var msg Message
+	appendDigest(&msg, entries)
+	want := append([]Section(nil), sections...)
+	want[0].StartGroup, want[0].Title = true, "UI tests"
+	if !reflect.DeepEqual(msg.Sections, want) {
+		t.Errorf("appendDigest() =\n%+v\nwant\n%+v", msg.Sections, want)
+	}
+}
+
+func TestDigestSectionsRestrictedAndEscaped(t *testing.T) {
+	captureOutputs(t)
+	dir := t.TempDir()
+	t.Setenv("BITRISE_DEPLOY_DIR", dir)
+	path := filepath.Join(dir, "digest.jsonl")
+	collected := Message{Title: "UI tests", Sections: []Section{
+		{ActivityText: "fix_login by jane@example.com"},
+		{Title: "Stack trace", Text: "```\nat Login.fix\n```", Formatted: true, Excerpt: true},
+	}}
+	b, err := digestRecord(collected, false, digestBuild{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := collectDigest(path, b); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &sendPipeline{conf: Config{
+		DryRun:         true,
+		Subject:        "Nightly",
+		DigestFile:     path,
+		DigestMode:     "send",
+		ContentPolicy:  "restricted",
+		EscapeMarkdown: true,
+	}, report: &RunReport{}}
+	if code := p.run(); code != 0 {
+		t.Fatalf("run() = %d, want 0", code)
+	}
+
+	var texts []string
+	for _, s := range p.msg.Sections {
+		texts = append(texts, s.Title+"|"+s.ActivityText+"|"+s.Text)
+	}
+	got := strings.Join(texts, "\n")
+	if !strings.Contains(got, `fix\_login by`) {
+		t.Errorf("the collected section is not escaped: %q", got)
+	}
+	for _, s := range []string{"jane@example.com", "Login.fix"} {
+		if strings.Contains(got, s) {
+			t.Errorf("the message contains %q: %q", s, got)
+		}
+	}
+}
+
+func TestDigestRecordShape(t *testing.T) {
+	build := digestBuild{Branch: "main", BuildNumber: "42", BuildURL: "https://app.bitrise.io/build/1", Duration: "3m 5s"}
+	b, err := digestRecord(Message{Title: "UI tests", Sections: []Section{{ActivityText: "ok"}}}, true, build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"version":1,"title":"UI tests","success":true,"branch":"main","build_number":"42",` +
+		`"build_url":"https://app.bitrise.io/build/1","duration":"3m 5s","sections":[{"activityText":"ok"}]}`
+	if string(b) != want {
+		t.Errorf("digestRecord() = %s, want %s", b, want)
+	}
+}
+
+func TestCurrentDigestBuild(t *testing.T) {
+	env := map[string]string{
+		"BITRISE_GIT_BRANCH":              "main",
+		"BITRISE_BUILD_NUMBER":            "42",
+		"BITRISE_BUILD_URL":               "https://app.bitrise.io/build/1",
+		"BITRISE_BUILD_TRIGGER_TIMESTAMP": "1700000000",
+	}
+	now := time.Unix(1700000185, 0)
+	want := digestBuild{Branch: "main", BuildNumber: "42", BuildURL: "https://app.bitrise.io/build/1", Duration: "3m 5s"}
+	if got := currentDigestBuild(func(k string) string { return env[k] }, now); got != want {
+		t.Errorf("currentDigestBuild() = %+v, want %+v", got, want)
+	}
+
+	delete(env, "BITRISE_BUILD_TRIGGER_TIMESTAMP")
+	if got := currentDigestBuild(func(k string) string { return env[k] }, now); got.Duration != "" {
+		t.Errorf("currentDigestBuild() Duration = %q, want it omitted", got.Duration)
+	}
+}
+
+func TestDigestSummary(t *testing.T) {
+	failed := func(number, branch string) digestEntry {
+		return digestEntry{Title: "Tests", digestBuild: digestBuild{Branch: branch, BuildNumber: number, BuildURL: "https://app.bitrise.io/build/" + number}}
+	}
+	passed := func(branch string) digestEntry {
+		return digestEntry{Title: "Tests", Success: true, digestBuild: digestBuild{Branch: branch, BuildURL: "https://app.bitrise.io/build/ok"}}
+	}
+	link := func(name, number string) Action {
+		return Action{Type: "OpenUri", Name: name, Targets: []Target{{OS: "default", URI: "https://app.bitrise.io/build/" + number}}}
+	}
+	tests := []struct {
+		name    string
+		entries []digestEntry
+		want    *Section
+	}{
+		{"no entry", nil, nil},
+		{
+			"grouped by branch",
+			[]digestEntry{passed("main"), failed("1", "feature"), passed("main"), {Title: "Lint", Success: true}},
+			&Section{Title: "Digest", Facts: []Fact{
+				{Name: "-", Value: "1 passed, 0 failed"},
+				{Name: "feature", Value: "0 passed, 1 failed"},
+				{Name: "main", Value: "2 passed, 0 failed"},
+			}, Actions: []Action{link("#1 feature", "1")}},
+		},
+		{
+			"latest failures linked",
+			[]digestEntry{failed("1", "main"), failed("2", "main"), {Title: "Lint"}, failed("3", "main"), failed("4", "main"), failed("5", "main"), failed("6", "main")},
+			&Section{Title: "Digest", Facts: []Fact{{Name: "-", Value: "0 passed, 1 failed"}, {Name: "main", Value: "0 passed, 6 failed"}}, Actions: []Action{
+				link("#6 main", "6"), link("#5 main", "5"), link("#4 main", "4"), link("#3 main", "3"),
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := digestSummary(tt.entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("digestSummary() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDigestBuildName(t *testing.T) {
+	tests := []struct {
+		build digestBuild
+		want  string
+	}{
+		{digestBuild{}, "UI tests"},
+		{digestBuild{Branch: "main"}, "UI tests main"},
+		{digestBuild{BuildNumber: "42", Branch: "main", Duration: "3m 5s"}, "#42 main (3m 5s)"},
+	}
+	for _, tt := range tests {
+		if got := digestBuildName(digestEntry{Title: "UI tests", digestBuild: tt.build}); got != tt.want {
+			t.Errorf("digestBuildName(%+v) = %q, want %q", tt.build, got, tt.want)
+		}
+	}
+}
+
+func TestEmptyDigestSendsThisBuild(t *testing.T) {
+	captureOutputs(t)
+	dir := t.TempDir()
+	t.Setenv("BITRISE_DEPLOY_DIR", dir)
+	path := filepath.Join(dir, "digest.jsonl")
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	log := captureLog(t)
+
+	p := &sendPipeline{conf: Config{DryRun: true, Subject: "Nightly", DigestFile: path, DigestMode: "send"}, report: &RunReport{}}
+	if code := p.run(); code != 0 {
+		t.Fatalf("run() = %d, want 0", code)
+	}
+	if len(p.msg.Sections) != 1 || p.msg.Sections[0].ActivityText != "Nightly" {
+		t.Errorf("Sections = %+v, want the section of this build only", p.msg.Sections)
+	}
+	if !strings.Contains(log.String(), "No notification is collected") {
+		t.Errorf("the empty digest is not warned about: %s", log)
+	}
+}
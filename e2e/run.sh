@@ -0,0 +1,302 @@
+#!/bin/bash
+# End-to-end tests of the step binary against the fake webhook server of cmd/fakehook.
+# Usage: e2e/run.sh [group...], FAKEHOOK_PORT overrides the port of the fake server.
+set -euo pipefail
+cd "$(dirname "$0")/.."
+
+tmp=$(mktemp -d)
+fake_pid=
+cleanup() {
+	if [ -n "$fake_pid" ]; then kill "$fake_pid" 2>/dev/null || true; fi
+	rm -rf "$tmp"
+}
+trap cleanup EXIT
+
+go build -o "$tmp/step" .
+go build -o "$tmp/fakehook" ./cmd/fakehook
+
+addr="127.0.0.1:${FAKEHOOK_PORT:-8088}"
+"$tmp/fakehook" -addr "$addr" -dir "$tmp/recordings" &
+fake_pid=$!
+for _ in $(seq 50); do
+	curl -s -o /dev/null "http://$addr/" && break
+	sleep 0.1
+done
+
+# envman records the exported outputs.
+mkdir "$tmp/bin"
+cat > "$tmp/bin/envman" <<'SH'
+#!/bin/sh
+# envman add --key KEY --value VALUE
+echo "$3=$5" >> "$ENVMAN_LOG"
+SH
+chmod +x "$tmp/bin/envman"
+
+# The defaults of the single-line inputs of step.yml, the multi-line ones are left empty.
+defaults=()
+while IFS= read -r line; do
+	defaults+=("$line")
+done < <(awk '
+	/^inputs:/ { inputs = 1; next }
+	/^outputs:/ { inputs = 0 }
+	inputs && /^  - [a-z_]+:/ {
+		key = $2; sub(/:$/, "", key)
+		value = $0; sub(/^  - [a-z_]+: ?/, "", value)
+		if (value == "|" || value == "|-") value = ""
+		gsub(/^"|"$/, "", value)
+		print key "=" value
+	}' step.yml)
+
+failures=0
+output=
+
+# run_step runs the step with the defaults and the given inputs, it fails the scenario if the
+# exit code of the step differs from the expected one.
+run_step() {
+	local scenario=$1 expected=$2
+	shift 2
+	export ENVMAN_LOG="$tmp/$scenario.envs"
+	: > "$ENVMAN_LOG"
+	local code=0
+	output=$(env "${defaults[@]}" PATH="$tmp/bin:$PATH" BITRISE_DEPLOY_DIR="$tmp/deploy-$scenario" \
+		BITRISE_BUILD_STATUS=0 retry_wait_seconds=0 allow_any_webhook_host=yes "$@" "$tmp/step" 2>&1) || code=$?
+	if [ "$code" != "$expected" ]; then
+		fail "$scenario" "exit code $code, expected $expected"
+	fi
+}
+
+fail() {
+	echo "FAIL $1: $2"
+	echo "$output" | sed 's/^/    /'
+	failures=$((failures + 1))
+}
+
+# recorded returns the number of requests recorded for the scenario.
+recorded() {
+	find "$tmp/recordings" -name "*-$1.json" | wc -l | tr -d ' '
+}
+
+# last_recording returns the path of the last request recorded for the scenario.
+last_recording() {
+	find "$tmp/recordings" -name "*-$1.json" | sort | tail -n 1
+}
+
+check() {
+	local scenario=$1 description=$2
+	shift 2
+	if "$@"; then
+		echo "ok   $scenario: $description"
+	else
+		fail "$scenario" "$description"
+	fi
+}
+
+# The scenarios are grouped by feature, the groups named on the command line run alone.
+
+group_delivery() {
+	run_step connector 0 webhook_url="http://$addr/connector/hook"
+	check connector "MessageCard delivered" grep -q '"@type":"MessageCard"' "$tmp"/recordings/*-connector.json
+	check connector "delivery exported" grep -q '^TEAMS_MESSAGE_SENT=true$' "$tmp/connector.envs"
+	check connector "response status exported" grep -q '^TEAMS_RESPONSE_STATUS=200$' "$tmp/connector.envs"
+	check connector "not degraded" grep -q '^TEAMS_MESSAGE_DEGRADED=false$' "$tmp/connector.envs"
+
+	run_step workflows 0 webhook_url="http://$addr/workflows/hook" card_format=adaptivecard
+	check workflows "Adaptive Card accepted" grep -q '"type":"AdaptiveCard"' "$tmp"/recordings/*-workflows.json
+
+	run_step workflows-messagecard 1 webhook_url="http://$addr/workflows/hook"
+	check workflows-messagecard "MessageCard rejected" grep -q 'did not match its schema' <<<"$output"
+
+	run_step configpage 1 webhook_url="http://$addr/configpage/hook"
+	check configpage "web page detected" grep -q 'web page' <<<"$output"
+
+	run_step slack 1 webhook_url="https://hooks.slack.com/services/T0/B0/x" allow_any_webhook_host=no
+	check slack "Slack webhook rejected" grep -q 'is a Slack webhook (hooks.slack.com)' <<<"$output"
+}
+
+group_requests() {
+	run_step correlation 0 webhook_url="http://$addr/connector/hook" correlation_id=e2e-42
+	check correlation "header sent" grep -q '^X-Correlation-Id: e2e-42$' "$(find "$tmp/recordings" -name '*-connector.headers' | sort | tail -n 1)"
+
+	run_step proxy 0 webhook_url="http://teams.invalid/connector/hook" proxy_url="http://$addr"
+	check proxy "sent through the proxy" grep -q '^Host: teams.invalid$' "$(find "$tmp/recordings" -name '*-connector.headers' | sort | tail -n 1)"
+
+	run_step headers 0 webhook_url="http://$addr/connector/hook" request_headers="Authorization: Bearer e2e-token
+X-Request-Source: bitrise"
+	check headers "authorization sent" grep -q '^Authorization: Bearer e2e-token$' "$(find "$tmp/recordings" -name '*-connector.headers' | sort | tail -n 1)"
+	check headers "custom header sent" grep -q '^X-Request-Source: bitrise$' "$(find "$tmp/recordings" -name '*-connector.headers' | sort | tail -n 1)"
+
+	run_step signed 0 webhook_url="http://$addr/connector/hook" signing_secret=e2e-secret
+	local recording signature
+	recording=$(find "$tmp/recordings" -name '*-connector.json' | sort | tail -n 1)
+	signature=$(openssl dgst -sha256 -hmac e2e-secret -r <"$recording" | cut -d ' ' -f 1)
+	check signed "signature matches the body" grep -q "^X-Signature: $signature\$" "${recording%.json}.headers"
+
+	run_step troubleshooting 0 webhook_url="http://$addr/connector/hook"
+	check troubleshooting "request dumped" grep -q '"@type":"MessageCard"' "$tmp/deploy-troubleshooting/teams-step/request-1.json"
+	check troubleshooting "response dumped" grep -q '^HTTP/1.1 200 OK$' "$tmp/deploy-troubleshooting/teams-step/response-1.txt"
+	check troubleshooting "webhook URL redacted" test -z "$(grep -rl '/connector/hook' "$tmp/deploy-troubleshooting/teams-step")"
+}
+
+group_retries() {
+	run_step throttled 0 webhook_url="http://$addr/throttled/hook" retry_count=2
+	check throttled "retried after 429" [ "$(recorded throttled)" = 2 ]
+
+	run_step unavailable 1 webhook_url="http://$addr/unavailable/hook" retry_count=2
+	check unavailable "not retried without an idempotency key" [ "$(recorded unavailable)" = 1 ]
+
+	run_step unavailable-idempotent 1 webhook_url="http://$addr/unavailable/hook" retry_count=2 idempotency_key_header=Idempotency-Key
+	check unavailable-idempotent "every attempt made" [ "$(recorded unavailable)" = 4 ]
+
+	run_step undelivered 1 webhook_url="http://$addr/undelivered/hook" retry_count=0
+	check undelivered "not reported as sent" grep -q '^TEAMS_MESSAGE_SENT=false$' "$tmp/undelivered.envs"
+
+	run_step soft-fail 0 webhook_url="http://$addr/unavailable/hook" retry_count=0 fail_on_error=no
+	check soft-fail "not reported as sent" grep -q '^TEAMS_MESSAGE_SENT=false$' "$tmp/soft-fail.envs"
+
+	run_step gone 1 webhook_url="http://$addr/gone/hook" retry_count=2
+	check gone "not retried" [ "$(recorded gone)" = 1 ]
+	check gone "failure exported" grep -q '^TEAMS_MESSAGE_SENT=false$' "$tmp/gone.envs"
+	check gone "response status exported" grep -q '^TEAMS_RESPONSE_STATUS=404$' "$tmp/gone.envs"
+
+	run_step degrade 0 webhook_url="http://$addr/strict/hook" degrade_on_rejection=yes idempotency_key_header=Idempotency-Key \
+		buttons="Broken|https://example.com/fakehook-reject" images="Logo|https://example.com/fakehook-reject.png"
+	check degrade "two reduced cards tried" [ "$(recorded strict)" = 3 ]
+	check degrade "omission noted" grep -q 'some elements omitted' "$(last_recording strict)"
+	check degrade "dropped elements logged" grep -q 'sent without 1 buttons, 1 images' <<<"$output"
+	check degrade "degradation exported" grep -q '^TEAMS_MESSAGE_DEGRADED=true$' "$tmp/degrade.envs"
+	check degrade "key of every card differs" [ "$(cat "$tmp"/recordings/*-strict.headers | grep '^Idempotency-Key:' | sort -u | wc -l | tr -d ' ')" = 3 ]
+
+	openssl genpkey -algorithm ed25519 -out "$tmp/signing.pem" 2>/dev/null
+	run_step degrade-signed 0 webhook_url="http://$addr/strict/hook" degrade_on_rejection=yes \
+		payload_signing_key_path="$tmp/signing.pem" buttons="Broken|https://example.com/fakehook-reject"
+	check degrade-signed "reduced card archived" cmp -s "$tmp/deploy-degrade-signed/teams-message-payload.json" "$(last_recording strict)"
+
+	run_step degrade-off 1 webhook_url="http://$addr/strict/hook" buttons="Broken|https://example.com/fakehook-reject"
+}
+
+group_targets() {
+	run_step partial 0 webhook_url="http://$addr/connector/hook | http://$addr/gone/hook"
+	check partial "sent to the working webhook" grep -q 'webhook 1 (http://127.0.0.1:[0-9]*): sent' <<<"$output"
+	check partial "failure summarized" grep -q 'webhook 2 (http://127.0.0.1:[0-9]*): failed' <<<"$output"
+
+	run_step partial-fatal 1 webhook_url="http://$addr/connector/hook
+http://$addr/gone/hook" fail_on_partial_error=yes
+
+	local gone
+	gone=$(recorded gone)
+	run_step on-error 0 webhook_url="http://$addr/gone/hook" webhook_url_on_error="http://$addr/connector/hook" BITRISE_BUILD_STATUS=1
+	check on-error "webhook_url_on_error used" [ "$(recorded gone)" = "$gone" ]
+
+	local slow="http://$addr/slow/hook" start
+	start=$SECONDS
+	run_step parallel 0 webhook_url="$slow | $slow | $slow"
+	check parallel "webhooks posted in parallel" [ $((SECONDS - start)) -lt 3 ]
+	check parallel "delivery summary" grep -q 'webhook 3 (http://127.0.0.1:[0-9]*): sent, attempts: 1' <<<"$output"
+
+	start=$SECONDS
+	run_step serial 0 webhook_url="$slow | $slow | $slow" max_parallel_requests=1
+	check serial "webhooks posted one after the other" [ $((SECONDS - start)) -ge 3 ]
+
+	run_step fail-fast 1 webhook_url="http://$addr/gone/hook | http://$addr/fail-fast/hook" max_parallel_requests=1 fail_fast=yes
+	check fail-fast "remaining webhook cancelled" [ "$(recorded fail-fast)" = 0 ]
+	check fail-fast "cancellation reported" grep -q 'webhook 2 (http://127.0.0.1:[0-9]*): cancelled' <<<"$output"
+}
+
+group_ack() {
+	run_step ack-card 0 webhook_url="http://$addr/connector/hook" ack_url="http://$addr/ack" BITRISE_BUILD_STATUS=1
+	local token
+	token=$(sed -n 's/^TEAMS_ACK_TOKEN=//p' "$tmp/ack-card.envs")
+	check ack-card "token exported" [ -n "$token" ]
+	check ack-card "token embedded" grep -q "$token" "$(last_recording connector)"
+
+	run_step ack-pending 0 operation=check-ack ack_status_url="http://$addr/ack" ack_token="$token"
+	check ack-pending "unacknowledged exported" grep -q '^TEAMS_ACK_STATUS=unacknowledged$' "$tmp/ack-pending.envs"
+
+	curl -s -o /dev/null -d "{\"ack_token\":\"$token\"}" "http://$addr/ack"
+	run_step ack-done 0 operation=check-ack ack_status_url="http://$addr/ack" ack_token="$token"
+	check ack-done "acknowledged exported" grep -q '^TEAMS_ACK_STATUS=acknowledged$' "$tmp/ack-done.envs"
+}
+
+group_operations() {
+	run_step probe 0 webhook_url="http://$addr/connector/hook" operation=probe
+	check probe "no card posted" grep -q '{}' "$(last_recording connector)"
+	check probe "healthy exported" grep -q '^TEAMS_WEBHOOK_HEALTH=healthy$' "$tmp/probe.envs"
+
+	run_step probe-gone 1 webhook_url="http://$addr/gone/hook" operation=probe
+	check probe-gone "missing exported" grep -q '^TEAMS_WEBHOOK_HEALTH=missing$' "$tmp/probe-gone.envs"
+
+	run_step selftest 0 webhook_url="http://$addr/connector/hook" operation=selftest title="Not sent"
+	check selftest "canned card posted" grep -q '"title":"Bitrise Teams step connectivity test"' "$(last_recording connector)"
+	check selftest "status and round trip logged" grep -q 'passed: status 200, round trip' <<<"$output"
+
+	run_step selftest-workflows 0 webhook_url="http://$addr/workflows/hook" operation=selftest card_format=adaptivecard
+
+	run_step selftest-gone 1 webhook_url="http://$addr/gone/hook" operation=selftest retry_count=0
+	check selftest-gone "failure logged" grep -q 'Selftest of .* failed: server error: 404' <<<"$output"
+}
+
+group_graph() {
+	run_step graph 0 delivery_method=graph graph_api_url="http://$addr/graph" graph_access_token=token team_id=team channel_id="19:channel@thread.tacv2"
+	check graph "message id exported" grep -q '^TEAMS_MESSAGE_ID=1$' "$tmp/graph.envs"
+	check graph "message link exported" grep -q '^TEAMS_MESSAGE_LINK=https://teams.microsoft.com/l/message/1$' "$tmp/graph.envs"
+
+	run_step graph-reply 0 delivery_method=graph graph_api_url="http://$addr/graph" graph_access_token=token team_id=team channel_id="19:channel@thread.tacv2" reply_to_message_id=1
+	check graph-reply "posted as a reply" grep -q '^POST /graph/teams/team/channels/19:channel@thread.tacv2/messages/1/replies$' "$(find "$tmp/recordings" -name '*-graph.headers' | sort | tail -n 1)"
+}
+
+group_content() {
+	local before
+	before=$(recorded connector)
+	run_step digest-collect 0 webhook_url="http://$addr/connector/hook" digest_file="$tmp/digest.jsonl" title="Unit tests"
+	run_step digest-collect 0 webhook_url="http://$addr/connector/hook" digest_file="$tmp/digest.jsonl" title="UI tests" enable_title_prefix=yes
+	check digest-collect "nothing posted" test "$(recorded connector)" = "$before"
+	run_step digest-send 0 webhook_url="http://$addr/connector/hook" digest_file="$tmp/digest.jsonl" digest_mode=send title="Tests"
+	check digest-send "collected messages posted" grep -q '"title":"✅ UI tests"' "$(last_recording connector)"
+	check digest-send "digest file cleared" test ! -s "$tmp/digest.jsonl"
+
+	run_step quality-gate 0 webhook_url="http://$addr/connector/hook" quality_gate="Coverage|>=|80" max_facts_per_section=1 fields="Coverage|90"
+	check quality-gate "verdict posted" grep -q '"name":"Quality gate","value":"PASSED"' "$(last_recording connector)"
+	check quality-gate "verdict split like the other facts" test -z "$(grep -o '"facts":\[[^]]*\]' "$(last_recording connector)" | grep '},{')"
+
+	before=$(recorded connector)
+	run_step stale-skip 0 webhook_url="http://$addr/connector/hook" max_build_age_minutes=60 on_stale_build=skip \
+		BITRISE_BUILD_TRIGGER_TIMESTAMP=$(($(date +%s) - 7200))
+	check stale-skip "nothing posted" test "$(recorded connector)" = "$before"
+	check stale-skip "skipped status exported" grep -q '^TEAMS_MESSAGE_STATUS=skipped$' "$tmp/stale-skip.envs"
+
+	run_step title-default 0 webhook_url="http://$addr/connector/hook"
+	check title-default "no prefix by default" grep -q '"title":"Build Succeeded!"' "$(last_recording connector)"
+
+	run_step title-prefix 0 webhook_url="http://$addr/connector/hook" enable_title_prefix=yes BITRISE_BUILD_STATUS=1
+	check title-prefix "failure prefix" grep -q '"title":"❌ Build Failed!"' "$(last_recording connector)"
+
+	run_step title-prefix-once 0 webhook_url="http://$addr/connector/hook" enable_title_prefix=yes title="✅ Tests passed"
+	check title-prefix-once "prefix not repeated" grep -q '"title":"✅ Tests passed"' "$(last_recording connector)"
+}
+
+group_logging() {
+	run_step json-log 0 webhook_url="http://$addr/connector/hook" log_format=json
+	check json-log "every line is a JSON object" test -z "$(grep -v '^{.*}$' <<<"$output")"
+	check json-log "attempt logged" grep -q '"msg":"Attempt","payload_size":[0-9]*,"response_status":200' <<<"$output"
+	check json-log "secrets masked" grep -q '"webhook_url":"\*\*\*\*\*"' <<<"$output"
+}
+
+all=(delivery requests retries targets ack operations graph content logging)
+groups=("${all[@]}")
+if [ $# -gt 0 ]; then
+	groups=("$@")
+fi
+for g in "${groups[@]}"; do
+	if ! declare -F "group_$g" >/dev/null; then
+		echo "Unknown group: $g, the groups are: ${all[*]}"
+		exit 2
+	fi
+	echo "== $g"
+	"group_$g"
+done
+
+if [ "$failures" -gt 0 ]; then
+	echo "$failures check(s) failed"
+	exit 1
+fi
+echo "All end-to-end checks passed"
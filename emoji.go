@@ -0,0 +1,195 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	zeroWidthJoiner      = '\u200d'
+	variationSelector15  = '\ufe0e'
+	variationSelector16  = '\ufe0f'
+	combiningKeycap      = '\u20e3'
+	skinToneFirst        = '\U0001F3FB'
+	skinToneLast         = '\U0001F3FF'
+	regionalIndicatorMin = '\U0001F1E6'
+	regionalIndicatorMax = '\U0001F1FF'
+	tagFirst             = '\U000E0020'
+	tagLast              = '\U000E007F'
+)
+
+// emojiRanges are the code point ranges of the pictographic emoji, symbols like © which
+// are rendered as text by default are not included.
+var emojiRanges = [][2]rune{
+	{0x203C, 0x203C}, {0x2049, 0x2049}, {0x2139, 0x2139}, {0x2194, 0x21AA}, {0x231A, 0x23FF},
+	{0x24C2, 0x24C2}, {0x25AA, 0x25FE}, {0x2600, 0x27BF}, {0x2934, 0x2935},
+	{0x2B05, 0x2B55}, {0x3030, 0x3030}, {0x303D, 0x303D}, {0x3297, 0x3299},
+	{0x1F000, 0x1F1E5}, {0x1F200, 0x1FAFF},
+}
+
+func isPictographic(r rune) bool {
+	for _, rg := range emojiRanges {
+		if r >= rg[0] && r <= rg[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool { return r >= regionalIndicatorMin && r <= regionalIndicatorMax }
+
+func isSkinTone(r rune) bool { return r >= skinToneFirst && r <= skinToneLast }
+
+func isTag(r rune) bool { return r >= tagFirst && r <= tagLast }
+
+func isKeycapBase(r rune) bool { return r == '#' || r == '*' || (r >= '0' && r <= '9') }
+
+// grapheme is a user-perceived character of a text.
+type grapheme struct {
+	Runes []rune
+	Emoji bool
+}
+
+// emojiLength returns the number of runes of the emoji sequence at the start of rs, or 0.
+// It covers flags, keycaps, modifier, tag and zero width joiner sequences.
+func emojiLength(rs []rune) int {
+	if len(rs) == 0 {
+		return 0
+	}
+	if isRegionalIndicator(rs[0]) {
+		if len(rs) > 1 && isRegionalIndicator(rs[1]) {
+			return 2
+		}
+		return 1
+	}
+	if isKeycapBase(rs[0]) {
+		n := 1
+		if n < len(rs) && rs[n] == variationSelector16 {
+			n++
+		}
+		if n < len(rs) && rs[n] == combiningKeycap {
+			return n + 1
+		}
+		return 0
+	}
+	if !isPictographic(rs[0]) {
+		return 0
+	}
+
+	n := 1
+	for n < len(rs) {
+		switch r := rs[n]; {
+		case r == variationSelector15, r == variationSelector16, isSkinTone(r), isTag(r):
+			n++
+		case r == zeroWidthJoiner && n+1 < len(rs) && isPictographic(rs[n+1]):
+			n += 2
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// segmentGraphemes splits s into emoji sequences and single other characters.
+func segmentGraphemes(s string) []grapheme {
+	rs := []rune(s)
+	var gs []grapheme
+	for i := 0; i < len(rs); {
+		if n := emojiLength(rs[i:]); n > 0 {
+			gs = append(gs, grapheme{Runes: rs[i : i+n], Emoji: true})
+			i += n
+			continue
+		}
+		gs = append(gs, grapheme{Runes: rs[i : i+1]})
+		i++
+	}
+	return gs
+}
+
+// baseEmoji returns the first emoji of a sequence without its modifiers.
+func baseEmoji(g grapheme) string {
+	if isRegionalIndicator(g.Runes[0]) || isKeycapBase(g.Runes[0]) {
+		return string(g.Runes)
+	}
+	base := string(g.Runes[0])
+	if len(g.Runes) > 1 && g.Runes[1] == variationSelector16 {
+		base += string(variationSelector16)
+	}
+	return base
+}
+
+// applyEmojiCompatibility rewrites the emoji of s for the given compatibility level:
+// "basic" keeps only the base emoji of zero width joiner and modifier sequences,
+// "strip" removes the emoji, any other level keeps s unchanged.
+func applyEmojiCompatibility(s, level string) string {
+	if level != "basic" && level != "strip" {
+		return s
+	}
+	var b strings.Builder
+	for _, g := range segmentGraphemes(s) {
+		switch {
+		case !g.Emoji:
+			b.WriteString(string(g.Runes))
+		case level == "basic":
+			b.WriteString(baseEmoji(g))
+		}
+	}
+	if level == "strip" {
+		return strings.Join(strings.Fields(b.String()), " ")
+	}
+	return b.String()
+}
+
+// applyEmojiCompatibilityToMessage rewrites the emoji of the title and of the fact names.
+func applyEmojiCompatibilityToMessage(msg *Message, level string) {
+	msg.Title = applyEmojiCompatibility(msg.Title, level)
+	for i := range msg.Sections {
+		for j := range msg.Sections[i].Facts {
+			f := &msg.Sections[i].Facts[j]
+			f.Name = applyEmojiCompatibility(f.Name, level)
+		}
+	}
+}
+
+// truncateGraphemes shortens s to at most n runes ending with an ellipsis like truncateText,
+// but never cuts an emoji sequence apart.
+func truncateGraphemes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	var b strings.Builder
+	length := 0
+	for _, g := range segmentGraphemes(s) {
+		if length+len(g.Runes) > n-1 {
+			break
+		}
+		b.WriteString(string(g.Runes))
+		length += len(g.Runes)
+	}
+	return b.String() + "…"
+}
@@ -0,0 +1,136 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const (
+	family       = "👨‍👩‍👧"
+	thumbsUp     = "👍🏽"
+	germanFlag   = "🇩🇪"
+	englandFlag  = "🏴\U000E0067\U000E0062\U000E0065\U000E006E\U000E0067\U000E007F"
+	keycapOne    = "1️⃣"
+	redHeart     = "❤️"
+	technologist = "🧑🏽‍💻"
+)
+
+func TestSegmentGraphemes(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{"text", "ok ©", []string{"o", "k", " ", "©"}},
+		{"flag", germanFlag + "!", []string{germanFlag, "!"}},
+		{"two flags", germanFlag + "🇫🇷", []string{germanFlag, "🇫🇷"}},
+		{"lone regional indicator", "🇩x", []string{"🇩", "x"}},
+		{"tag flag", englandFlag, []string{englandFlag}},
+		{"ZWJ family", "a" + family + "b", []string{"a", family, "b"}},
+		{"skin tone", thumbsUp + thumbsUp, []string{thumbsUp, thumbsUp}},
+		{"modifier and ZWJ", technologist, []string{technologist}},
+		{"keycap", keycapOne + "1", []string{keycapOne, "1"}},
+		{"variation selector", redHeart + "✅", []string{redHeart, "✅"}},
+		{"trailing joiner", "👨‍", []string{"👨", "‍"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, g := range segmentGraphemes(tt.in) {
+				got = append(got, string(g.Runes))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("segmentGraphemes(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyEmojiCompatibility(t *testing.T) {
+	tests := []struct {
+		in, level, want string
+	}{
+		{"Build " + family + " " + thumbsUp, "full", "Build " + family + " " + thumbsUp},
+		{"Build " + family + " " + thumbsUp, "", "Build " + family + " " + thumbsUp},
+		{"Build " + family, "basic", "Build 👨"},
+		{"Build " + thumbsUp, "basic", "Build 👍"},
+		{technologist + " Release", "basic", "🧑 Release"},
+		{germanFlag + " " + englandFlag, "basic", germanFlag + " 🏴"},
+		{keycapOne + " " + redHeart, "basic", keycapOne + " " + redHeart},
+		{"✅ Build passed " + thumbsUp, "strip", "Build passed"},
+		{"Build " + family + " and " + germanFlag + " done", "strip", "Build and done"},
+		{keycapOne + " Build 1 ©", "strip", "Build 1 ©"},
+		{"No emoji", "strip", "No emoji"},
+	}
+	for _, tt := range tests {
+		if got := applyEmojiCompatibility(tt.in, tt.level); got != tt.want {
+			t.Errorf("applyEmojiCompatibility(%q, %q) = %q, want %q", tt.in, tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestApplyEmojiCompatibilityToMessage(t *testing.T) {
+	msg := Message{
+		Title: "✅ Build Succeeded!",
+		Sections: []Section{
+			{ActivityText: "Shipped " + thumbsUp, Facts: []Fact{{Name: "🚀 Release", Value: "1.2 " + family}}},
+			{Facts: []Fact{{Name: "Team " + family, Value: thumbsUp}}},
+		},
+	}
+	applyEmojiCompatibilityToMessage(&msg, "strip")
+	want := Message{
+		Title: "Build Succeeded!",
+		Sections: []Section{
+			{ActivityText: "Shipped " + thumbsUp, Facts: []Fact{{Name: "Release", Value: "1.2 " + family}}},
+			{Facts: []Fact{{Name: "Team", Value: thumbsUp}}},
+		},
+	}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("applyEmojiCompatibilityToMessage() = %+v, want %+v", msg, want)
+	}
+}
+
+func TestTruncateGraphemes(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"Build", 5, "Build"},
+		{"Build passed", 6, "Build…"},
+		{"Hi " + family, 5, "Hi …"},
+		{"Hi " + family + "!!", 9, "Hi " + family + "…"},
+		{germanFlag + germanFlag, 3, germanFlag + "…"},
+	}
+	for _, tt := range tests {
+		if got := truncateGraphemes(tt.in, tt.n); got != tt.want {
+			t.Errorf("truncateGraphemes(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errHTMLResponse is returned if the webhook URL points to a web page instead of a webhook.
+var errHTMLResponse = errors.New("the server responded with a web page instead of accepting the message: " +
+	"the webhook URL is probably the address of the connector configuration page, " +
+	"copy the URL shown after creating the Incoming Webhook connector instead")
+
+// isHTMLResponse reports whether a successful response is a web page.
+func isHTMLResponse(contentType string, body []byte) bool {
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), "text/html") {
+		return true
+	}
+	b := bytes.ToLower(bytes.TrimSpace(body))
+	return bytes.HasPrefix(b, []byte("<html")) || bytes.HasPrefix(b, []byte("<!doctype html"))
+}
+
+// failedDeliveryPhrases are found in the bodies of the 200 responses of the connectors which
+// accepted the request but failed to deliver the message.
+var failedDeliveryPhrases = []string{
+	"Webhook message delivery failed",
+	"Microsoft Teams endpoint returned HTTP error",
+}
+
+// failedDelivery returns the error of a successful response whose body reports that the message
+// could not be delivered, or nil.
+func failedDelivery(body []byte) error {
+	for _, phrase := range failedDeliveryPhrases {
+		if bytes.Contains(body, []byte(phrase)) {
+			return fmt.Errorf("the webhook accepted the request but failed to deliver the message: %s", bytes.TrimSpace(body))
+		}
+	}
+	return nil
+}
+
+// badPayloadBody is returned by the connector when it can't interpret the card.
+const badPayloadBody = "Bad payload received by generic incoming webhook."
+
+// translateError turns a failed response into an error with a hint about the likely cause.
+func translateError(status string, body []byte) error {
+	switch {
+	case strings.Contains(string(body), badPayloadBody):
+		return fmt.Errorf("server error: %s, the webhook could not interpret the card: "+
+			"Workflows (Power Automate) webhooks only accept Adaptive Cards, set card_format to adaptivecard for them, "+
+			"otherwise check that the card contains no unsupported properties", status)
+	default:
+		return fmt.Errorf("server error: %s, response: %s", status, body)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readFixture(t *testing.T, elem ...string) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join(append([]string{"testdata"}, elem...)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestIsHTMLResponse(t *testing.T) {
+	connectorPage := readFixture(t, "html", "connector-configuration.html")
+	signIn := readFixture(t, "html", "sign-in.html")
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		want        bool
+	}{
+		{"connector page", "text/html; charset=utf-8", connectorPage, true},
+		{"connector page sniffed", "", connectorPage, true},
+		{"sign in page sniffed", "application/octet-stream", signIn, true},
+		{"content type only", " TEXT/HTML ", []byte("1"), true},
+		{"connector success", "text/plain; charset=utf-8", []byte("1"), false},
+		{"empty", "", nil, false},
+		{"JSON", "application/json", []byte(`{"html":"<html>"}`), false},
+		{"HTML fragment", "", []byte("<p>Hello</p>"), false},
+	}
+	for _, tt := range tests {
+		if got := isHTMLResponse(tt.contentType, tt.body); got != tt.want {
+			t.Errorf("isHTMLResponse(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckWebhookURLConnectorPage(t *testing.T) {
+	for _, u := range []string{
+		"https://outlook.office.com/connectors/Configure?groupId=1",
+		"https://OUTLOOK.OFFICE.COM/Connector/IncomingWebhook",
+		"https://contoso.webhook.office.com/connectors/configure",
+	} {
+		for _, anyHost := range []bool{false, true} {
+			err := checkWebhookURL(u, anyHost)
+			if err == nil || !strings.Contains(err.Error(), "connector configuration page") {
+				t.Errorf("checkWebhookURL(%q, %v) = %v, want the connector configuration page hint", u, anyHost, err)
+			}
+		}
+	}
+	if err := checkWebhookURL("https://outlook.office.com/webhook/a@b/IncomingWebhook/c/d", false); err != nil {
+		t.Errorf("checkWebhookURL(outlook webhook) = %v, want nil", err)
+	}
+}
+
+func TestPostMessageHTMLResponse(t *testing.T) {
+	page := readFixture(t, "html", "connector-configuration.html")
+	tests := []struct {
+		name        string
+		contentType string
+		status      int
+		wantErr     error
+	}{
+		{"HTML page", "text/html; charset=utf-8", http.StatusOK, errHTMLResponse},
+		{"sniffed page", "text/plain", http.StatusOK, errHTMLResponse},
+		{"accepted by Workflows", "text/html", http.StatusAccepted, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write(page)
+			}))
+			defer srv.Close()
+
+			s := &Sender{Client: srv.Client(), URL: srv.URL}
+			err := s.postMessage(context.Background(), Config{}, Message{Title: "Build Succeeded!"}, "", &RunReport{})
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("postMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil && isTransient(err) {
+				t.Errorf("postMessage() error = %v is transient, want it permanent", err)
+			}
+		})
+	}
+}
+
+func TestTranslateError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		body   string
+		want   []string
+	}{
+		{"bad payload", "400 Bad Request", "Bad payload received by generic incoming webhook.", []string{"400 Bad Request", "set card_format to adaptivecard"}},
+		{"bad payload in a longer body", "400 Bad Request", "Error: Bad payload received by generic incoming webhook. (id 1)", []string{"could not interpret the card"}},
+		{"other error", "404 Not Found", "Webhook not found", []string{"server error: 404 Not Found, response: Webhook not found"}},
+		{"empty body", "500 Internal Server Error", "", []string{"server error: 500 Internal Server Error, response: "}},
+	}
+	for _, tt := range tests {
+		err := translateError(tt.status, []byte(tt.body))
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("translateError(%s) = %q, want %q in it", tt.name, err, want)
+			}
+		}
+	}
+}
+
+func TestFailedDelivery(t *testing.T) {
+	tests := []struct {
+		body    string
+		wantErr bool
+	}{
+		{"1", false},
+		{"", false},
+		{`{"id":"1"}`, false},
+		{"Webhook message delivery failed with error: Microsoft Teams endpoint returned HTTP error 504", true},
+		{"  Microsoft Teams endpoint returned HTTP error 413 with ContextId tcid=0\n", true},
+		{"webhook message delivery failed", false},
+	}
+	for _, tt := range tests {
+		err := failedDelivery([]byte(tt.body))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("failedDelivery(%q) = %v, want error %v", tt.body, err, tt.wantErr)
+		}
+		if err != nil && strings.HasSuffix(err.Error(), "\n") {
+			t.Errorf("failedDelivery(%q) = %q, want the body trimmed", tt.body, err)
+		}
+	}
+}
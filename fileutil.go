@@ -0,0 +1,194 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// renameFile moves the written temporary files to their final path, tests replace it to
+// interrupt a write before the rename.
+var renameFile = os.Rename
+
+// writeFileAtomic writes data to a temporary file in the directory of path and renames it to
+// path, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return renameFile(f.Name(), path)
+}
+
+// appendFileLocked appends data to the file at path, creating it if needed, while holding an
+// exclusive lock on it, so concurrent writers never interleave their records.
+func appendFileLocked(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readFileLocked reads the file at path while holding a shared lock on it, so it never reads
+// a record appendFileLocked is writing.
+func readFileLocked(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(f)
+}
+
+// dropFilePrefixLocked removes the first n bytes of the file at path while holding an exclusive
+// lock on it, the records appended after they were read are kept.
+func dropFilePrefixLocked(path string, n int) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return err
+	}
+	b, err := ioutil.ReadAll(f)
+	if err == nil && len(b) < n {
+		err = fmt.Errorf("%s is shorter than the %d bytes read before", path, n)
+	}
+	if err == nil {
+		_, err = f.WriteAt(b[n:], 0)
+	}
+	if err == nil {
+		err = f.Truncate(int64(len(b) - n))
+	}
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// resolvePath resolves the path of a file input: ~ is expanded to the home dir, absolute paths
+// are used as is and relative paths are looked up in $BITRISE_SOURCE_DIR first, then in the
+// working dir. If the file exists in neither, the path in the first of them is returned.
+func resolvePath(p string, getenv func(string) string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = filepath.Join(home, p[1:])
+		}
+	}
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+
+	type candidate struct{ base, path string }
+	var candidates []candidate
+	if dir := getenv("BITRISE_SOURCE_DIR"); dir != "" {
+		candidates = append(candidates, candidate{"$BITRISE_SOURCE_DIR", filepath.Join(dir, p)})
+	}
+	if wd, err := os.Getwd(); err == nil {
+		candidates = append(candidates, candidate{"the working dir", filepath.Join(wd, p)})
+	}
+	for _, c := range candidates {
+		if _, err := os.Stat(c.path); err == nil {
+			logger.Debugf("%s found in %s: %s\n", p, c.base, c.path)
+			return c.path
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0].path
+	}
+	return p
+}
+
+// resolveFileInputs resolves the paths of the file inputs.
+func resolveFileInputs(c *Config, getenv func(string) string) {
+	for _, p := range []*string{&c.ReleaseNotesPath, &c.ImportCardPath, &c.PayloadSigningKeyPath, &c.VerifyPayloadPath, &c.SubjectFilePath, &c.FieldsFilePath, &c.DigestFile, &c.TroubleshootingDir, &c.AuditLogPath} {
+		*p = resolvePath(*p, getenv)
+	}
+	if !strings.HasPrefix(c.BannerSource, "http://") && !strings.HasPrefix(c.BannerSource, "https://") {
+		c.BannerSource = resolvePath(c.BannerSource, getenv)
+	}
+}
+
+// readInputFiles replaces the subject and the fields with the content of their file if the
+// path of the file is set.
+func readInputFiles(c *Config) error {
+	for _, f := range []struct {
+		Input string
+		Path  string
+		Value *string
+	}{
+		{"subject_file_path", c.SubjectFilePath, &c.Subject},
+		{"fields_file_path", c.FieldsFilePath, &c.Fields},
+	} {
+		if f.Path == "" {
+			continue
+		}
+		b, err := ioutil.ReadFile(f.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %s", f.Input, err)
+		}
+		*f.Value = strings.TrimRight(string(b), "\n")
+	}
+	return nil
+}
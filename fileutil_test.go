@@ -0,0 +1,202 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveFileInputs(t *testing.T) {
+	captureLog(t)
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip(err)
+	}
+	source := t.TempDir()
+	getenv := func(key string) string {
+		if key == "BITRISE_SOURCE_DIR" {
+			return source
+		}
+		return ""
+	}
+	c := Config{
+		AuditLogPath:     "~/audit/teams.jsonl",
+		DigestFile:       "digest.jsonl",
+		ReleaseNotesPath: "/abs/notes.md",
+		BannerSource:     "https://example.com/banner.md",
+	}
+	resolveFileInputs(&c, getenv)
+
+	for name, tt := range map[string]struct{ got, want string }{
+		"audit_log_path":     {c.AuditLogPath, filepath.Join(home, "audit/teams.jsonl")},
+		"digest_file":        {c.DigestFile, filepath.Join(source, "digest.jsonl")},
+		"release_notes_path": {c.ReleaseNotesPath, "/abs/notes.md"},
+		"banner_source":      {c.BannerSource, "https://example.com/banner.md"},
+		"import_card_path":   {c.ImportCardPath, ""},
+	} {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestResolveFileInputsRelativeAuditLog(t *testing.T) {
+	captureLog(t)
+	source := t.TempDir()
+	c := Config{AuditLogPath: "logs/audit.jsonl"}
+	resolveFileInputs(&c, func(key string) string {
+		if key == "BITRISE_SOURCE_DIR" {
+			return source
+		}
+		return ""
+	})
+	if want := filepath.Join(source, "logs/audit.jsonl"); c.AuditLogPath != want {
+		t.Errorf("audit_log_path = %q, want %q", c.AuditLogPath, want)
+	}
+}
+
+// dirNames returns the names of the files in dir.
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range fs {
+		names = append(names, f.Name())
+	}
+	return names
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "teams-message.md")
+	for _, content := range []string{"first", "second, longer than the first"} {
+		if err := writeFileAtomic(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Errorf("content = %q, want %q", b, content)
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("permissions = %o, want 600", perm)
+	}
+	if names := dirNames(t, dir); len(names) != 1 {
+		t.Errorf("files %q, want only the written file", names)
+	}
+}
+
+func TestWriteFileAtomicInterrupted(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+	}{
+		{"new file", ""},
+		{"existing file", "previous content"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "teams-message-payload.json")
+			if tt.existing != "" {
+				if err := ioutil.WriteFile(path, []byte(tt.existing), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// The process is killed once the temporary file is written, before the rename.
+			killed := errors.New("killed")
+			saved := renameFile
+			renameFile = func(from, to string) error {
+				if b, err := ioutil.ReadFile(from); err != nil || string(b) != "new content" {
+					t.Errorf("temporary file %q before the rename, error %v, want the new content", b, err)
+				}
+				return killed
+			}
+			t.Cleanup(func() { renameFile = saved })
+
+			if err := writeFileAtomic(path, []byte("new content"), 0600); !errors.Is(err, killed) {
+				t.Fatalf("writeFileAtomic() error = %v, want %v", err, killed)
+			}
+			b, err := ioutil.ReadFile(path)
+			switch {
+			case tt.existing == "" && !os.IsNotExist(err):
+				t.Errorf("final file %q, error %v, want it not to exist", b, err)
+			case tt.existing != "" && string(b) != tt.existing:
+				t.Errorf("final file %q, error %v, want the previous content", b, err)
+			}
+			if names := dirNames(t, dir); len(names) > 1 || tt.existing == "" && len(names) > 0 {
+				t.Errorf("files %q left behind, want no temporary file", names)
+			}
+		})
+	}
+}
+
+func TestFileOutputsInterrupted(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize))
+	msg := Message{Title: "Build Succeeded!", Sections: []Section{{ActivityText: "Fix the login"}}}
+	outputs := []struct {
+		name  string
+		write func() error
+	}{
+		{"markdown", func() error { return exportMarkdown(msg) }},
+		{"normalized message", func() error { return exportContentHash(msg, "", nil) }},
+		{"signed payload", func() error { return archiveSignedPayload(key, []byte(`{"title":"Build Succeeded!"}`)) }},
+	}
+	for _, o := range outputs {
+		t.Run(o.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("BITRISE_DEPLOY_DIR", dir)
+			captureOutputs(t)
+			saved := renameFile
+			renameFile = func(from, to string) error { return errors.New("killed") }
+			t.Cleanup(func() { renameFile = saved })
+
+			if err := o.write(); err == nil {
+				t.Fatal("write error = nil, want the interrupted rename")
+			}
+			if names := dirNames(t, dir); len(names) > 0 {
+				t.Errorf("files %q in the deploy dir, want none", names)
+			}
+		})
+	}
+}
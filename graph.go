@@ -0,0 +1,107 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// See also: https://learn.microsoft.com/en-us/graph/api/channel-post-messages
+
+// graphAttachmentID identifies the card in the body of the chat message.
+const graphAttachmentID = "card"
+
+type graphChatMessage struct {
+	Body        graphItemBody     `json:"body"`
+	Attachments []graphAttachment `json:"attachments"`
+}
+
+type graphItemBody struct {
+	ContentType string `json:"contentType"`
+	Content     string `json:"content"`
+}
+
+type graphAttachment struct {
+	ID          string `json:"id"`
+	ContentType string `json:"contentType"`
+	// Content is the JSON of the card.
+	Content string `json:"content"`
+}
+
+// graphMessagesURL returns the URL posting a message to the channel, or a reply to the message
+// if replyTo is set.
+func graphMessagesURL(base, teamID, channelID, replyTo string) string {
+	u := strings.TrimSuffix(base, "/") + "/teams/" + url.PathEscape(teamID) + "/channels/" + url.PathEscape(channelID) + "/messages"
+	if replyTo != "" {
+		u += "/" + url.PathEscape(replyTo) + "/replies"
+	}
+	return u
+}
+
+// checkGraphInputs returns the problem of the inputs of the graph delivery method.
+func checkGraphInputs(c Config) error {
+	switch {
+	case c.GraphAccessToken == "":
+		return errors.New("graph_access_token is required by the graph delivery method")
+	case c.TeamID == "" || c.ChannelID == "":
+		return errors.New("team_id and channel_id are required by the graph delivery method")
+	case !isWebURL(c.GraphAPIURL):
+		return errors.New("graph_api_url is not an http(s) URL")
+	}
+	return nil
+}
+
+// newGraphMessage returns the chat message with the Adaptive Card equivalent of the MessageCard.
+func newGraphMessage(msg Message) ([]byte, error) {
+	card, err := json.Marshal(newAdaptiveCard(msg))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(graphChatMessage{
+		Body: graphItemBody{ContentType: "html", Content: `<attachment id="` + graphAttachmentID + `"></attachment>`},
+		Attachments: []graphAttachment{{
+			ID:          graphAttachmentID,
+			ContentType: adaptiveCardContentType,
+			Content:     string(card),
+		}},
+	})
+}
+
+// graphMessageIdentity returns the id and the link of the message created by the Graph API.
+func graphMessageIdentity(body string) (id, link string) {
+	var created struct {
+		ID     string `json:"id"`
+		WebURL string `json:"webUrl"`
+	}
+	if err := json.Unmarshal([]byte(body), &created); err != nil {
+		return "", ""
+	}
+	return created.ID, created.WebURL
+}
@@ -0,0 +1,88 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// newUUID generates a random (version 4) UUID.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// idempotencyKey returns a new idempotency key if idempotency_key_header is set, or "".
+func idempotencyKey(conf Config) (string, error) {
+	if conf.IdempotencyKeyHeader == "" {
+		return "", nil
+	}
+	key, err := newUUID()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate idempotency key: %s", err)
+	}
+	logger.Debugf("Idempotency key: %s\n", key)
+	return key, nil
+}
+
+// variantKey returns the idempotency key of the nth reduced variant of the message. A receiver
+// deduplicating on the key would drop a reduced card sent with the key of the rejected one,
+// if the rejected request was partially accepted.
+func variantKey(key string, n int) string {
+	if key == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s-reduced-%d", key, n)
+}
+
+// isUndelivered reports whether the request failed before the connection was established,
+// so the message was certainly not delivered.
+func isUndelivered(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// classifyNetworkError wraps the error of a failed request. Requests which failed after the
+// message was possibly delivered are retried only if they carry an idempotency key.
+func classifyNetworkError(err error, idempotent bool) error {
+	if isUndelivered(err) || idempotent {
+		return transient(fmt.Errorf("failed to send the request: %w", err))
+	}
+	return permanent(fmt.Errorf("failed to send the request: %w (the message may have been delivered, "+
+		"it is retried only if idempotency_key_header is set)", err))
+}
@@ -0,0 +1,113 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestNewUUID(t *testing.T) {
+	pattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id, err := newUUID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !pattern.MatchString(id) || seen[id] {
+			t.Fatalf("newUUID() = %q, want a new version 4 UUID", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestVariantKey(t *testing.T) {
+	if got := variantKey("", 1); got != "" {
+		t.Errorf("variantKey(\"\", 1) = %q, want \"\"", got)
+	}
+	if got, want := variantKey("key", 2), "key-reduced-2"; got != want {
+		t.Errorf("variantKey(\"key\", 2) = %q, want %q", got, want)
+	}
+}
+
+// networkErrors returns the error of a refused connection and of a request timing out after
+// the request was sent.
+func networkErrors(t *testing.T) (refused, timedOut error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, refused = http.Post("http://"+addr, "application/json", nil)
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer srv.Close()
+	defer close(release)
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	_, timedOut = client.Post(srv.URL, "application/json", nil)
+	return refused, timedOut
+}
+
+func TestClassifyNetworkError(t *testing.T) {
+	refused, timedOut := networkErrors(t)
+	if refused == nil || timedOut == nil {
+		t.Fatalf("network errors = %v, %v, want both", refused, timedOut)
+	}
+	dns := &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}
+	tests := []struct {
+		name       string
+		err        error
+		idempotent bool
+		want       bool
+	}{
+		{"refused", refused, false, true},
+		{"unknown host", dns, false, true},
+		{"timed out", timedOut, false, false},
+		{"timed out with a key", timedOut, true, true},
+		{"cancelled", context.Canceled, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyNetworkError(tt.err, tt.idempotent)
+			if got := isTransient(err); got != tt.want {
+				t.Errorf("classifyNetworkError(%v, %t) transient = %t, want %t", tt.err, tt.idempotent, got, tt.want)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("classifyNetworkError(%v, %t) = %v, want it to wrap the error", tt.err, tt.idempotent, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// imageVerifyTimeout is the deadline shared by all the image checks.
+const imageVerifyTimeout = 3 * time.Second
+
+// imageCheck is the result of verifying an image URL.
+type imageCheck int
+
+const (
+	imageOK imageCheck = iota
+	imageBroken
+	imageTimedOut
+)
+
+// checkImage sends a HEAD request to the image URL. The servers rejecting HEAD requests, eg.
+// CDNs answering 403 or 405, are asked for the first byte of the image with a GET request.
+func checkImage(ctx context.Context, client *http.Client, url string) imageCheck {
+	status, check := requestImage(ctx, client, "HEAD", url)
+	if status == http.StatusForbidden || status == http.StatusMethodNotAllowed {
+		_, check = requestImage(ctx, client, "GET", url)
+	}
+	return check
+}
+
+// requestImage sends a request to the image URL and returns the status of the response, or
+// 0 if there is none.
+func requestImage(ctx context.Context, client *http.Client, method, url string) (int, imageCheck) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return 0, imageBroken
+	}
+	if method == "GET" {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, imageTimedOut
+		}
+		return 0, imageBroken
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warnf("Failed to close response body: %s", err)
+		}
+	}()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, imageBroken
+	}
+	return resp.StatusCode, imageOK
+}
+
+// checkImageURLs checks the URLs concurrently within the timeout shared by all of them.
+func checkImageURLs(client *http.Client, urls []string, timeout time.Duration) []imageCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	checks := make([]imageCheck, len(urls))
+	var wg sync.WaitGroup
+	for i, url := range urls {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			checks[i] = checkImage(ctx, client, url)
+		}(i, url)
+	}
+	wg.Wait()
+	return checks
+}
+
+// keepImage logs the result of the check of the image and reports whether it is kept. Images
+// which couldn't be verified in time are kept unless dropOnTimeout is set.
+func keepImage(img Image, check imageCheck, dropOnTimeout bool) bool {
+	switch check {
+	case imageBroken:
+		logger.Warnf("Image omitted, it is not reachable: %s", img.URL)
+		return false
+	case imageTimedOut:
+		if dropOnTimeout {
+			logger.Warnf("Image omitted, it could not be verified in time: %s", img.URL)
+			return false
+		}
+		logger.Debugf("Image unverified, it could not be verified in time: %s\n", img.URL)
+	default:
+		logger.Debugf("Image verified: %s\n", img.URL)
+	}
+	return true
+}
+
+// verifyImages checks all the images concurrently within the given timeout and omits the
+// broken ones.
+func verifyImages(client *http.Client, images []Image, timeout time.Duration, dropOnTimeout bool) []Image {
+	if len(images) == 0 {
+		return images
+	}
+	var urls []string
+	for _, img := range images {
+		urls = append(urls, img.URL)
+	}
+	checks := checkImageURLs(client, urls, timeout)
+
+	var verified []Image
+	for i, img := range images {
+		if keepImage(img, checks[i], dropOnTimeout) {
+			verified = append(verified, img)
+		}
+	}
+	return verified
+}
+
+// verifyMessageImages checks the images of every section of the message concurrently, within
+// the timeout shared by all of them, and omits the broken ones.
+func verifyMessageImages(client *http.Client, msg *Message, timeout time.Duration, dropOnTimeout bool) {
+	var urls []string
+	for _, s := range msg.Sections {
+		for _, img := range s.Images {
+			urls = append(urls, img.URL)
+		}
+	}
+	if len(urls) == 0 {
+		return
+	}
+	checks := checkImageURLs(client, urls, timeout)
+
+	n := 0
+	for i := range msg.Sections {
+		s := &msg.Sections[i]
+		var verified []Image
+		for _, img := range s.Images {
+			if keepImage(img, checks[n], dropOnTimeout) {
+				verified = append(verified, img)
+			}
+			n++
+		}
+		s.Images = verified
+	}
+}
+
+// heroImage returns the hero image of the input, or nil if it is empty, not an http(s) URL or
+// not reachable.
+func heroImage(client *http.Client, in pairedInput) *Image {
+	url := strings.TrimSpace(in.Value)
+	if url == "" {
+		return nil
+	}
+	if !isWebURL(url) {
+		logger.Warnf("%s is not an http(s) URL, it is omitted: %s", in.Name, url)
+		return nil
+	}
+	images := verifyImages(client, []Image{{URL: url}}, imageVerifyTimeout, false)
+	if len(images) == 0 {
+		return nil
+	}
+	return &images[0]
+}
@@ -0,0 +1,148 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// imageServer serves images on /ok, fails on /missing, rejects HEAD requests on /head-403 and
+// /head-405 and stalls on /stall until the request is cancelled.
+func imageServer(t *testing.T) *httptest.Server {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+		case "/head-403", "/head-405":
+			if r.Method == "HEAD" {
+				status := http.StatusForbidden
+				if r.URL.Path == "/head-405" {
+					status = http.StatusMethodNotAllowed
+				}
+				w.WriteHeader(status)
+				return
+			}
+			if r.Header.Get("Range") != "bytes=0-0" {
+				t.Errorf("GET %s Range = %q, want bytes=0-0", r.URL.Path, r.Header.Get("Range"))
+			}
+			w.WriteHeader(http.StatusPartialContent)
+		case "/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		case "/stall":
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		srv.Close()
+	})
+	return srv
+}
+
+func TestCheckImage(t *testing.T) {
+	srv := imageServer(t)
+	tests := []struct {
+		path string
+		want imageCheck
+	}{
+		{"/ok", imageOK},
+		{"/missing", imageBroken},
+		{"/head-403", imageOK},
+		{"/head-405", imageOK},
+		{"/forbidden", imageBroken},
+	}
+	for _, tt := range tests {
+		if got := checkImage(context.Background(), srv.Client(), srv.URL+tt.path); got != tt.want {
+			t.Errorf("checkImage(%q) = %d, want %d", tt.path, got, tt.want)
+		}
+	}
+	if got := checkImage(context.Background(), srv.Client(), "http://"); got != imageBroken {
+		t.Errorf("checkImage() of an invalid URL = %d, want %d", got, imageBroken)
+	}
+}
+
+func TestVerifyImagesTimeoutPolicies(t *testing.T) {
+	srv := imageServer(t)
+	images := []Image{{Title: "ok", URL: srv.URL + "/ok"}, {Title: "missing", URL: srv.URL + "/missing"}, {Title: "stall", URL: srv.URL + "/stall"}}
+	tests := []struct {
+		name          string
+		dropOnTimeout bool
+		want          []string
+	}{
+		{"kept", false, []string{"ok", "stall"}},
+		{"dropped", true, []string{"ok"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, img := range verifyImages(srv.Client(), images, 100*time.Millisecond, tt.dropOnTimeout) {
+				got = append(got, img.Title)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("verifyImages() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyMessageImagesSharesTheDeadline(t *testing.T) {
+	srv := imageServer(t)
+	stall := Image{Title: "stall", URL: srv.URL + "/stall"}
+	msg := Message{Sections: []Section{
+		{Images: []Image{stall, {Title: "ok", URL: srv.URL + "/ok"}}},
+		{Images: []Image{stall, stall}},
+		{Text: "no image"},
+		{Images: []Image{{Title: "missing", URL: srv.URL + "/missing"}, stall}},
+	}}
+	timeout := 200 * time.Millisecond
+
+	start := time.Now()
+	verifyMessageImages(srv.Client(), &msg, timeout, true)
+	if elapsed := time.Since(start); elapsed > 2*timeout {
+		t.Errorf("verifyMessageImages() took %s, want the %s deadline shared by the sections", elapsed, timeout)
+	}
+	var counts []int
+	for _, s := range msg.Sections {
+		counts = append(counts, len(s.Images))
+	}
+	if want := []int{1, 0, 0, 0}; !reflect.DeepEqual(counts, want) {
+		t.Errorf("images per section = %v, want %v", counts, want)
+	}
+	if got := msg.Sections[0].Images[0].Title; got != "ok" {
+		t.Errorf("kept image = %q, want ok", got)
+	}
+}
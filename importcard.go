@@ -0,0 +1,258 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// importedInputs are the step inputs reproducing an imported card, Unmapped lists the
+// elements of the card which have no equivalent input.
+type importedInputs struct {
+	CardFormat      string
+	Title           string
+	ThemeColor      string
+	AuthorName      string
+	AuthorAvatarURL string
+	Subject         string
+	Fields          []string
+	Images          []string
+	Buttons         []string
+	Unmapped        []string
+}
+
+// pairLine formats a line of a pipe separated input, or returns false if the name or the
+// value can not be represented.
+func pairLine(name, value string) (string, bool) {
+	if name == "" || value == "" || strings.Contains(name, "|") || strings.ContainsAny(name+value, "\n") {
+		return "", false
+	}
+	return name + "|" + value, true
+}
+
+func (in *importedInputs) addPair(list *[]string, kind, name, value string) {
+	if line, ok := pairLine(name, value); ok {
+		*list = append(*list, line)
+	} else {
+		in.Unmapped = append(in.Unmapped, fmt.Sprintf("%s %q", kind, name))
+	}
+}
+
+// importMessageCard maps a MessageCard onto the step inputs, it is the inverse of newMessage.
+func importMessageCard(msg Message) importedInputs {
+	in := importedInputs{CardFormat: "messagecard", Title: msg.Title, ThemeColor: msg.ThemeColor}
+	for i, s := range msg.Sections {
+		if i == 0 {
+			in.AuthorName = s.ActivityTitle
+			in.AuthorAvatarURL = s.ActivityImage
+			in.Subject = s.ActivityText
+		} else if s.ActivityTitle != "" || s.ActivityText != "" {
+			in.Unmapped = append(in.Unmapped, fmt.Sprintf("activity of section %d", i+1))
+		}
+		if s.Title != "" {
+			in.Unmapped = append(in.Unmapped, fmt.Sprintf("section title %q", s.Title))
+		}
+		if s.Text != "" {
+			in.Unmapped = append(in.Unmapped, fmt.Sprintf("text of section %d", i+1))
+		}
+		for _, f := range s.Facts {
+			in.addPair(&in.Fields, "fact", f.Name, f.Value)
+		}
+		for _, img := range s.Images {
+			in.addPair(&in.Images, "image", img.Title, img.URL)
+		}
+		for _, a := range s.Actions {
+			if a.Type == "HttpPOST" && a.Target != "" && a.Body != "" {
+				in.addPair(&in.Buttons, "button", a.Name, a.Target+"|POST|"+a.Body)
+				continue
+			}
+			if a.Type != "OpenUri" || len(a.Targets) == 0 {
+				in.Unmapped = append(in.Unmapped, fmt.Sprintf("%s action %q", a.Type, a.Name))
+				continue
+			}
+			in.addPair(&in.Buttons, "button", a.Name, a.Targets[0].URI)
+		}
+	}
+	return in
+}
+
+// importAdaptiveCard maps an Adaptive Card onto the step inputs, it is the inverse of
+// newAdaptiveCard where possible: the first text block becomes the title, the next bold one
+// the author and the next one the subject. The containers and columns are flattened.
+func importAdaptiveCard(card adaptiveCard) importedInputs {
+	in := importedInputs{CardFormat: "adaptivecard"}
+	var walk func(es []adaptiveElement)
+	walk = func(es []adaptiveElement) {
+		for _, e := range es {
+			switch e.Type {
+			case "TextBlock":
+				switch {
+				case in.Title == "":
+					in.Title = e.Text
+				case in.AuthorName == "" && e.Weight == "Bolder":
+					in.AuthorName = e.Text
+				case in.Subject == "":
+					in.Subject = e.Text
+				default:
+					in.Unmapped = append(in.Unmapped, fmt.Sprintf("TextBlock %q", truncateText(e.Text, 40)))
+				}
+			case "FactSet":
+				for _, f := range e.Facts {
+					in.addPair(&in.Fields, "fact", f.Title, f.Value)
+				}
+			case "Image":
+				if e.SelectAction != nil && e.SelectAction.URL != "" {
+					in.addPair(&in.Images, "image", e.AltText, e.URL+"|"+e.SelectAction.URL)
+				} else {
+					in.addPair(&in.Images, "image", e.AltText, e.URL)
+				}
+			case "ImageSet":
+				walk(e.Images)
+			case "Container", "Column":
+				walk(e.Items)
+			case "ColumnSet":
+				walk(e.Columns)
+			default:
+				in.Unmapped = append(in.Unmapped, e.Type)
+			}
+		}
+	}
+	walk(card.Body)
+	for _, a := range card.Actions {
+		if a.Type != "Action.OpenUrl" {
+			in.Unmapped = append(in.Unmapped, fmt.Sprintf("%s %q", a.Type, a.Title))
+			continue
+		}
+		in.addPair(&in.Buttons, "button", a.Title, a.URL)
+	}
+	return in
+}
+
+// parseCard imports a MessageCard or an Adaptive Card, either sent as is or wrapped in the
+// attachments of a message.
+func parseCard(data []byte) (importedInputs, error) {
+	var probe struct {
+		Type        string `json:"type"`
+		AtType      string `json:"@type"`
+		Attachments []struct {
+			Content json.RawMessage `json:"content"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return importedInputs{}, fmt.Errorf("invalid card JSON: %s", err)
+	}
+	switch {
+	case probe.AtType == "MessageCard":
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return importedInputs{}, fmt.Errorf("invalid MessageCard: %s", err)
+		}
+		return importMessageCard(msg), nil
+	case probe.Type == "AdaptiveCard":
+		var card adaptiveCard
+		if err := json.Unmarshal(data, &card); err != nil {
+			return importedInputs{}, fmt.Errorf("invalid Adaptive Card: %s", err)
+		}
+		return importAdaptiveCard(card), nil
+	case probe.Type == "message" && len(probe.Attachments) == 1:
+		return parseCard(probe.Attachments[0].Content)
+	default:
+		return importedInputs{}, fmt.Errorf("neither a MessageCard nor an Adaptive Card")
+	}
+}
+
+// yamlInputs formats the inputs as the step inputs of a bitrise.yml.
+func yamlInputs(in importedInputs) string {
+	var b strings.Builder
+	scalar := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "- %s: %s\n", key, strconv.Quote(value))
+		}
+	}
+	lines := func(key string, values []string) {
+		if len(values) == 0 {
+			fmt.Fprintf(&b, "- %s: \"\"\n", key)
+			return
+		}
+		fmt.Fprintf(&b, "- %s: |\n", key)
+		for _, v := range values {
+			fmt.Fprintf(&b, "    %s\n", v)
+		}
+	}
+	scalar("card_format", in.CardFormat)
+	scalar("title", in.Title)
+	scalar("title_on_error", in.Title)
+	scalar("theme_color", strings.TrimPrefix(in.ThemeColor, "#"))
+	scalar("author_name", in.AuthorName)
+	scalar("author_avatar_url", in.AuthorAvatarURL)
+	scalar("subject", in.Subject)
+	lines("fields", in.Fields)
+	lines("images", in.Images)
+	lines("buttons", in.Buttons)
+	return b.String()
+}
+
+// runImport converts the card at path into step inputs, writes them into the deploy dir and
+// returns the exit code of the step.
+func runImport(path string) int {
+	if path == "" {
+		logger.Errorf("Error: import_card_path is required by the import operation")
+		return 1
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.Errorf("Error: failed to read card: %s", err)
+		return 1
+	}
+	in, err := parseCard(data)
+	if err != nil {
+		logger.Errorf("Error: %s: %s", path, err)
+		return 1
+	}
+
+	inputs := yamlInputs(in)
+	out := outputPath("teams-message-inputs.yml")
+	if err := writeFileAtomic(out, []byte(inputs), 0600); err != nil {
+		logger.Errorf("Error: %s", err)
+		return 1
+	}
+	if err := exportEnv("TEAMS_IMPORTED_INPUTS_PATH", out); err != nil {
+		logger.Warnf("%s", err)
+	}
+	logger.Printf("Imported inputs, written to %s:\n%s", out, inputs)
+	if len(in.Unmapped) > 0 {
+		logger.Warnf("Elements without an equivalent input, not imported:")
+		for _, u := range in.Unmapped {
+			logger.Warnf("- %s", u)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,206 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// importedConfig returns the configuration of the imported inputs.
+func importedConfig(in importedInputs) Config {
+	return Config{
+		CardFormat:      in.CardFormat,
+		Title:           in.Title,
+		ThemeColor:      in.ThemeColor,
+		AuthorName:      in.AuthorName,
+		AuthorAvatarURL: in.AuthorAvatarURL,
+		Subject:         in.Subject,
+		Fields:          strings.Join(in.Fields, "\n"),
+		Images:          strings.Join(in.Images, "\n"),
+		Buttons:         strings.Join(in.Buttons, "\n"),
+	}
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		conf Config
+	}{
+		{"messagecard", Config{
+			CardFormat:      "messagecard",
+			Title:           "Build Succeeded!",
+			ThemeColor:      "#00ff00",
+			AuthorName:      "Bitrise",
+			AuthorAvatarURL: "https://example.com/avatar.png",
+			Subject:         "Fix the login",
+			Fields:          "App|Login\nBranch|main",
+			Images:          "Icon|https://example.com/icon.png",
+			Buttons:         "Dashboard|https://app.bitrise.io/dashboard\nPromote|https://deploy.example.com|POST|{\"build\":42}",
+		}},
+		{"adaptivecard", Config{
+			CardFormat: "adaptivecard",
+			Title:      "Build Succeeded!",
+			AuthorName: "Bitrise",
+			Subject:    "Fix the login",
+			Fields:     "App|Login\nBranch|main",
+			Images:     "Icon|https://example.com/icon.png",
+			Buttons:    "Dashboard|https://app.bitrise.io/dashboard",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, errs := newMessage(tt.conf)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			want, err := marshalPayload(tt.conf, msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			in, err := parseCard(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(in.Unmapped) > 0 {
+				t.Errorf("unmapped %q, want everything imported", in.Unmapped)
+			}
+			conf := importedConfig(in)
+			rebuilt, errs := newMessage(conf)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			got, err := marshalPayload(conf, rebuilt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("rebuilt payload\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestParseCardAdaptiveCardContent(t *testing.T) {
+	msg, _ := newMessage(Config{Title: "Build Succeeded!", Subject: "Fix the login", Fields: "App|Login"})
+	card, err := json.Marshal(newAdaptiveCard(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope, err := json.Marshal(newAdaptiveMessage(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromCard, err := parseCard(card)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromEnvelope, err := parseCard(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromCard, fromEnvelope) {
+		t.Errorf("imported envelope %+v, want the card %+v", fromEnvelope, fromCard)
+	}
+}
+
+func TestParseCardUnmapped(t *testing.T) {
+	tests := []struct {
+		name string
+		card string
+		want []string
+	}{
+		{"MessageCard", `{"@type":"MessageCard","title":"T","sections":[{"activityTitle":"A"},` +
+			`{"title":"Details","text":"more","activityText":"x","facts":[{"name":"a|b","value":"v"}]}],` +
+			`"potentialAction":[]}`,
+			[]string{"activity of section 2", `section title "Details"`, "text of section 2", `fact "a|b"`},
+		},
+		{"Adaptive Card", `{"type":"AdaptiveCard","body":[{"type":"TextBlock","text":"T"},{"type":"Input.Text"},` +
+			`{"type":"FactSet","facts":[{"title":"Multi","value":"a\nb"}]}],"actions":[{"type":"Action.Submit","title":"Send"}]}`,
+			[]string{"Input.Text", `fact "Multi"`, `Action.Submit "Send"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := parseCard([]byte(tt.card))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(in.Unmapped, tt.want) {
+				t.Errorf("unmapped %q, want %q", in.Unmapped, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseCardInvalid(t *testing.T) {
+	for _, card := range []string{`{`, `{"type":"message","attachments":[]}`, `{"@type":"Other"}`, `[]`} {
+		if _, err := parseCard([]byte(card)); err == nil {
+			t.Errorf("parseCard(%s) error = nil, want an error", card)
+		}
+	}
+}
+
+func TestRunImport(t *testing.T) {
+	captureLog(t)
+	dir := t.TempDir()
+	t.Setenv("BITRISE_DEPLOY_DIR", dir)
+	exported := captureOutputs(t)
+	path := filepath.Join(dir, "card.json")
+	card := `{"@type":"MessageCard","title":"Nightly \"build\"","themeColor":"#ff0000","sections":[{"activityText":"Fix",` +
+		`"facts":[{"name":"App","value":"Login"}]}]}`
+	if err := ioutil.WriteFile(path, []byte(card), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if code := runImport(path); code != 0 {
+		t.Fatalf("runImport() = %d, want 0", code)
+	}
+	b, err := ioutil.ReadFile(exported["TEAMS_IMPORTED_INPUTS_PATH"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "- card_format: \"messagecard\"\n" +
+		"- title: \"Nightly \\\"build\\\"\"\n" +
+		"- title_on_error: \"Nightly \\\"build\\\"\"\n" +
+		"- theme_color: \"ff0000\"\n" +
+		"- subject: \"Fix\"\n" +
+		"- fields: |\n    App|Login\n" +
+		"- images: \"\"\n" +
+		"- buttons: \"\"\n"
+	if string(b) != want {
+		t.Errorf("imported inputs\n%s\nwant\n%s", b, want)
+	}
+	if code := runImport(filepath.Join(dir, "missing.json")); code != 1 {
+		t.Errorf("runImport(missing) = %d, want 1", code)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/bitrise-tools/go-steputils/stepconf"
+)
+
+// untrustedInputs are the inputs whose values come from the commit or from the webhook payload,
+// so anyone who can push controls them. Their references and commands are never resolved.
+var untrustedInputs = map[string]bool{"subject": true, "author_name": true}
+
+// untrustedEnvs are the envs set from the commit, the pull request or the webhook payload. The
+// Bitrise CLI expands them in the inputs before the step starts.
+var untrustedEnvs = []string{
+	"GIT_CLONE_COMMIT_MESSAGE_SUBJECT",
+	"GIT_CLONE_COMMIT_MESSAGE_BODY",
+	"GIT_CLONE_COMMIT_AUTHOR_NAME",
+	"GIT_CLONE_COMMIT_AUTHOR_EMAIL",
+	"GIT_CLONE_COMMIT_COMMITER_NAME",
+	"GIT_CLONE_COMMIT_COMMITER_EMAIL",
+	"BITRISE_GIT_MESSAGE",
+	"BITRISE_GIT_BRANCH",
+	"BITRISE_GIT_BRANCH_DEST",
+	"BITRISE_GIT_TAG",
+	"BITRISEIO_PULL_REQUEST_TITLE",
+	"BITRISEIO_GIT_BRANCH_DEST",
+}
+
+var secretType = reflect.TypeOf(stepconf.Secret(""))
+
+// parseInputList returns the input keys of a list separated by newlines or commas.
+func parseInputList(list string) []string {
+	var keys []string
+	for _, k := range strings.FieldsFunc(list, func(r rune) bool { return r == '\n' || r == ',' }) {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// optedInInputs returns the string fields of the config by the input keys listed in keys,
+// which the user opted in to resolve as listName. A key which is not a string input, which
+// is a secret or which comes from the commit is an error.
+func optedInInputs(c *Config, listName string, keys []string) (map[string]reflect.Value, error) {
+	v := reflect.ValueOf(c).Elem()
+	fields := map[string]reflect.Value{}
+	for i := 0; i < v.NumField(); i++ {
+		key := strings.SplitN(v.Type().Field(i).Tag.Get("env"), ",", 2)[0]
+		if key != "" && v.Field(i).Kind() == reflect.String {
+			fields[key] = v.Field(i)
+		}
+	}
+
+	inputs := map[string]reflect.Value{}
+	for _, key := range keys {
+		f, ok := fields[key]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%s: %s is not a text input", listName, key)
+		case f.Type() == secretType:
+			return nil, fmt.Errorf("%s: %s is a secret, it can't be resolved", listName, key)
+		case untrustedInputs[key]:
+			return nil, fmt.Errorf("%s: %s comes from the commit, it can't be resolved", listName, key)
+		}
+		inputs[key] = f
+	}
+	return inputs, nil
+}
+
+// untrustedSource returns the env from the commit whose value contains the marker and is part
+// of s, or "". Such a value was expanded into the input by the Bitrise CLI, so resolving the
+// input would resolve the text of the commit.
+func untrustedSource(s, marker string, getenv func(string) string) string {
+	for _, key := range untrustedEnvs {
+		if v := getenv(key); strings.Contains(v, marker) && strings.Contains(s, v) {
+			return key
+		}
+	}
+	return ""
+}
@@ -0,0 +1,110 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// jsonLink is a button or an image of the json input format.
+type jsonLink struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+	// Method and Body are those of an HttpPOST button.
+	Method string          `json:"method,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+}
+
+// decodeJSONInput decodes the JSON array of objects of an input, an empty input is an empty list.
+func decodeJSONInput(input, s string, v interface{}) error {
+	if strings.TrimSpace(s) == "" {
+		return nil
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%s is not a valid JSON list: %s", input, err)
+	}
+	return nil
+}
+
+// decodeJSONLinks decodes the buttons or the images of the json input format.
+func decodeJSONLinks(input, s string) ([]jsonLink, error) {
+	var ls []jsonLink
+	if err := decodeJSONInput(input, s, &ls); err != nil {
+		return nil, err
+	}
+	for i, l := range ls {
+		switch {
+		case l.Title == "":
+			return nil, fmt.Errorf("%s item %d has no title", input, i+1)
+		case !isWebURL(l.URL):
+			return nil, fmt.Errorf("%s item %d is not an http(s) URL: %s", input, i+1, l.URL)
+		case l.Method != "" && l.Method != "POST":
+			return nil, fmt.Errorf("%s item %d has an unsupported method: %s", input, i+1, l.Method)
+		}
+	}
+	return ls, nil
+}
+
+// jsonInputs parses the fields, images and buttons of the json input format.
+func jsonInputs(in selectedInputs) (fs []Fact, is []Image, as []Action, err error) {
+	if err = decodeJSONInput(in.Fields.Name, in.Fields.Value, &fs); err != nil {
+		return
+	}
+	for i, f := range fs {
+		if f.Name == "" {
+			return nil, nil, nil, fmt.Errorf("%s item %d has no name", in.Fields.Name, i+1)
+		}
+	}
+
+	images, err := decodeJSONLinks(in.Images.Name, in.Images.Value)
+	if err != nil {
+		return
+	}
+	for i, l := range images {
+		if l.Method != "" {
+			return nil, nil, nil, fmt.Errorf("%s item %d has a method, only buttons do", in.Images.Name, i+1)
+		}
+		is = append(is, Image{Title: l.Title, URL: l.URL})
+	}
+
+	buttons, err := decodeJSONLinks(in.Buttons.Name, in.Buttons.Value)
+	if err != nil {
+		return
+	}
+	for _, l := range buttons {
+		if l.Method == "POST" {
+			as = append(as, Action{Type: "HttpPOST", Name: l.Title, Target: l.URL, Body: string(l.Body)})
+			continue
+		}
+		as = append(as, Action{Type: "OpenUri", Name: l.Title, Targets: []Target{{OS: "default", URI: l.URL}}})
+	}
+	return
+}
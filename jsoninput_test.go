@@ -0,0 +1,129 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// jsonConfig returns the configuration of the json input format with the inputs.
+func jsonConfig(fields, images, buttons string) Config {
+	return Config{InputFormat: "json", Title: "Build Succeeded!", Fields: fields, Images: images, Buttons: buttons}
+}
+
+func TestJSONInputs(t *testing.T) {
+	fs, is, as, err := jsonInputs(selectInputs(jsonConfig(
+		`[{"name":"Subject","value":"Fix a|b\nand c"},{"name":"Branch","value":"main"}]`,
+		`[{"title":"Icon","url":"https://example.com/icon.png"}]`,
+		`[{"title":"Logs","url":"https://app.bitrise.io/build/42"},`+
+			`{"title":"Retry","url":"https://deploy.example.com","method":"POST","body":{"retry":true}}]`,
+	)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFacts := []Fact{{Name: "Subject", Value: "Fix a|b\nand c"}, {Name: "Branch", Value: "main"}}
+	if !reflect.DeepEqual(fs, wantFacts) {
+		t.Errorf("facts = %+v, want %+v", fs, wantFacts)
+	}
+	if want := []Image{{Title: "Icon", URL: "https://example.com/icon.png"}}; !reflect.DeepEqual(is, want) {
+		t.Errorf("images = %+v, want %+v", is, want)
+	}
+	wantActions := []Action{
+		{Type: "OpenUri", Name: "Logs", Targets: []Target{{OS: "default", URI: "https://app.bitrise.io/build/42"}}},
+		{Type: "HttpPOST", Name: "Retry", Target: "https://deploy.example.com", Body: `{"retry":true}`},
+	}
+	if !reflect.DeepEqual(as, wantActions) {
+		t.Errorf("actions = %+v, want %+v", as, wantActions)
+	}
+
+	if fs, is, as, err := jsonInputs(selectInputs(jsonConfig("", " \n", ""))); err != nil || fs != nil || is != nil || as != nil {
+		t.Errorf("jsonInputs() of empty inputs = %v, %v, %v, %v, want empty lists", fs, is, as, err)
+	}
+}
+
+func TestJSONInputsErrors(t *testing.T) {
+	const image = `[{"title":"Icon","url":"https://example.com/icon.png"}]`
+	tests := []struct {
+		name   string
+		conf   Config
+		failed bool
+		want   string
+	}{
+		{"malformed fields", jsonConfig(`[{"name":"Branch",}]`, "", ""), false, "fields is not a valid JSON list"},
+		{"simple syntax", jsonConfig("Branch|main", "", ""), false, "fields is not a valid JSON list"},
+		{"unknown key", jsonConfig(`[{"name":"Branch","val":"main"}]`, "", ""), false, `unknown field "val"`},
+		{"no name", jsonConfig(`[{"name":"Branch","value":"main"},{"value":"x"}]`, "", ""), false, "fields item 2 has no name"},
+		{"image without title", jsonConfig("", `[{"url":"https://example.com/icon.png"}]`, ""), false, "images item 1 has no title"},
+		{"image URL", jsonConfig("", `[{"title":"Icon","url":"ftp://example.com/icon.png"}]`, ""), false, "images item 1 is not an http(s) URL"},
+		{"image method", jsonConfig("", `[{"title":"Icon","url":"https://example.com","method":"POST"}]`, ""), false, "images item 1 has a method"},
+		{"button method", jsonConfig("", image, `[{"title":"Retry","url":"https://example.com","method":"PUT"}]`), false, "buttons item 1 has an unsupported method: PUT"},
+		{"error inputs", Config{InputFormat: "json", FieldsOnError: "{"}, true, "fields_on_error is not a valid JSON list"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(v bool) { success = v }(success)
+			success = !tt.failed
+			if _, _, _, err := jsonInputs(selectInputs(tt.conf)); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("jsonInputs() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONInputsCheckedBeforeSending(t *testing.T) {
+	captureLog(t)
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { requests++ }))
+	defer srv.Close()
+	p := &sendPipeline{conf: jsonConfig(`[{"name":"Branch"`, "", ""), report: &RunReport{}, urls: []string{srv.URL}}
+	if code := p.run(); code != 1 {
+		t.Errorf("run() = %d, want 1", code)
+	}
+	if requests != 0 {
+		t.Errorf("%d requests sent, want none with an invalid input", requests)
+	}
+}
+
+func TestJSONInputsAfterSubshells(t *testing.T) {
+	conf := jsonConfig(`[{"name":"Version","value":"$(git describe)"}]`, "", "")
+	conf.SubshellInputs = "fields"
+	run := func(string) (string, error) { return "1.4.2", nil }
+	if err := applySubshells(&conf, run, func(string) string { return "" }); err != nil {
+		t.Fatal(err)
+	}
+	msg, errs := newMessage(conf)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if want := []Fact{{Name: "Version", Value: "1.4.2"}}; !reflect.DeepEqual(msg.Sections[0].Facts, want) {
+		t.Errorf("facts = %+v, want %+v", msg.Sections[0].Facts, want)
+	}
+}
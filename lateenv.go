@@ -0,0 +1,74 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// lateEnvPattern matches the {{env:KEY}} and {{env:KEY|default}} references, which are resolved
+// by the step when it runs, unlike $KEY which the Bitrise CLI expands before the step starts.
+var lateEnvPattern = regexp.MustCompile(`\{\{env:([A-Za-z_][A-Za-z0-9_]*)(?:\|([^}]*))?\}\}`)
+
+// resolveLateEnvs replaces the late-bound env references in s with the value of the env, or
+// with the default if the env is empty.
+func resolveLateEnvs(s string, getenv func(string) string) string {
+	return lateEnvPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		m := lateEnvPattern.FindStringSubmatch(ref)
+		if v := getenv(m[1]); v != "" {
+			return v
+		}
+		if m[2] == "" {
+			logger.Debugf("Env %s referenced by {{env:%s}} is empty\n", m[1], m[1])
+		}
+		return m[2]
+	})
+}
+
+// applyLateEnvs resolves the late-bound env references in the inputs listed in late_env_inputs.
+// An input containing the value of an env from the commit with a reference is left as is, so a
+// commit message can't read the envs of the build.
+func applyLateEnvs(c *Config, getenv func(string) string) error {
+	keys := parseInputList(c.LateEnvInputs)
+	inputs, err := optedInInputs(c, "late_env_inputs", keys)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		f := inputs[key]
+		if !strings.Contains(f.String(), "{{env:") {
+			continue
+		}
+		if env := untrustedSource(f.String(), "{{env:", getenv); env != "" {
+			logger.Warnf("Input %s contains $%s, which has an env reference, its references are not resolved.", key, env)
+			continue
+		}
+		f.SetString(resolveLateEnvs(f.String(), getenv))
+	}
+	return nil
+}
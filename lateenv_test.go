@@ -0,0 +1,97 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestApplyLateEnvs(t *testing.T) {
+	envs := map[string]string{"SECRET_TOKEN": "s3cr3t", "VERSION": "1.2"}
+	getenv := func(key string) string { return envs[key] }
+
+	tests := []struct {
+		name string
+		conf Config
+		want Config
+	}{
+		{
+			name: "only the listed inputs are resolved",
+			conf: Config{LateEnvInputs: "title", Title: "v{{env:VERSION}}", Summary: "v{{env:VERSION}}"},
+			want: Config{LateEnvInputs: "title", Title: "v1.2", Summary: "v{{env:VERSION}}"},
+		},
+		{
+			name: "the default of an empty env",
+			conf: Config{LateEnvInputs: "title", Title: "{{env:MISSING|none}}"},
+			want: Config{LateEnvInputs: "title", Title: "none"},
+		},
+		{
+			name: "the subject is never resolved",
+			conf: Config{Subject: "{{env:SECRET_TOKEN}}"},
+			want: Config{Subject: "{{env:SECRET_TOKEN}}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := tt.conf
+			if err := applyLateEnvs(&conf, getenv); err != nil {
+				t.Fatalf("applyLateEnvs() error = %v", err)
+			}
+			if conf != tt.want {
+				t.Errorf("applyLateEnvs() = %+v, want %+v", conf, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyLateEnvsRefusesCommitInputs(t *testing.T) {
+	for _, key := range []string{"subject", "author_name", "webhook_url"} {
+		conf := Config{LateEnvInputs: key}
+		if err := applyLateEnvs(&conf, func(string) string { return "" }); err == nil {
+			t.Errorf("applyLateEnvs() with %s listed: expected an error", key)
+		}
+	}
+}
+
+func TestApplyLateEnvsSkipsExpandedCommitEnvs(t *testing.T) {
+	getenv := func(key string) string {
+		switch key {
+		case "BITRISE_GIT_MESSAGE":
+			return "leak {{env:SECRET_TOKEN}}"
+		case "SECRET_TOKEN":
+			return "s3cr3t"
+		}
+		return ""
+	}
+	conf := Config{LateEnvInputs: "fields", Fields: "Message|leak {{env:SECRET_TOKEN}}"}
+	if err := applyLateEnvs(&conf, getenv); err != nil {
+		t.Fatalf("applyLateEnvs() error = %v", err)
+	}
+	if want := "Message|leak {{env:SECRET_TOKEN}}"; conf.Fields != want {
+		t.Errorf("fields = %q, want %q", conf.Fields, want)
+	}
+}
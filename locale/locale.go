@@ -0,0 +1,229 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package locale translates the labels the step adds to the messages itself.
+package locale
+
+// The keys of the labels.
+const (
+	FactApp             = "fact.app"
+	FactBuild           = "fact.build"
+	FactBranch          = "fact.branch"
+	FactWorkflow        = "fact.workflow"
+	FactCommit          = "fact.commit"
+	FactAuthor          = "fact.author"
+	FactDuration        = "fact.duration"
+	FactCorrelationID   = "fact.correlation_id"
+	FactBuildStatus     = "fact.build_status"
+	StatusSucceeded     = "status.succeeded"
+	StatusFailed        = "status.failed"
+	ButtonViewBuild     = "button.view_build"
+	SectionReleaseNotes = "section.release_notes"
+	SectionStages       = "section.stages"
+	SectionStackTrace   = "section.stack_trace"
+	SectionAnnouncement = "section.announcement"
+	SectionDetails      = "section.details"
+	SectionContinued    = "section.continued"
+	SectionChangelog    = "section.changelog"
+	ButtonShowDetails   = "button.show_details"
+	NoteTruncated       = "note.truncated"
+	NoteOmitted         = "note.omitted"
+	NoteWithheld        = "note.withheld"
+	NoteStale           = "note.stale"
+	FactQualityGate     = "fact.quality_gate"
+	QualityGatePassed   = "quality_gate.passed"
+	QualityGateFailed   = "quality_gate.failed"
+	ButtonAcknowledge   = "button.acknowledge"
+	SelftestTitle       = "selftest.title"
+	SelftestText        = "selftest.text"
+	FactSentAt          = "fact.sent_at"
+	SectionDigest       = "section.digest"
+	DigestCounts        = "digest.counts"
+)
+
+// Default is the language of the labels if no or an unsupported language is selected.
+const Default = "en"
+
+var translations = map[string]map[string]string{
+	"en": {
+		FactApp:             "App",
+		FactBuild:           "Build",
+		FactBranch:          "Branch",
+		FactWorkflow:        "Workflow",
+		FactCommit:          "Commit",
+		FactAuthor:          "Author",
+		FactDuration:        "Duration",
+		FactCorrelationID:   "Correlation ID",
+		ButtonViewBuild:     "View Build",
+		SectionReleaseNotes: "Release notes",
+		SectionStages:       "Stages",
+		SectionStackTrace:   "Stack trace",
+		SectionAnnouncement: "Announcement",
+		SectionDetails:      "Details",
+		ButtonShowDetails:   "Show details",
+		SectionContinued:    "… continued",
+		SectionChangelog:    "Changes",
+		FactBuildStatus:     "Build status",
+		StatusSucceeded:     "Succeeded",
+		StatusFailed:        "Failed",
+		NoteTruncated:       "… (message truncated)",
+		NoteOmitted:         "(some elements omitted)",
+		NoteWithheld:        "_Some details are withheld in this channel._",
+		NoteStale:           "⚠️ results are %s old",
+		FactQualityGate:     "Quality gate",
+		QualityGatePassed:   "PASSED",
+		QualityGateFailed:   "FAILED",
+		ButtonAcknowledge:   "Acknowledge",
+		SelftestTitle:       "Bitrise Teams step connectivity test",
+		SelftestText:        "The webhook is reachable from Bitrise.",
+		FactSentAt:          "Sent at",
+		SectionDigest:       "Digest",
+		DigestCounts:        "%d passed, %d failed",
+	},
+	"de": {
+		FactApp:             "App",
+		FactBuild:           "Build",
+		FactBranch:          "Branch",
+		FactWorkflow:        "Workflow",
+		FactCommit:          "Commit",
+		FactAuthor:          "Autor",
+		FactDuration:        "Dauer",
+		FactCorrelationID:   "Korrelations-ID",
+		ButtonViewBuild:     "Build anzeigen",
+		SectionReleaseNotes: "Versionshinweise",
+		SectionStages:       "Phasen",
+		SectionStackTrace:   "Stacktrace",
+		SectionAnnouncement: "Ankündigung",
+		SectionDetails:      "Details",
+		ButtonShowDetails:   "Details anzeigen",
+		SectionContinued:    "… Fortsetzung",
+		SectionChangelog:    "Änderungen",
+		FactBuildStatus:     "Build-Status",
+		StatusSucceeded:     "Erfolgreich",
+		StatusFailed:        "Fehlgeschlagen",
+		NoteTruncated:       "… (Nachricht gekürzt)",
+		NoteOmitted:         "(einige Elemente ausgelassen)",
+		NoteWithheld:        "_Einige Details werden in diesem Kanal nicht angezeigt._",
+		NoteStale:           "⚠️ die Ergebnisse sind %s alt",
+		FactQualityGate:     "Quality Gate",
+		QualityGatePassed:   "BESTANDEN",
+		QualityGateFailed:   "NICHT BESTANDEN",
+		ButtonAcknowledge:   "Bestätigen",
+		SelftestTitle:       "Verbindungstest des Bitrise-Teams-Steps",
+		SelftestText:        "Der Webhook ist von Bitrise aus erreichbar.",
+		FactSentAt:          "Gesendet am",
+		SectionDigest:       "Zusammenfassung",
+		DigestCounts:        "%d erfolgreich, %d fehlgeschlagen",
+	},
+	"fr": {
+		FactApp:             "App",
+		FactBuild:           "Build",
+		FactBranch:          "Branche",
+		FactWorkflow:        "Workflow",
+		FactCommit:          "Commit",
+		FactAuthor:          "Auteur",
+		FactDuration:        "Durée",
+		FactCorrelationID:   "ID de corrélation",
+		ButtonViewBuild:     "Voir le build",
+		SectionReleaseNotes: "Notes de version",
+		SectionStages:       "Étapes",
+		SectionStackTrace:   "Trace de la pile",
+		SectionAnnouncement: "Annonce",
+		SectionDetails:      "Détails",
+		ButtonShowDetails:   "Afficher les détails",
+		SectionContinued:    "… suite",
+		SectionChangelog:    "Modifications",
+		FactBuildStatus:     "Statut du build",
+		StatusSucceeded:     "Réussi",
+		StatusFailed:        "Échoué",
+		NoteTruncated:       "… (message tronqué)",
+		NoteOmitted:         "(certains éléments omis)",
+		NoteWithheld:        "_Certains détails ne sont pas affichés dans ce canal._",
+		NoteStale:           "⚠️ les résultats datent de %s",
+		FactQualityGate:     "Seuil de qualité",
+		QualityGatePassed:   "RÉUSSI",
+		QualityGateFailed:   "ÉCHOUÉ",
+		ButtonAcknowledge:   "Prendre en compte",
+		SelftestTitle:       "Test de connexion de l'étape Bitrise Teams",
+		SelftestText:        "Le webhook est accessible depuis Bitrise.",
+		FactSentAt:          "Envoyé le",
+		SectionDigest:       "Récapitulatif",
+		DigestCounts:        "%d réussis, %d échoués",
+	},
+	"es": {
+		FactApp:             "App",
+		FactBuild:           "Build",
+		FactBranch:          "Rama",
+		FactWorkflow:        "Workflow",
+		FactCommit:          "Commit",
+		FactAuthor:          "Autor",
+		FactDuration:        "Duración",
+		FactCorrelationID:   "ID de correlación",
+		ButtonViewBuild:     "Ver build",
+		SectionReleaseNotes: "Notas de la versión",
+		SectionStages:       "Etapas",
+		SectionStackTrace:   "Traza de la pila",
+		SectionAnnouncement: "Anuncio",
+		SectionDetails:      "Detalles",
+		ButtonShowDetails:   "Mostrar detalles",
+		SectionContinued:    "… continuación",
+		SectionChangelog:    "Cambios",
+		FactBuildStatus:     "Estado del build",
+		StatusSucceeded:     "Correcto",
+		StatusFailed:        "Fallido",
+		NoteTruncated:       "… (mensaje truncado)",
+		NoteOmitted:         "(algunos elementos omitidos)",
+		NoteWithheld:        "_Algunos detalles no se muestran en este canal._",
+		NoteStale:           "⚠️ los resultados tienen %s de antigüedad",
+		FactQualityGate:     "Umbral de calidad",
+		QualityGatePassed:   "SUPERADO",
+		QualityGateFailed:   "NO SUPERADO",
+		ButtonAcknowledge:   "Confirmar",
+		SelftestTitle:       "Prueba de conexión del paso de Bitrise Teams",
+		SelftestText:        "El webhook es accesible desde Bitrise.",
+		FactSentAt:          "Enviado el",
+		SectionDigest:       "Resumen",
+		DigestCounts:        "%d correctos, %d fallidos",
+	},
+}
+
+// Supported reports whether the labels are translated to the language.
+func Supported(lang string) bool {
+	_, ok := translations[lang]
+	return ok
+}
+
+// T returns the label of the key in the language, in English if the language is not supported.
+// An unknown key is returned as is.
+func T(lang, key string) string {
+	if s, ok := translations[lang][key]; ok {
+		return s
+	}
+	if s, ok := translations[Default][key]; ok {
+		return s
+	}
+	return key
+}
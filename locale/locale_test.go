@@ -0,0 +1,112 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package locale
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// declaredKeys returns the values of the key constants declared in locale.go.
+func declaredKeys(t *testing.T) []string {
+	f, err := parser.ParseFile(token.NewFileSet(), "locale.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, d := range f.Decls {
+		g, ok := d.(*ast.GenDecl)
+		if !ok || g.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range g.Specs {
+			for i, name := range spec.(*ast.ValueSpec).Names {
+				lit, ok := spec.(*ast.ValueSpec).Values[i].(*ast.BasicLit)
+				if !ok || name.Name == "Default" {
+					continue
+				}
+				key, err := strconv.Unquote(lit.Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+func TestEveryLanguageHasEveryKey(t *testing.T) {
+	keys := declaredKeys(t)
+	if len(keys) == 0 {
+		t.Fatal("no key is declared")
+	}
+	for lang, labels := range translations {
+		for _, key := range keys {
+			if strings.TrimSpace(labels[key]) == "" {
+				t.Errorf("%s: %s is missing", lang, key)
+			}
+		}
+		if len(labels) != len(keys) {
+			t.Errorf("%s: %d labels, %d keys are declared", lang, len(labels), len(keys))
+		}
+	}
+}
+
+func TestFormatVerbsMatchEnglish(t *testing.T) {
+	for lang, labels := range translations {
+		for key, label := range labels {
+			if want := strings.Count(translations[Default][key], "%s"); strings.Count(label, "%s") != want {
+				t.Errorf("%s: %s has %d %%s verbs, English has %d", lang, key, strings.Count(label, "%s"), want)
+			}
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	tests := []struct {
+		lang, key, want string
+	}{
+		{"de", FactAuthor, "Autor"},
+		{"xx", FactAuthor, "Author"},
+		{"de", "unknown.key", "unknown.key"},
+	}
+	for _, tt := range tests {
+		if got := T(tt.lang, tt.key); got != tt.want {
+			t.Errorf("T(%q, %q) = %q, want %q", tt.lang, tt.key, got, tt.want)
+		}
+	}
+	for _, lang := range []string{"en", "de", "fr", "es"} {
+		if !Supported(lang) {
+			t.Errorf("Supported(%q) = false", lang)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+// logFields are the structured fields of a log line.
+type logFields map[string]interface{}
+
+// stepLogger writes the log of the step, as text or as JSON lines for the log scrapers.
+type stepLogger interface {
+	Debugf(format string, v ...interface{})
+	Printf(format string, v ...interface{})
+	Donef(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+	// Record logs a line with structured fields. print writes its text form, it is nil if the
+	// line is not written to the text log.
+	Record(level, msg string, fields logFields, print func())
+}
+
+// logger is the logger of the step, it is replaced once the log format is known.
+var logger stepLogger = textLogger{}
+
+// logMu serializes the writes of the log lines, the webhooks are sent to in parallel.
+var logMu sync.Mutex
+
+// textLogger writes the colored text log of the go-utils log package.
+type textLogger struct{}
+
+func (textLogger) Debugf(format string, v ...interface{}) { log.Debugf(format, v...) }
+func (textLogger) Printf(format string, v ...interface{}) { log.Printf(format, v...) }
+func (textLogger) Donef(format string, v ...interface{})  { log.Donef(format, v...) }
+func (textLogger) Warnf(format string, v ...interface{})  { log.Warnf(format, v...) }
+func (textLogger) Errorf(format string, v ...interface{}) { log.Errorf(format, v...) }
+
+func (textLogger) Record(level, msg string, fields logFields, print func()) {
+	if print != nil {
+		print()
+	}
+}
+
+// jsonLogger writes every line as a JSON object with its level, message and fields.
+type jsonLogger struct {
+	w        io.Writer
+	debug    bool
+	warnings *int
+}
+
+func (l jsonLogger) Debugf(format string, v ...interface{}) { l.logf("debug", format, v...) }
+func (l jsonLogger) Printf(format string, v ...interface{}) { l.logf("info", format, v...) }
+func (l jsonLogger) Donef(format string, v ...interface{})  { l.logf("info", format, v...) }
+func (l jsonLogger) Warnf(format string, v ...interface{})  { l.logf("warn", format, v...) }
+func (l jsonLogger) Errorf(format string, v ...interface{}) { l.logf("error", format, v...) }
+
+func (l jsonLogger) logf(level, format string, v ...interface{}) {
+	l.Record(level, fmt.Sprintf(format, v...), nil, nil)
+}
+
+// colorCodes matches the color codes of the colorstring package.
+var colorCodes = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func (l jsonLogger) Record(level, msg string, fields logFields, print func()) {
+	if level == "debug" && !l.debug {
+		return
+	}
+	line := logFields{}
+	for k, v := range fields {
+		line[k] = v
+	}
+	line["level"] = level
+	line["msg"] = strings.TrimSpace(colorCodes.ReplaceAllString(msg, ""))
+	b, err := json.Marshal(line)
+	if err != nil {
+		b, _ = json.Marshal(logFields{"level": "error", "msg": fmt.Sprintf("failed to encode the log line: %s", err)})
+	}
+	logMu.Lock()
+	if level == "warn" && l.warnings != nil {
+		*l.warnings++
+	}
+	_, err = l.w.Write(append(b, '\n'))
+	logMu.Unlock()
+	if err != nil {
+		log.Errorf("Failed to write the log: %s", err)
+	}
+}
+
+// configFields returns the inputs of the config by their keys, the secrets are masked.
+func configFields(conf Config) logFields {
+	fields := logFields{}
+	v := reflect.ValueOf(conf)
+	for i := 0; i < v.NumField(); i++ {
+		key := strings.SplitN(v.Type().Field(i).Tag.Get("env"), ",", 2)[0]
+		if key != "" {
+			fields[key] = fmt.Sprint(v.Field(i).Interface())
+		}
+	}
+	return logFields{"config": fields}
+}
@@ -27,89 +27,481 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bitrise-io/go-utils/log"
 	"github.com/bitrise-tools/go-steputils/stepconf"
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
 )
 
 // Config ...
 type Config struct {
 	// Settings
-	Debug      bool            `env:"is_debug_mode,opt[yes,no]"`
-	WebhookURL stepconf.Secret `env:"webhook_url"`
+	Debug                  bool            `env:"is_debug_mode,opt[yes,no]"`
+	LogFormat              string          `env:"log_format,opt[text,json]"`
+	TroubleshootingDir     string          `env:"troubleshooting_dir"`
+	DryRun                 bool            `env:"is_dry_run,opt[yes,no]"`
+	DigestFile             string          `env:"digest_file"`
+	DigestMode             string          `env:"digest_mode,opt[collect,send]"`
+	Operation              string          `env:"operation,opt[send,probe,selftest,import,verify,check-ack]"`
+	BuildStatus            string          `env:"build_status,opt[auto,success,failed]"`
+	ForceSuccessLayout     bool            `env:"force_success_layout,opt[yes,no]"`
+	ForceErrorLayout       bool            `env:"force_error_layout,opt[yes,no]"`
+	FailOnDeprecated       bool            `env:"fail_on_deprecated,opt[yes,no]"`
+	WebhookURL             stepconf.Secret `env:"webhook_url"`
+	WebhookURLOnError      stepconf.Secret `env:"webhook_url_on_error"`
+	FailOnError            bool            `env:"fail_on_error,opt[yes,no]"`
+	FailOnPartialError     bool            `env:"fail_on_partial_error,opt[yes,no]"`
+	FailFast               bool            `env:"fail_fast,opt[yes,no]"`
+	MaxParallelRequests    int             `env:"max_parallel_requests"`
+	DegradeOnRejection     bool            `env:"degrade_on_rejection,opt[yes,no]"`
+	WebhookURLParams       string          `env:"webhook_url_params"`
+	AllowAnyWebhookHost    bool            `env:"allow_any_webhook_host,opt[yes,no]"`
+	CompressRequest        bool            `env:"compress_request,opt[yes,no]"`
+	CanonicalJSON          bool            `env:"canonical_json,opt[yes,no]"`
+	TimeoutSeconds         int             `env:"timeout_seconds"`
+	ProxyURL               stepconf.Secret `env:"proxy_url"`
+	RequestHeaders         stepconf.Secret `env:"request_headers"`
+	SkipTLSVerify          bool            `env:"skip_tls_verify,opt[yes,no]"`
+	MaxPayloadKB           int             `env:"max_payload_kb"`
+	SubshellTimeoutSeconds int             `env:"subshell_timeout_seconds"`
+	OnSubshellError        string          `env:"on_subshell_error,opt[fail,warn,empty]"`
+	EnableSubshells        bool            `env:"enable_subshells,opt[yes,no]"`
+	SubshellInputs         string          `env:"subshell_inputs"`
+	LateEnvInputs          string          `env:"late_env_inputs"`
+	RetryCount             int             `env:"retry_count"`
+	RetryWaitSeconds       int             `env:"retry_wait_seconds"`
+	IdempotencyKeyHeader   string          `env:"idempotency_key_header"`
+	SendOn                 string          `env:"send_on,opt[always,success,failure]"`
+	SuccessSamplingPercent int             `env:"success_sampling_percent"`
+	ImportCardPath         string          `env:"import_card_path"`
+	// Muting
+	MuteUntil          string `env:"mute_until"`
+	MuteFrom           string `env:"mute_from"`
+	MuteSchedule       string `env:"mute_schedule"`
+	MuteExemptFailures bool   `env:"mute_exempt_failures,opt[yes,no]"`
+	// Stale Build Guard
+	MaxBuildAgeMinutes int    `env:"max_build_age_minutes"`
+	OnStaleBuild       string `env:"on_stale_build,opt[annotate,skip]"`
 	// Message Main
-	ThemeColor        string `env:"theme_color"`
-	ThemeColorOnError string `env:"theme_color_on_error"`
-	Title             string `env:"title"`
-	TitleOnError      string `env:"title_on_error"`
+	CardFormat           string `env:"card_format,opt[messagecard,adaptivecard]"`
+	Language             string `env:"language"`
+	ThemeColor           string `env:"theme_color"`
+	ThemeColorOnError    string `env:"theme_color_on_error"`
+	Title                string `env:"title"`
+	TitleOnError         string `env:"title_on_error"`
+	EnableTitlePrefix    bool   `env:"enable_title_prefix,opt[yes,no]"`
+	TitlePrefixOnSuccess string `env:"title_prefix_on_success"`
+	TitlePrefixOnError   string `env:"title_prefix_on_error"`
+	Summary              string `env:"summary"`
+	EmojiCompatibility   string `env:"emoji_compatibility,opt[full,basic,strip]"`
+	CorrelationID        string `env:"correlation_id"`
+	ShowCorrelationID    bool   `env:"show_correlation_id,opt[yes,no]"`
 	// Message Git
-	AuthorName string `env:"author_name"`
-	Subject    string `env:"subject"`
+	AuthorName             string `env:"author_name"`
+	AuthorAvatarURL        string `env:"author_avatar_url"`
+	AuthorAvatarURLOnError string `env:"author_avatar_url_on_error"`
+	Subject                string `env:"subject"`
+	SubjectFilePath        string `env:"subject_file_path"`
+	CollapseAfterLines     int    `env:"collapse_after_lines"`
+	EscapeMarkdown         bool   `env:"escape_markdown,opt[yes,no]"`
+	ShortenURLs            bool   `env:"shorten_urls,opt[yes,no]"`
+	ShortenURLsLength      int    `env:"shorten_urls_length"`
 	// Message Content
-	Fields         string `env:"fields"`
-	Images         string `env:"images"`
-	ImagesOnError  string `env:"images_on_error"`
-	Buttons        string `env:"buttons"`
-	ButtonsOnError string `env:"buttons_on_error"`
+	EnableTemplates     bool   `env:"enable_templates,opt[yes,no]"`
+	InputFormat         string `env:"input_format,opt[simple,json]"`
+	Fields              string `env:"fields"`
+	FieldsFilePath      string `env:"fields_file_path"`
+	FieldsOnError       string `env:"fields_on_error"`
+	MaxFactsPerSection  int    `env:"max_facts_per_section"`
+	IncludeDefaultFacts bool   `env:"include_default_facts,opt[yes,no]"`
+	ShowBuildTime       bool   `env:"show_build_time,opt[yes,no]"`
+	ShowBuildStatusFact bool   `env:"show_build_status_fact,opt[yes,no]"`
+	BuildStartTime      string `env:"build_start_time"`
+	IncludeBuildButton  bool   `env:"include_build_button,opt[yes,no]"`
+	Stages              string `env:"stages"`
+	Sections            string `env:"sections"`
+	Images              string `env:"images"`
+	ImagesOnError       string `env:"images_on_error"`
+	HeroImageURL        string `env:"hero_image_url"`
+	HeroImageURLOnError string `env:"hero_image_url_on_error"`
+	Buttons             string `env:"buttons"`
+	ButtonsOnError      string `env:"buttons_on_error"`
+	Mentions            string `env:"mentions"`
+	MentionsOnError     string `env:"mentions_on_error"`
+	StackTrace          string `env:"stack_trace"`
+	StackTraceFrames    int    `env:"stack_trace_frames"`
+	BannerSource        string `env:"banner_source"`
+	// Release Notes
+	ReleaseNotesPath     string `env:"release_notes_path"`
+	ReleaseNotesRequired bool   `env:"release_notes_required,opt[yes,no]"`
+	// Changelog
+	ShowChangelog        bool   `env:"show_changelog,opt[yes,no]"`
+	ChangelogCommitCount int    `env:"changelog_commit_count"`
+	ChangelogFormat      string `env:"changelog_format,opt[oneline,full]"`
+	// Quality Gate
+	QualityGate          string `env:"quality_gate"`
+	QualityGateSetsColor bool   `env:"quality_gate_sets_color,opt[yes,no]"`
+	// Content Policy
+	ContentPolicy         string `env:"content_policy,opt[internal,restricted]"`
+	ContentPolicyDenylist string `env:"content_policy_denylist"`
+	// Outputs
+	ContentHashExclude string `env:"content_hash_exclude"`
+	// Audit
+	AuditLogPath string `env:"audit_log_path"`
+	AuditDetail  string `env:"audit_detail,opt[minimal,standard,full]"`
+	// Payload Signing
+	PayloadSigningKeyPath string          `env:"payload_signing_key_path"`
+	VerifyPayloadPath     string          `env:"verify_payload_path"`
+	SigningSecret         stepconf.Secret `env:"signing_secret"`
+	SignatureHeader       string          `env:"signature_header"`
+	// Graph API
+	DeliveryMethod   string          `env:"delivery_method,opt[webhook,graph]"`
+	GraphAccessToken stepconf.Secret `env:"graph_access_token"`
+	TeamID           string          `env:"team_id"`
+	ChannelID        string          `env:"channel_id"`
+	ReplyToMessageID string          `env:"reply_to_message_id"`
+	GraphAPIURL      string          `env:"graph_api_url"`
+	// Acknowledgement
+	AckURL       string `env:"ack_url"`
+	AckStatusURL string `env:"ack_status_url"`
+	AckToken     string `env:"ack_token"`
+	// Pull Request Comment
+	PRComment       bool            `env:"pr_comment,opt[yes,no]"`
+	PRCommentToken  stepconf.Secret `env:"pr_comment_token"`
+	PRCommentAPIURL string          `env:"pr_comment_api_url"`
+	// Image Verification
+	VerifyImageURLs      bool `env:"verify_image_urls,opt[yes,no]"`
+	DropUnverifiedImages bool `env:"drop_unverified_images,opt[yes,no]"`
 }
 
 // success is true if the build is successful, false otherwise.
-var success = os.Getenv("BITRISE_BUILD_STATUS") == "0"
+// It is resolved by resolveBuildStatus when the step starts.
+var success = true
 
-// selectValue chooses the right value based on the result of the build.
+// layoutSuccess is true if the message is laid out as a success: its title, colors, images
+// and buttons are those of a successful build. It is resolved by resolveLayout from success.
+var layoutSuccess = true
+
+// language is the language of the labels the step adds to the message.
+// It is set from the language input when the step starts.
+var language = locale.Default
+
+// label returns the label of the key in the selected language.
+func label(key string) string {
+	return locale.T(language, key)
+}
+
+// selectValue chooses the right value based on the layout of the message.
 func selectValue(ifSuccess, ifFailed string) string {
-	if success || ifFailed == "" {
+	if layoutSuccess || ifFailed == "" {
 		return ifSuccess
 	}
 	return ifFailed
 }
 
-// ensureNewlines replaces all \n substrings with newline characters.
-func ensureNewlines(s string) string {
-	return strings.Replace(s, "\\n", "\n", -1)
+// pairedInput is the value of an input which has an _on_error variant, Name is the input the
+// value comes from.
+type pairedInput struct {
+	Name  string
+	Value string
+}
+
+// selectInput chooses the input based on ok, the result of the build or the layout of the
+// message, like selectValue.
+func selectInput(ok bool, name, ifSuccess, ifFailed string) pairedInput {
+	if ok || ifFailed == "" {
+		return pairedInput{Name: name, Value: ifSuccess}
+	}
+	return pairedInput{Name: name + "_on_error", Value: ifFailed}
+}
+
+// selectedInputs are the inputs with an _on_error variant, chosen based on the result of the
+// build. The inputs of the look of the message are chosen based on its layout.
+type selectedInputs struct {
+	WebhookURL      pairedInput
+	ThemeColor      pairedInput
+	Title           pairedInput
+	AuthorAvatarURL pairedInput
+	HeroImageURL    pairedInput
+	Fields          pairedInput
+	Images          pairedInput
+	Buttons         pairedInput
+	Mentions        pairedInput
+}
+
+func selectInputs(c Config) selectedInputs {
+	return selectedInputs{
+		WebhookURL:      selectInput(success, "webhook_url", string(c.WebhookURL), string(c.WebhookURLOnError)),
+		ThemeColor:      selectInput(layoutSuccess, "theme_color", c.ThemeColor, c.ThemeColorOnError),
+		Title:           selectInput(layoutSuccess, "title", c.Title, c.TitleOnError),
+		AuthorAvatarURL: selectInput(layoutSuccess, "author_avatar_url", c.AuthorAvatarURL, c.AuthorAvatarURLOnError),
+		HeroImageURL:    selectInput(layoutSuccess, "hero_image_url", c.HeroImageURL, c.HeroImageURLOnError),
+		Fields:          selectInput(success, "fields", c.Fields, c.FieldsOnError),
+		Images:          selectInput(layoutSuccess, "images", c.Images, c.ImagesOnError),
+		Buttons:         selectInput(layoutSuccess, "buttons", c.Buttons, c.ButtonsOnError),
+		Mentions:        selectInput(success, "mentions", c.Mentions, c.MentionsOnError),
+	}
+}
+
+// defaultSummary is the summary of the messages without a summary and a title.
+const defaultSummary = "Result of Bitrise"
+
+// maxSummaryLength is the maximum length of a summary derived from the title.
+const maxSummaryLength = 100
+
+// summary returns the summary shown in the notifications: the summary input, the title if it
+// is empty, or the default summary. It is a single line of plain text.
+func summary(input, title string) string {
+	for _, s := range []string{input, title} {
+		s = strings.Join(strings.Fields(sanitizeText(normalizeText(s), false)), " ")
+		if s != "" {
+			return truncateGraphemes(s, maxSummaryLength)
+		}
+	}
+	return defaultSummary
 }
 
-func newMessage(c Config) Message {
+func newMessage(c Config) (Message, []error) {
+	in := selectInputs(c)
+	var errs []error
+	var facts []Fact
+	var images []Image
+	var actions []Action
+	if c.InputFormat == "json" {
+		// The inputs are validated by run, as are the sections.
+		facts, images, actions, _ = jsonInputs(in)
+	} else {
+		errs = checkPairs(in.Fields.Name, in.Fields.Value, false)
+		errs = append(errs, checkImages(in.Images.Name, in.Images.Value)...)
+		errs = append(errs, checkPairs(in.Buttons.Name, in.Buttons.Value, true)...)
+		var malformed []string
+		facts, images = parsesFacts(in.Fields.Value), parsesImages(in.Images.Value)
+		actions, malformed = parsesActions(in.Buttons.Value)
+		for _, line := range malformed {
+			logger.Warnf("%s line is not a title|url|POST|JSON body button, it is omitted: %s", in.Buttons.Name, line)
+		}
+	}
+	errs = append(errs, checkStages(c.Stages)...)
+
+	text, overflow := splitLines(normalizeText(c.Subject), c.CollapseAfterLines)
+	stages := parsesStages(c.Stages)
+	if len(stages) > 0 {
+		text = strings.TrimSpace(text + "\n\n" + stageBar(stages))
+	}
+
+	title := in.Title.Value
+	if c.EnableTitlePrefix {
+		title = withTitlePrefix(title, selectValue(c.TitlePrefixOnSuccess, c.TitlePrefixOnError))
+	}
+
 	msg := Message{
-		Context:    "https://schema.org/extension",
-		Type:       "MessageCard",
-		ThemeColor: selectValue(c.ThemeColor, c.ThemeColorOnError),
-		Title:      selectValue(c.Title, c.TitleOnError),
-		Summary:    "Result of Bitrise",
+		Context:       "https://schema.org/extension",
+		Type:          "MessageCard",
+		ThemeColor:    in.ThemeColor.Value,
+		Title:         title,
+		Summary:       summary(c.Summary, title),
+		CorrelationID: c.CorrelationID,
 		Sections: []Section{{
 			ActivityTitle: c.AuthorName,
-			ActivityText:  ensureNewlines(c.Subject),
-			Facts:         parsesFacts(c.Fields),
-			Images:        parsesImages(selectValue(c.Images, c.ImagesOnError)),
-			Actions:       parsesActions(selectValue(c.Buttons, c.ButtonsOnError)),
+			ActivityText:  text,
+			Facts:         facts,
+			Images:        images,
+			Actions:       actions,
 		}},
 	}
+	if overflow != "" {
+		msg.Sections = append(msg.Sections, detailsSection(overflow))
+	}
+	if len(stages) > 0 {
+		msg.Sections = append(msg.Sections, stagesSection(stages))
+	}
+	if c.InputFormat == "json" {
+		sections, _ := jsonSections(c.Sections)
+		msg.Sections = append(msg.Sections, sections...)
+	} else {
+		sections, sectionErrs := parsesSections(c.Sections)
+		msg.Sections = append(msg.Sections, sections...)
+		errs = append(errs, sectionErrs...)
+	}
+	if c.IncludeDefaultFacts {
+		msg.Sections[0].Facts = withDefaultFacts(msg.Sections[0].Facts, os.Getenv)
+	}
+	if c.ShowBuildTime {
+		start := c.BuildStartTime
+		if start == "" {
+			start = os.Getenv("BITRISE_BUILD_TRIGGER_TIMESTAMP")
+		}
+		if f, ok := durationFact(start, time.Now()); ok {
+			msg.Sections[0].Facts = append(msg.Sections[0].Facts, f)
+		}
+	}
+	if c.ShowBuildStatusFact {
+		msg.Sections[0].Facts = append(msg.Sections[0].Facts, Fact{Name: label(locale.FactBuildStatus), Value: label(buildStatusLabel(success))})
+	}
+	if c.IncludeBuildButton {
+		msg.Sections[0].Actions = withBuildButton(msg.Sections[0].Actions, strings.TrimSpace(os.Getenv("BITRISE_BUILD_URL")))
+	}
+	if avatar := strings.TrimSpace(in.AuthorAvatarURL.Value); avatar != "" {
+		if isWebURL(avatar) {
+			msg.Sections[0].ActivityImage = avatar
+		} else {
+			logger.Warnf("%s is not an http(s) URL, it is omitted: %s", in.AuthorAvatarURL.Name, avatar)
+		}
+	}
+	mentions, malformed := parsesMentions(in.Mentions.Value)
+	// A malformed mention would make Teams reject the card, so it is always worth a warning.
+	for _, line := range malformed {
+		logger.Warnf("Mentions line without a name and an email address, it is omitted: %s", line)
+	}
+	msg.Mentions = mentions
+	if c.ShowCorrelationID && c.CorrelationID != "" {
+		msg.Sections[0].Facts = append(msg.Sections[0].Facts, Fact{Name: label(locale.FactCorrelationID), Value: c.CorrelationID})
+	}
 
-	return msg
+	return msg, errs
 }
 
-// postMessage sends a message.
-func postMessage(conf Config, msg Message) error {
-	b, err := json.Marshal(msg)
-	if err != nil {
-		return err
+// marshalPayload returns the payload posted to the webhook in the configured card format,
+// with its keys sorted if canonical_json is enabled.
+func marshalPayload(conf Config, msg Message) ([]byte, error) {
+	var b []byte
+	var err error
+	switch {
+	case conf.DeliveryMethod == "graph":
+		b, err = newGraphMessage(msg)
+	case conf.CardFormat == "adaptivecard":
+		b, err = json.Marshal(newAdaptiveMessage(msg))
+	default:
+		b, err = json.Marshal(msg)
+	}
+	if err != nil || !conf.CanonicalJSON {
+		return b, err
+	}
+	return canonicalJSON(b)
+}
+
+// Sender posts requests to a webhook.
+type Sender struct {
+	Client *http.Client
+	URL    string
+	// Header is sent with every request, the headers of a request override it.
+	Header http.Header
+	// If SigningSecret is set, the HMAC-SHA256 of the body sent is set in the SignatureHeader.
+	SigningSecret   string
+	SignatureHeader string
+	// Target is the number of the webhook if the message is sent to several of them.
+	Target int
+}
+
+// newSender returns a Sender posting to the url with the given request timeout.
+func newSender(url string, timeout time.Duration) *Sender {
+	return &Sender{Client: &http.Client{Transport: transport, Timeout: timeout}, URL: url}
+}
+
+// send posts the JSON body with the given headers, gzip compressed if compress is set.
+// Requests which are idempotent may be retried after any network error.
+func (s *Sender) send(ctx context.Context, b []byte, header http.Header, compress, idempotent bool) (*http.Response, error) {
+	if compress {
+		var err error
+		if b, err = gzipBytes(b); err != nil {
+			return nil, permanent(fmt.Errorf("failed to compress the request: %s", err))
+		}
 	}
-	log.Debugf("Post Json Data: %s\n", b)
 
-	url := string(conf.WebhookURL)
-	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	req, err := http.NewRequestWithContext(ctx, "POST", s.URL, bytes.NewReader(b))
+	if err != nil {
+		return nil, permanent(fmt.Errorf("failed to create the request: %s", redactURLError(err)))
+	}
+	for k, vs := range s.Header {
+		req.Header[k] = vs
+	}
+	for k, vs := range header {
+		req.Header.Del(k)
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
 	req.Header.Add("Content-Type", "application/json; charset=utf-8")
-	client := &http.Client{}
+	if compress {
+		req.Header.Add("Content-Encoding", "gzip")
+	}
+	if s.SigningSecret != "" {
+		req.Header.Set(s.SignatureHeader, hmacSignature(s.SigningSecret, b))
+	}
 
-	resp, err := client.Do(req)
+	resp, err := s.Client.Do(req)
+	err = redactURLError(err)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		err = fmt.Errorf("request timed out after %s: %w", s.Client.Timeout, err)
+	}
+	if err != nil {
+		return nil, classifyNetworkError(err, idempotent)
+	}
+	return resp, nil
+}
+
+// postMessage sends a message. The idempotency key is sent in the configured header.
+func (s *Sender) postMessage(ctx context.Context, conf Config, msg Message, idempotencyKey string, report *RunReport) (err error) {
+	// The status of a previous attempt must not be taken for the status of this one.
+	report.ResponseStatus, report.ResponseBody = 0, ""
+	b, err := marshalPayload(conf, msg)
+	if err != nil {
+		return permanent(err)
+	}
+	report.Payload, report.PayloadSize = b, len(b)
+	logger.Debugf("Post Json Data: %s\n", b)
+
+	var resp *http.Response
+	if conf.TroubleshootingDir != "" {
+		defer func() {
+			attempt := strconv.Itoa(report.Attempts)
+			if s.Target > 0 {
+				attempt = fmt.Sprintf("%d-%d", s.Target, report.Attempts)
+			}
+			dumpAttempt(conf.TroubleshootingDir, attempt, s, b, resp, report.ResponseBody, err)
+		}()
+	}
+
+	graph := conf.DeliveryMethod == "graph"
+	compress := conf.CompressRequest && !graph && compressionSupported(s.URL)
+	if conf.CompressRequest && !compress {
+		logger.Debugf("Compression is not supported by the webhook host, sending the message uncompressed.\n")
+	}
+	header := http.Header{}
+	if graph {
+		header.Set("Authorization", "Bearer "+string(conf.GraphAccessToken))
+	}
+	if conf.CorrelationID != "" {
+		header.Set("X-Correlation-ID", conf.CorrelationID)
+	}
+	idempotent := conf.IdempotencyKeyHeader != "" && idempotencyKey != ""
+	if idempotent {
+		header.Set(conf.IdempotencyKeyHeader, idempotencyKey)
+	}
+	resp, err = s.send(ctx, b, header, compress, idempotent)
+	if err == nil && compress && resp.StatusCode == http.StatusUnsupportedMediaType {
+		if err := resp.Body.Close(); err != nil {
+			logger.Warnf("Failed to close response body: %s", err)
+		}
+		logger.Warnf("The server does not accept compressed requests, sending the message uncompressed.")
+		resp, err = s.send(ctx, b, header, false, idempotent)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to send the request: %s", err)
+		return err
 	}
 	defer func() {
 		if cerr := resp.Body.Close(); err == nil {
@@ -117,31 +509,271 @@ func postMessage(conf Config, msg Message) error {
 		}
 	}()
 
-	if resp.StatusCode != http.StatusOK {
+	report.ResponseStatus, report.ResponseBody = resp.StatusCode, ""
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return fmt.Errorf("server error: %s, failed to read response: %s", resp.Status, err)
+			return classifyStatus(resp.StatusCode, fmt.Errorf("server error: %s, failed to read response: %s", resp.Status, err), idempotent)
 		}
-		return fmt.Errorf("server error: %s, response: %s", resp.Status, body)
+		report.ResponseBody = string(body)
+		return classifyStatus(resp.StatusCode, translateError(resp.Status, body), idempotent)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	report.ResponseBody = string(body)
+	if err != nil {
+		return transient(fmt.Errorf("failed to read response: %s", err))
+	}
+	// The Workflows webhooks accept the message asynchronously, the body reports nothing.
+	if resp.StatusCode == http.StatusAccepted {
+		return nil
+	}
+	if isHTMLResponse(resp.Header.Get("Content-Type"), body) {
+		return permanent(errHTMLResponse)
+	}
+	if err := failedDelivery(body); err != nil {
+		return transient(err)
+	}
+	if graph {
+		report.MessageID, report.MessageLink = graphMessageIdentity(report.ResponseBody)
+		logger.Debugf("Created message: %s\n", orDash(report.MessageID))
 	}
 
 	return nil
 }
 
-func main() {
+// dryRun prints the payload instead of posting it and returns the exit code of the step.
+func dryRun(conf Config, msg Message, report *RunReport) int {
+	b, err := marshalPayload(conf, msg)
+	if err != nil {
+		logger.Errorf("Error: %s", err)
+		return 1
+	}
+	report.PayloadSize = len(b)
+	var out bytes.Buffer
+	if err := json.Indent(&out, b, "", "  "); err != nil {
+		logger.Errorf("Error: %s", err)
+		return 1
+	}
+	logger.Printf("Dry run, the message is not sent:\n%s", out.String())
+	report.Status = "dry run"
+	return 0
+}
+
+// run sends the message, recording the details of the run in the report, and returns
+// the exit code of the step.
+func run(report *RunReport) int {
 	var conf Config
 	if err := stepconf.Parse(&conf); err != nil {
-		log.Errorf("Error: %s\n", err)
-		os.Exit(1)
+		logger.Errorf("Error: %s\n", err)
+		return 1
+	}
+	if err := applyDeprecations(&conf, deprecations, os.Getenv); err != nil {
+		logger.Errorf("Error: %s\n", err)
+		return 1
 	}
-	stepconf.Print(conf)
 	log.SetEnableDebugLog(conf.Debug)
+	if conf.LogFormat == "json" {
+		logger = jsonLogger{w: os.Stdout, debug: conf.Debug, warnings: &report.Warnings}
+	}
+	// The status is resolved once, the templates and the message use the same status.
+	var source string
+	success, source = resolveBuildStatus(conf.BuildStatus, os.Getenv)
+	var err error
+	if layoutSuccess, err = resolveLayout(conf.ForceSuccessLayout, conf.ForceErrorLayout, success); err != nil {
+		logger.Errorf("Error: %s", err)
+		return 1
+	}
+
+	if missing := missingCapabilities(capabilities, exec.LookPath); len(missing) > 0 {
+		logger.Warnf("%s\n", disableCapabilities(missing))
+	}
+	if conf.EnableTemplates {
+		if err := applyTemplates(&conf, newTemplateData(os.Environ(), success), conf.Debug, os.Getenv); err != nil {
+			logger.Errorf("Error: %s\n", err)
+			return 1
+		}
+	}
+	if conf.EnableSubshells {
+		runCommand := cachedShellCommands(runShellCommand, time.Duration(conf.SubshellTimeoutSeconds)*time.Second)
+		if conf.OnSubshellError != "fail" {
+			runCommand = ignoreShellErrors(runCommand, conf.OnSubshellError == "warn")
+		}
+		if err := applySubshells(&conf, runCommand, os.Getenv); err != nil {
+			logger.Errorf("Error: %s\n", err)
+			return 1
+		}
+	} else if conf.SubshellInputs != "" {
+		logger.Warnf("subshell_inputs is set but enable_subshells is disabled, no command is run.")
+	}
+	if err := applyLateEnvs(&conf, os.Getenv); err != nil {
+		logger.Errorf("Error: %s\n", err)
+		return 1
+	}
+	if locale.Supported(conf.Language) {
+		language = conf.Language
+	} else {
+		logger.Warnf("Language %q is not supported, the labels are in English.", conf.Language)
+	}
+	if dir := os.Getenv("BITRISE_DEPLOY_DIR"); conf.TroubleshootingDir == "" && dir != "" {
+		conf.TroubleshootingDir = filepath.Join(dir, "teams-step")
+	}
+	resolveFileInputs(&conf, os.Getenv)
+	if err := readInputFiles(&conf); err != nil {
+		logger.Errorf("Error: %s\n", err)
+		return 1
+	}
+	logger.Record("info", "Config", configFields(conf), func() { stepconf.Print(conf) })
+
+	requestHeaders, err := parseRequestHeaders(string(conf.RequestHeaders))
+	if err != nil {
+		logger.Errorf("Error: %s", err)
+		return 1
+	}
+	if conf.SigningSecret != "" && !headerNamePattern.MatchString(conf.SignatureHeader) {
+		logger.Errorf("Error: signature_header is not a valid header name: %q", conf.SignatureHeader)
+		return 1
+	}
+	t, err := newTransport(string(conf.ProxyURL), conf.SkipTLSVerify)
+	if err != nil {
+		logger.Errorf("Error: %s", err)
+		return 1
+	}
+	transport = t
+	if conf.SkipTLSVerify {
+		logger.Warnf("TLS certificates are NOT verified, the messages may be read or modified by anyone on the network. Only use skip_tls_verify behind a TLS-intercepting proxy.")
+	}
 
-	msg := newMessage(conf)
-	if err := postMessage(conf, msg); err != nil {
-		log.Errorf("Error: %s", err)
-		os.Exit(1)
+	if conf.Operation == "import" {
+		report.Status = "imported"
+		return runImport(conf.ImportCardPath)
 	}
+	if conf.Operation == "verify" {
+		report.Status = "verified"
+		return runVerify(conf.PayloadSigningKeyPath, conf.VerifyPayloadPath)
+	}
+	if conf.Operation == "check-ack" {
+		report.Status = "checked acknowledgement"
+		return runCheckAck(conf)
+	}
+
+	if success {
+		logger.Printf("Build status: success (determined by %s)", source)
+	} else {
+		logger.Printf("Build status: failed (determined by %s)", source)
+	}
+	if layoutSuccess != success && layoutSuccess {
+		logger.Printf("The message is laid out as a success, forced by force_success_layout.")
+	} else if layoutSuccess != success {
+		logger.Printf("The message is laid out as a failure, forced by force_error_layout.")
+	}
+
+	var urls []string
+	if conf.DeliveryMethod == "graph" {
+		if err := checkGraphInputs(conf); err != nil {
+			logger.Errorf("Error: %s", err)
+			return 1
+		}
+		if conf.Operation == "probe" {
+			logger.Errorf("Error: the probe operation supports the webhook delivery method only")
+			return 1
+		}
+		// The Graph API accepts Adaptive Cards only.
+		conf.CardFormat = "adaptivecard"
+		urls = []string{graphMessagesURL(conf.GraphAPIURL, conf.TeamID, conf.ChannelID, conf.ReplyToMessageID)}
+	} else if urls, err = resolveWebhookURLs(selectInputs(conf).WebhookURL.Value, conf.WebhookURLParams, conf.AllowAnyWebhookHost); err != nil {
+		logger.Errorf("Error: %s", err)
+		return 1
+	}
+	if len(urls) == 0 && !conf.DryRun {
+		logger.Errorf("Error: webhook_url is required")
+		return 1
+	}
+	conf.WebhookURL = stepconf.Secret(strings.Join(urls, "\n"))
+
+	var hosts []string
+	for _, u := range urls {
+		hosts = append(hosts, redactedHost(u))
+	}
+	report.Host = strings.Join(hosts, ", ")
+	report.CorrelationID = conf.CorrelationID
+	if err := exportEnv("TEAMS_MESSAGE_CORRELATION_ID", conf.CorrelationID); err != nil {
+		logger.Warnf("%s", err)
+	}
+	if conf.Operation == "probe" {
+		report.Status = "probed"
+		return runProbe(conf, urls, requestHeaders)
+	}
+	if conf.Operation == "selftest" {
+		report.CardFormat = "MessageCard"
+		if conf.CardFormat == "adaptivecard" {
+			report.CardFormat = "AdaptiveCard"
+		}
+		return runSelftest(conf, urls, requestHeaders, report)
+	}
+
+	if !conf.DryRun && (conf.SendOn == "success" && !success || conf.SendOn == "failure" && success) {
+		logger.Printf("The build status does not match send_on: %s, the message is not sent.", conf.SendOn)
+		if err := exportEnv("TEAMS_MESSAGE_STATUS", "skipped"); err != nil {
+			logger.Warnf("%s", err)
+		}
+		report.Status = "skipped"
+		return 0
+	}
+
+	until, muted, err := skipMuted(conf, success, time.Now())
+	if err != nil {
+		logger.Errorf("Error: %s", err)
+		return 1
+	}
+	if muted {
+		logger.Printf("Notifications are muted until %s, the message is not sent.", until.Format(time.RFC3339))
+		if err := exportEnv("TEAMS_MESSAGE_STATUS", "muted"); err != nil {
+			logger.Warnf("%s", err)
+		}
+		report.Status = "muted"
+		return 0
+	}
+
+	if success && !conf.DryRun && !sampled(os.Getenv("BITRISE_BUILD_SLUG"), conf.SuccessSamplingPercent) {
+		logger.Printf("The build is not sampled for notification, the message is not sent.")
+		if err := exportEnv("TEAMS_MESSAGE_STATUS", "sampled_out"); err != nil {
+			logger.Warnf("%s", err)
+		}
+		report.Status = "sampled out"
+		return 0
+	}
+
+	p := &sendPipeline{conf: conf, report: report, urls: urls, header: requestHeaders}
+	return p.run()
+}
+
+// deliveryResult returns the exit code and the status of the step once the message was sent to
+// sent of the total webhooks.
+func deliveryResult(sent, total int, failOnError, failOnPartialError bool) (int, string) {
+	switch {
+	case sent == total:
+		return 0, "sent"
+	case failOnError && (sent == 0 || failOnPartialError):
+		return 1, "failed"
+	case sent > 0:
+		return 0, "partially sent"
+	default:
+		return 0, "not sent"
+	}
+}
+
+func main() {
+	start := time.Now()
+	report := &RunReport{Status: "failed"}
+	log.SetOutWriter(warningCounter{w: os.Stdout, report: report})
+
+	code := run(report)
 
-	log.Donef("\nMessage successfully sent! 🚀\n")
+	report.Duration = time.Since(start)
+	logger.Record("info", "Summary", report.fields(), func() {
+		fmt.Println()
+		fmt.Print(report)
+	})
+	os.Exit(code)
 }
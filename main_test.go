@@ -0,0 +1,108 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDeliveryResult(t *testing.T) {
+	tests := []struct {
+		sent, total                     int
+		failOnError, failOnPartialError bool
+		wantCode                        int
+		wantStatus                      string
+	}{
+		{1, 1, true, false, 0, "sent"},
+		{1, 1, false, false, 0, "sent"},
+		{3, 3, true, true, 0, "sent"},
+		{0, 1, true, false, 1, "failed"},
+		{0, 1, false, false, 0, "not sent"},
+		{0, 3, false, true, 0, "not sent"},
+		{2, 3, true, false, 0, "partially sent"},
+		{2, 3, true, true, 1, "failed"},
+		{2, 3, false, true, 0, "partially sent"},
+	}
+	for _, tt := range tests {
+		code, status := deliveryResult(tt.sent, tt.total, tt.failOnError, tt.failOnPartialError)
+		if code != tt.wantCode || status != tt.wantStatus {
+			t.Errorf("deliveryResult(%d, %d, %v, %v) = %d, %q, want %d, %q",
+				tt.sent, tt.total, tt.failOnError, tt.failOnPartialError, code, status, tt.wantCode, tt.wantStatus)
+		}
+	}
+}
+
+func TestSoftDeliveryFailure(t *testing.T) {
+	tests := []struct {
+		name        string
+		failOnError bool
+		wantCode    int
+		wantLevel   string
+	}{
+		{"fails the step", true, 1, `"level":"error"`},
+		{"soft failure", false, 0, `"level":"warn"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BITRISE_DEPLOY_DIR", t.TempDir())
+			exported := captureOutputs(t)
+			var log bytes.Buffer
+			saved := logger
+			logger = jsonLogger{w: &log, debug: true}
+			t.Cleanup(func() { logger = saved })
+			srv := webhookReplies(http.StatusBadRequest)
+			defer srv.Close()
+
+			p := &sendPipeline{
+				conf:   Config{Title: "Build Succeeded!", Subject: "Fix the login", FailOnError: tt.failOnError, MaxPayloadKB: 25},
+				report: &RunReport{},
+				urls:   []string{srv.URL},
+			}
+			if code := p.run(); code != tt.wantCode {
+				t.Errorf("run() = %d, want %d", code, tt.wantCode)
+			}
+			if exported["TEAMS_MESSAGE_SENT"] != "false" || exported["TEAMS_RESPONSE_STATUS"] != "400" {
+				t.Errorf("outputs %v, want the message not sent with the response status 400", exported)
+			}
+			var delivery string
+			for _, line := range strings.Split(log.String(), "\n") {
+				if strings.Contains(line, "Bad Request") && !strings.Contains(line, `"level":"debug"`) {
+					delivery = line
+				}
+			}
+			if !strings.Contains(delivery, tt.wantLevel) {
+				t.Errorf("delivery error logged as %q, want %s", delivery, tt.wantLevel)
+			}
+			if !strings.Contains(log.String(), "Last response: status 400, body: Bad Request") {
+				t.Errorf("log %s, want the last response at debug level", log.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxEnvValueLength is the size of the exported markdown which fits envman's value size limit.
+const maxEnvValueLength = 16 * 1024
+
+// renderMarkdown renders the message as markdown text, eg. for clients which can't render cards.
+func renderMarkdown(msg Message) string {
+	var blocks []string
+	if msg.Title != "" {
+		blocks = append(blocks, "**"+msg.Title+"**")
+	}
+	for _, s := range msg.Sections {
+		var lines []string
+		if s.Title != "" {
+			lines = append(lines, "**"+s.Title+"**")
+		}
+		if s.ActivityTitle != "" {
+			lines = append(lines, "*"+s.ActivityTitle+"*")
+		}
+		for _, text := range []string{s.ActivityText, s.Text} {
+			if text != "" {
+				lines = append(lines, text)
+			}
+		}
+		for _, f := range s.Facts {
+			lines = append(lines, fmt.Sprintf("- **%s**: %s", f.Name, f.Value))
+		}
+		if s.HeroImage != nil {
+			lines = append(lines, fmt.Sprintf("![%s](%s)", s.HeroImage.Title, s.HeroImage.URL))
+		}
+		for _, img := range s.Images {
+			line := fmt.Sprintf("![%s](%s)", img.Title, img.URL)
+			if img.Link != "" {
+				line = fmt.Sprintf("[%s](%s)", line, img.Link)
+			}
+			lines = append(lines, line)
+		}
+		var links []string
+		for _, a := range s.Actions {
+			for _, t := range a.Targets {
+				links = append(links, fmt.Sprintf("[%s](%s)", a.Name, t.URI))
+			}
+		}
+		if len(links) > 0 {
+			lines = append(lines, strings.Join(links, " | "))
+		}
+		if len(lines) > 0 {
+			blocks = append(blocks, strings.Join(lines, "\n"))
+		}
+	}
+	return strings.Join(blocks, "\n\n")
+}
+
+// truncateBytes shortens s to at most n bytes without splitting a multi-byte character.
+func truncateBytes(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// exportMarkdown writes the markdown rendition of the message to a file and exports both its
+// path and its content, truncated to the env size limit with a pointer to the file.
+func exportMarkdown(msg Message) error {
+	md := renderMarkdown(msg)
+	path := outputPath("teams-message.md")
+	if err := writeFileAtomic(path, []byte(md), 0600); err != nil {
+		return fmt.Errorf("failed to write markdown: %s", err)
+	}
+	if err := exportEnv("TEAMS_MESSAGE_MARKDOWN_PATH", path); err != nil {
+		return err
+	}
+
+	if len(md) > maxEnvValueLength {
+		suffix := fmt.Sprintf("\n\n… (truncated, see %s)", path)
+		md = truncateBytes(md, maxEnvValueLength-len(suffix)) + suffix
+	}
+	return exportEnv("TEAMS_MESSAGE_MARKDOWN", md)
+}
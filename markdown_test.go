@@ -0,0 +1,134 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	msg := Message{Title: "Build Failed!", Sections: []Section{
+		{ActivityTitle: "Jane", ActivityText: "Fix the login", Facts: []Fact{{Name: "Branch", Value: "main"}}},
+		{Images: []Image{{Title: "Icon", URL: "https://example.com/icon.png", Link: "https://example.com"}}},
+		{Actions: []Action{{Name: "Open", Targets: []Target{{OS: "default", URI: "https://example.com/build"}}}}},
+		{},
+	}}
+	want := "**Build Failed!**\n\n*Jane*\nFix the login\n- **Branch**: main\n\n" +
+		"[![Icon](https://example.com/icon.png)](https://example.com)\n\n[Open](https://example.com/build)"
+	if got := renderMarkdown(msg); got != want {
+		t.Errorf("renderMarkdown() = %q, want %q", got, want)
+	}
+}
+
+func TestTruncateBytes(t *testing.T) {
+	tests := []struct {
+		s    string
+		n    int
+		want string
+	}{
+		{"abc", 5, "abc"},
+		{"abc", 3, "abc"},
+		{"abcdef", 3, "abc"},
+		{"aé", 2, "a"},
+		{"🚀🚀", 5, "🚀"},
+		{"🚀", 3, ""},
+	}
+	for _, tt := range tests {
+		if got := truncateBytes(tt.s, tt.n); got != tt.want {
+			t.Errorf("truncateBytes(%q, %d) = %q, want %q", tt.s, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestExportMarkdown(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		truncated bool
+	}{
+		{"short", "Fix the login", false},
+		{"at the limit", strings.Repeat("a", maxEnvValueLength-len("**T**\n\n")), false},
+		{"over the limit", strings.Repeat("é", maxEnvValueLength), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exported := captureOutputs(t)
+			dir := t.TempDir()
+			t.Setenv("BITRISE_DEPLOY_DIR", dir)
+			msg := Message{Title: "T", Sections: []Section{{Text: tt.text}}}
+
+			if err := exportMarkdown(msg); err != nil {
+				t.Fatalf("exportMarkdown() error = %v", err)
+			}
+			path := filepath.Join(dir, "teams-message.md")
+			if got := exported["TEAMS_MESSAGE_MARKDOWN_PATH"]; got != path {
+				t.Errorf("TEAMS_MESSAGE_MARKDOWN_PATH = %q, want %q", got, path)
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != renderMarkdown(msg) {
+				t.Errorf("the file does not hold the whole markdown, got %d bytes", len(b))
+			}
+
+			md := exported["TEAMS_MESSAGE_MARKDOWN"]
+			if len(md) > maxEnvValueLength {
+				t.Errorf("TEAMS_MESSAGE_MARKDOWN is %d bytes, want at most %d", len(md), maxEnvValueLength)
+			}
+			pointer := "… (truncated, see " + path + ")"
+			if got := strings.HasSuffix(md, pointer); got != tt.truncated {
+				t.Errorf("TEAMS_MESSAGE_MARKDOWN ends with the pointer = %t, want %t", got, tt.truncated)
+			}
+			if !tt.truncated && md != string(b) {
+				t.Errorf("TEAMS_MESSAGE_MARKDOWN = %q, want the whole markdown", md)
+			}
+		})
+	}
+}
+
+func TestDryRunExportsMarkdown(t *testing.T) {
+	exported := captureOutputs(t)
+	t.Setenv("BITRISE_DEPLOY_DIR", t.TempDir())
+	p := &sendPipeline{conf: Config{DryRun: true, Title: "Build Succeeded!", Subject: "Fix the login"}, report: &RunReport{}}
+
+	if code := p.run(); code != 0 {
+		t.Fatalf("run() = %d, want 0", code)
+	}
+	if p.report.Status != "dry run" {
+		t.Errorf("Status = %q, want %q", p.report.Status, "dry run")
+	}
+	if got := exported["TEAMS_MESSAGE_MARKDOWN"]; !strings.Contains(got, "Fix the login") {
+		t.Errorf("TEAMS_MESSAGE_MARKDOWN = %q, want the dry run message", got)
+	}
+	if exported["TEAMS_MESSAGE_CONTENT_HASH"] == "" {
+		t.Errorf("TEAMS_MESSAGE_CONTENT_HASH is not exported")
+	}
+}
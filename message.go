@@ -26,7 +26,11 @@ SOFTWARE.
 package main
 
 import (
+	"encoding/json"
 	"strings"
+	"unicode"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
 )
 
 // See also: https://docs.microsoft.com/en-us/outlook/actionable-messages/message-card-reference#actions
@@ -37,36 +41,168 @@ type Message struct {
 	Title      string    `json:"title,omitempty"`
 	Summary    string    `json:"summary,omitempty"`
 	Sections   []Section `json:"sections,omitempty"`
+	// CorrelationID is not shown, it identifies the message for the tools processing it.
+	CorrelationID string `json:"correlationId,omitempty"`
+	// Mentions are supported by Adaptive Cards only.
+	Mentions []Mention `json:"-"`
 }
 
 type Section struct {
-	ActivityTitle string   `json:"activityTitle,omitempty"`
-	ActivityText  string   `json:"activityText,omitempty"`
-	Facts         []Fact   `json:"facts,omitempty"`
-	Images        []Image  `json:"images,omitempty"`
-	Actions       []Action `json:"potentialAction,omitempty"`
+	Title         string `json:"title,omitempty"`
+	ActivityTitle string `json:"activityTitle,omitempty"`
+	ActivityImage string `json:"activityImage,omitempty"`
+	ActivityText  string `json:"activityText,omitempty"`
+	Text          string `json:"text,omitempty"`
+	HeroImage     *Image `json:"heroImage,omitempty"`
+	StartGroup    bool   `json:"startGroup,omitempty"`
+	// Collapsed sections are hidden behind a Show details button in the Adaptive Cards.
+	Collapsed bool `json:"-"`
+	// Formatted sections hold the markdown generated by the step, it is not normalized or
+	// escaped again.
+	Formatted bool `json:"-"`
+	// Excerpt sections quote the logs or the files of the build, eg. a stack trace.
+	Excerpt bool     `json:"-"`
+	Facts   []Fact   `json:"facts,omitempty"`
+	Images  []Image  `json:"images,omitempty"`
+	Actions []Action `json:"potentialAction,omitempty"`
 }
 
 type Fact struct {
 	Name  string `json:"name"`
 	Value string `json:"value"`
+	// Formatted values were formatted by a modifier, their markdown is not escaped again.
+	Formatted bool `json:"-"`
+}
+
+// splitFacts moves the facts of the sections after the first n facts to the sections inserted
+// after them, titled as continued, so long lists of facts are not cut by Teams. The other
+// contents of a section stay in its first part. n <= 0 never splits.
+func splitFacts(sections []Section, n int) []Section {
+	if n <= 0 {
+		return sections
+	}
+	var split []Section
+	for _, s := range sections {
+		facts := s.Facts
+		if len(facts) > n {
+			s.Facts = facts[:n]
+		}
+		split = append(split, s)
+		for i := n; i < len(facts); i += n {
+			end := i + n
+			if end > len(facts) {
+				end = len(facts)
+			}
+			split = append(split, Section{Title: label(locale.SectionContinued), Facts: facts[i:end]})
+		}
+	}
+	return split
 }
 
+// parsesFacts parses name|value lines. A value ending with |code is shown as code and a value
+// ending with |raw is shown as written, its markdown characters are escaped.
 func parsesFacts(s string) (fs []Fact) {
 	for _, p := range pairs(s) {
-		fs = append(fs, Fact{Name: p[0], Value: p[1]})
+		f := Fact{Name: p[0], Value: p[1]}
+		if i := strings.LastIndex(f.Value, "|"); i >= 0 {
+			value := sanitizeText(normalizeText(trimBlank(f.Value[:i])), false)
+			switch trimBlank(f.Value[i+1:]) {
+			case "code":
+				f.Value, f.Formatted = codeValue(value), true
+			case "raw":
+				f.Value, f.Formatted = markdownEscaper.Replace(value), true
+			}
+		}
+		fs = append(fs, f)
 	}
 	return
 }
 
+// codeValue wraps the value in a code span, or in a fenced code block if it has several lines.
+// The backtick fence is longer than the backtick runs of the value.
+func codeValue(s string) string {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if strings.Contains(s, "\n") {
+		n := longest + 1
+		if n < 3 {
+			n = 3
+		}
+		fence := strings.Repeat("`", n)
+		return fence + "\n" + s + "\n" + fence
+	}
+	fence := strings.Repeat("`", longest+1)
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return fence + s + fence
+}
+
 type Image struct {
 	URL   string `json:"image"`
 	Title string `json:"title"`
+	// Link is opened by clicking the image, the MessageCards can't link an image.
+	Link string `json:"-"`
+}
+
+// splitImageRow splits a line of the images input on its pipes, `\|` is a pipe of the caption.
+func splitImageRow(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '|':
+			field.WriteByte('|')
+			i++
+		case line[i] == '|':
+			fields = append(fields, trimBlank(field.String()))
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(fields, trimBlank(field.String()))
+}
+
+// parseImageRow parses a url, url|caption or url|caption|link_url line of the images input, or
+// a title|url or title|url|link_url line. It returns false if the line has no image URL.
+func parseImageRow(line string) (Image, bool) {
+	f := splitImageRow(line)
+	var img Image
+	switch {
+	case isWebURL(f[0]):
+		img.URL = f[0]
+		if len(f) > 1 {
+			img.Title = f[1]
+		}
+	case len(f) > 1 && f[0] != "" && f[1] != "":
+		img.Title, img.URL = f[0], f[1]
+	default:
+		return Image{}, false
+	}
+	if len(f) > 2 {
+		img.Link = f[2]
+	}
+	return img, true
 }
 
 func parsesImages(s string) (is []Image) {
-	for _, p := range pairs(s) {
-		is = append(is, Image{Title: p[0], URL: p[1]})
+	for _, line := range strings.Split(s, "\n") {
+		if line = trimBlank(line); line == "" {
+			continue
+		}
+		if img, ok := parseImageRow(line); ok {
+			is = append(is, img)
+		}
 	}
 	return
 }
@@ -75,6 +211,9 @@ type Action struct {
 	Type    string   `json:"@type"`
 	Name    string   `json:"name"`
 	Targets []Target `json:"targets,omitempty"`
+	// Target and Body are those of an HttpPOST action.
+	Target string `json:"target,omitempty"`
+	Body   string `json:"body,omitempty"`
 }
 
 type Target struct {
@@ -82,16 +221,80 @@ type Target struct {
 	URI string `json:"uri"`
 }
 
-func parsesActions(s string) (as []Action) {
-	for _, p := range pairs(s) {
-		as = append(as, Action{
-			Type: "OpenUri",
-			Name: p[0],
-			Targets: []Target{{
-				OS:  "default",
-				URI: p[1],
-			}},
-		})
+// isHTTPPOSTButton reports whether the line of the buttons input is a title|url|POST|body button.
+func isHTTPPOSTButton(line string) bool {
+	a := strings.SplitN(line, "|", 4)
+	return len(a) == 4 && a[2] == "POST"
+}
+
+// parsesActions parses title|url lines as OpenUri buttons and title|url|POST|body lines as
+// HttpPOST buttons posting the JSON body to the url. The malformed HttpPOST lines are returned
+// as malformed.
+func parsesActions(s string) (as []Action, malformed []string) {
+	for _, line := range strings.Split(s, "\n") {
+		line = trimBlank(line)
+		if isHTTPPOSTButton(line) {
+			a := strings.SplitN(line, "|", 4)
+			if a[0] == "" || !isWebURL(a[1]) || !json.Valid([]byte(a[3])) {
+				malformed = append(malformed, line)
+				continue
+			}
+			as = append(as, Action{Type: "HttpPOST", Name: a[0], Target: a[1], Body: a[3]})
+			continue
+		}
+		for _, p := range pairs(line) {
+			as = append(as, Action{
+				Type: "OpenUri",
+				Name: p[0],
+				Targets: []Target{{
+					OS:  "default",
+					URI: p[1],
+				}},
+			})
+		}
+	}
+	return
+}
+
+// withBuildButton appends a View Build button linking to the url, unless it is empty or a
+// button already links to it.
+func withBuildButton(as []Action, url string) []Action {
+	if url == "" {
+		return as
+	}
+	for _, a := range as {
+		for _, t := range a.Targets {
+			if t.URI == url {
+				return as
+			}
+		}
+	}
+	return append(as, Action{
+		Type:    "OpenUri",
+		Name:    label(locale.ButtonViewBuild),
+		Targets: []Target{{OS: "default", URI: url}},
+	})
+}
+
+// Mention is a user mentioned in the card, ID is their email address or user ID.
+type Mention struct {
+	Name string
+	ID   string
+}
+
+// parsesMentions parses `Display Name|user@company.com` lines, the lines without an email
+// address or a user ID are returned as malformed.
+func parsesMentions(s string) (ms []Mention, malformed []string) {
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		a := strings.SplitN(line, "|", 2)
+		if len(a) != 2 || strings.TrimSpace(a[0]) == "" || strings.TrimSpace(a[1]) == "" {
+			malformed = append(malformed, line)
+			continue
+		}
+		ms = append(ms, Mention{Name: strings.TrimSpace(a[0]), ID: strings.TrimSpace(a[1])})
 	}
 	return
 }
@@ -101,10 +304,22 @@ func parsesActions(s string) (as []Action) {
 func pairs(s string) [][2]string {
 	var ps [][2]string
 	for _, line := range strings.Split(s, "\n") {
-		a := strings.SplitN(line, "|", 2)
-		if len(a) == 2 && a[0] != "" && a[1] != "" {
-			ps = append(ps, [2]string{a[0], a[1]})
+		a := strings.SplitN(trimBlank(line), "|", 2)
+		if len(a) != 2 {
+			continue
+		}
+		name, value := trimBlank(a[0]), trimBlank(a[1])
+		if name != "" && value != "" {
+			ps = append(ps, [2]string{name, value})
 		}
 	}
 	return ps
 }
+
+// trimBlank trims the white space and the invisible zero width characters, which are left eg.
+// by a command substitution with an empty output.
+func trimBlank(s string) string {
+	return strings.TrimFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '\u200b' || r == '\ufeff'
+	})
+}
@@ -0,0 +1,351 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestWithBuildButton(t *testing.T) {
+	const buildURL = "https://app.bitrise.io/build/42"
+	viewBuild := Action{Type: "OpenUri", Name: "View Build", Targets: []Target{{OS: "default", URI: buildURL}}}
+	dashboard := Action{Type: "OpenUri", Name: "Dashboard", Targets: []Target{{OS: "default", URI: "https://app.bitrise.io/dashboard"}}}
+	own := Action{Type: "OpenUri", Name: "Build logs", Targets: []Target{{OS: "default", URI: buildURL}}}
+	post := Action{Type: "HttpPOST", Name: "Retry", Target: buildURL, Body: `{"retry":true}`}
+	tests := []struct {
+		name    string
+		actions []Action
+		url     string
+		want    []Action
+	}{
+		{"added", nil, buildURL, []Action{viewBuild}},
+		{"appended after the buttons", []Action{dashboard}, buildURL, []Action{dashboard, viewBuild}},
+		{"no build URL", []Action{dashboard}, "", []Action{dashboard}},
+		{"same URL defined", []Action{own, dashboard}, buildURL, []Action{own, dashboard}},
+		{"other URL", []Action{dashboard}, buildURL + "/log", []Action{dashboard, {Type: "OpenUri", Name: "View Build", Targets: []Target{{OS: "default", URI: buildURL + "/log"}}}}},
+		{"POST to the URL is not a link", []Action{post}, buildURL, []Action{post, viewBuild}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withBuildButton(tt.actions, tt.url); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withBuildButton(%v, %q) = %v, want %v", tt.actions, tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewMessageBuildButton(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  bool
+		buildURL string
+		buttons  string
+		want     []string
+	}{
+		{"enabled", true, "https://app.bitrise.io/build/42", "Docs|https://example.com/docs", []string{"Docs", "View Build"}},
+		{"disabled", false, "https://app.bitrise.io/build/42", "Docs|https://example.com/docs", []string{"Docs"}},
+		{"env missing", true, "", "Docs|https://example.com/docs", []string{"Docs"}},
+		{"deduplicated", true, "https://app.bitrise.io/build/42", "Build|https://app.bitrise.io/build/42", []string{"Build"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BITRISE_BUILD_URL", tt.buildURL)
+			msg, errs := newMessage(Config{Subject: "Fix the login", Buttons: tt.buttons, IncludeBuildButton: tt.include})
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			var names []string
+			for _, a := range msg.Sections[0].Actions {
+				names = append(names, a.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("buttons = %q, want %q", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestAdaptiveCardBuildButton(t *testing.T) {
+	msg := Message{Sections: []Section{{Actions: withBuildButton(nil, "https://app.bitrise.io/build/42")}}}
+	card := newAdaptiveCard(msg)
+	want := []adaptiveElement{{Type: "Action.OpenUrl", Title: "View Build", URL: "https://app.bitrise.io/build/42"}}
+	if !reflect.DeepEqual(card.Actions, want) {
+		t.Errorf("actions = %+v, want %+v", card.Actions, want)
+	}
+}
+
+func TestParsesActions(t *testing.T) {
+	openURI := func(name, uri string) Action {
+		return Action{Type: "OpenUri", Name: name, Targets: []Target{{OS: "default", URI: uri}}}
+	}
+	tests := []struct {
+		name          string
+		in            string
+		want          []Action
+		wantMalformed []string
+	}{
+		{
+			name: "OpenUri",
+			in:   "Dashboard|https://app.bitrise.io/dashboard\nTop Page|https://www.bitrise.io",
+			want: []Action{openURI("Dashboard", "https://app.bitrise.io/dashboard"), openURI("Top Page", "https://www.bitrise.io")},
+		},
+		{
+			name: "mixed OpenUri and HttpPOST",
+			in: "Build|https://app.bitrise.io/build/42\n" +
+				`Promote to production|https://deploy.example.com/promote|POST|{"build":42,"env":"production"}` + "\n" +
+				`Reject|https://deploy.example.com/reject|POST|{"build":42}`,
+			want: []Action{
+				openURI("Build", "https://app.bitrise.io/build/42"),
+				{Type: "HttpPOST", Name: "Promote to production", Target: "https://deploy.example.com/promote", Body: `{"build":42,"env":"production"}`},
+				{Type: "HttpPOST", Name: "Reject", Target: "https://deploy.example.com/reject", Body: `{"build":42}`},
+			},
+		},
+		{
+			name: "body with pipes",
+			in:   `Run|https://ci.example.com/run|POST|{"cmd":"a|b"}`,
+			want: []Action{{Type: "HttpPOST", Name: "Run", Target: "https://ci.example.com/run", Body: `{"cmd":"a|b"}`}},
+		},
+		{
+			name: "malformed POST skipped",
+			in: "Docs|https://example.com/docs\n" +
+				`|https://deploy.example.com|POST|{}` + "\n" +
+				`Promote|ftp://deploy.example.com|POST|{}` + "\n" +
+				`Promote|https://deploy.example.com|POST|{"build":`,
+			want: []Action{openURI("Docs", "https://example.com/docs")},
+			wantMalformed: []string{
+				`|https://deploy.example.com|POST|{}`,
+				`Promote|ftp://deploy.example.com|POST|{}`,
+				`Promote|https://deploy.example.com|POST|{"build":`,
+			},
+		},
+		{
+			name: "GET is not a POST button",
+			in:   `Status|https://example.com/status|GET|{}`,
+			want: []Action{openURI("Status", `https://example.com/status|GET|{}`)},
+		},
+		{
+			name: "empty",
+			in:   "\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, malformed := parsesActions(tt.in)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsesActions(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+			if !reflect.DeepEqual(malformed, tt.wantMalformed) {
+				t.Errorf("parsesActions(%q) malformed = %q, want %q", tt.in, malformed, tt.wantMalformed)
+			}
+		})
+	}
+}
+
+func TestHTTPPOSTButtonPayload(t *testing.T) {
+	actions, _ := parsesActions(`Promote|https://deploy.example.com/promote|POST|{"build":42}`)
+	b, err := marshalPayload(Config{}, Message{Sections: []Section{{Actions: actions}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `"potentialAction":[{"@type":"HttpPOST","name":"Promote","target":"https://deploy.example.com/promote","body":"{\"build\":42}"}]`
+	if !bytes.Contains(b, []byte(want)) {
+		t.Errorf("payload %s, want %s", b, want)
+	}
+}
+
+func TestParsesImages(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []Image
+	}{
+		{"url", "https://example.com/a.png", []Image{{URL: "https://example.com/a.png"}}},
+		{"url and caption", "https://example.com/a.png|Coverage", []Image{{URL: "https://example.com/a.png", Title: "Coverage"}}},
+		{"url, caption and link", "https://example.com/a.png|Coverage|https://example.com/full.png", []Image{
+			{URL: "https://example.com/a.png", Title: "Coverage", Link: "https://example.com/full.png"},
+		}},
+		{"link without caption", "https://example.com/a.png||https://example.com/full.png", []Image{
+			{URL: "https://example.com/a.png", Link: "https://example.com/full.png"},
+		}},
+		{"escaped pipe in caption", `https://example.com/a.png|Before \| after|https://example.com/full.png`, []Image{
+			{URL: "https://example.com/a.png", Title: "Before | after", Link: "https://example.com/full.png"},
+		}},
+		{"escaped pipes only", `https://example.com/a.png|a\|b\|c`, []Image{{URL: "https://example.com/a.png", Title: "a|b|c"}}},
+		{"title and url", "Icon|https://example.com/icon.png", []Image{{URL: "https://example.com/icon.png", Title: "Icon"}}},
+		{"title, url and link", "Big|https://example.com/big.png|https://example.com/full.png", []Image{
+			{URL: "https://example.com/big.png", Title: "Big", Link: "https://example.com/full.png"},
+		}},
+		{"several rows", " https://example.com/a.png \n\nIcon|https://example.com/icon.png", []Image{
+			{URL: "https://example.com/a.png"},
+			{URL: "https://example.com/icon.png", Title: "Icon"},
+		}},
+		{"no URL", "Icon", nil},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsesImages(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsesImages(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckImages(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{"https://example.com/a.png|Coverage|https://example.com/full.png", false},
+		{"Icon|https://example.com/icon.png", false},
+		{"Icon", true},
+		{"Icon|ftp://example.com/icon.png", true},
+		{"https://example.com/a.png|Coverage|javascript:alert(1)", true},
+	}
+	for _, tt := range tests {
+		if errs := checkImages("images", tt.in); (len(errs) > 0) != tt.wantErr {
+			t.Errorf("checkImages(%q) = %v, want errors %v", tt.in, errs, tt.wantErr)
+		}
+	}
+}
+
+func TestImageLinks(t *testing.T) {
+	msg := Message{Sections: []Section{{Images: parsesImages("https://example.com/a.png|Coverage|https://example.com/full.png\nhttps://example.com/b.png")}}}
+	b, err := marshalPayload(Config{}, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `"images":[{"image":"https://example.com/a.png","title":"Coverage"},{"image":"https://example.com/b.png","title":""}]`; !bytes.Contains(b, []byte(want)) {
+		t.Errorf("MessageCard %s, want %s", b, want)
+	}
+
+	card := newAdaptiveCard(msg)
+	var images []adaptiveElement
+	for _, e := range card.Body {
+		if e.Type == "Image" {
+			images = append(images, e)
+		}
+	}
+	want := []adaptiveElement{
+		{Type: "Image", URL: "https://example.com/a.png", AltText: "Coverage", SelectAction: &adaptiveElement{Type: "Action.OpenUrl", Title: "Coverage", URL: "https://example.com/full.png"}},
+		{Type: "Image", URL: "https://example.com/b.png"},
+	}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("Adaptive Card images %+v, want %+v", images, want)
+	}
+}
+
+func TestParsesFactsModifiers(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []Fact
+	}{
+		{"plain", "Path|src/my_file.go", []Fact{{Name: "Path", Value: "src/my_file.go"}}},
+		{"code", "Path|src/my_file.go|code", []Fact{{Name: "Path", Value: "`src/my_file.go`", Formatted: true}}},
+		{"raw", "Path|src/my_file_*[1]*.go|raw", []Fact{{Name: "Path", Value: `src/my\_file\_\*\[1\]\*.go`, Formatted: true}}},
+		{"modifier with blanks", "Path|src/my_file.go | code ", []Fact{{Name: "Path", Value: "`src/my_file.go`", Formatted: true}}},
+		{"unknown modifier", "Ratio|1|2", []Fact{{Name: "Ratio", Value: "1|2"}}},
+		{"multi-line code", `Trace|at main()\nat run()|code`, []Fact{{Name: "Trace", Value: "```\nat main()\nat run()\n```", Formatted: true}}},
+		{"tab in code", `Cmd|make\ttest|code`, []Fact{{Name: "Cmd", Value: "`make    test`", Formatted: true}}},
+		{"control characters stripped", "Cmd|make\x07 test|code", []Fact{{Name: "Cmd", Value: "`make test`", Formatted: true}}},
+		{"backticks in code", "Cmd|run `make` now|code", []Fact{{Name: "Cmd", Value: "``run `make` now``", Formatted: true}}},
+		{"backtick at the edges", "Cmd|`make`|code", []Fact{{Name: "Cmd", Value: "`` `make` ``", Formatted: true}}},
+		{"backticks in raw", "Cmd|`make`|raw", []Fact{{Name: "Cmd", Value: "\\`make\\`", Formatted: true}}},
+		{"mixed rows", "Branch|main\nPath|a_b|code\nNote|*wip*|raw", []Fact{
+			{Name: "Branch", Value: "main"},
+			{Name: "Path", Value: "`a_b`", Formatted: true},
+			{Name: "Note", Value: `\*wip\*`, Formatted: true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsesFacts(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsesFacts(%q) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCodeValue(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"make", "`make`"},
+		{"a `b` c", "``a `b` c``"},
+		{"a ``b`` c", "```a ``b`` c```"},
+		{"`edge", "`` `edge ``"},
+		{"one\ntwo", "```\none\ntwo\n```"},
+		{"```go\nx\n```", "````\n```go\nx\n```\n````"},
+	}
+	for _, tt := range tests {
+		if got := codeValue(tt.in); got != tt.want {
+			t.Errorf("codeValue(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormattedFactsNotEscapedAgain(t *testing.T) {
+	msg := Message{Sections: []Section{{Facts: parsesFacts("Path|a_b|code\nNote|*wip*|raw\nPlain|a_b")}}}
+	sanitizeMessage(&msg, true)
+	want := []Fact{
+		{Name: "Path", Value: "`a_b`", Formatted: true},
+		{Name: "Note", Value: `\*wip\*`, Formatted: true},
+		{Name: "Plain", Value: `a\_b`},
+	}
+	if got := msg.Sections[0].Facts; !reflect.DeepEqual(got, want) {
+		t.Errorf("sanitized facts = %+v, want %+v", got, want)
+	}
+}
+
+func TestParsesMentions(t *testing.T) {
+	mentions, malformed := parsesMentions("Jane Doe|jane@company.com\n\n  On-call | 29:1a2b3c \nNo email|\n|ghost@company.com\nNo pipe")
+	wantMentions := []Mention{{Name: "Jane Doe", ID: "jane@company.com"}, {Name: "On-call", ID: "29:1a2b3c"}}
+	if !reflect.DeepEqual(mentions, wantMentions) {
+		t.Errorf("mentions = %+v, want %+v", mentions, wantMentions)
+	}
+	wantMalformed := []string{"No email|", "|ghost@company.com", "No pipe"}
+	if !reflect.DeepEqual(malformed, wantMalformed) {
+		t.Errorf("malformed = %q, want %q", malformed, wantMalformed)
+	}
+}
+
+func TestNewMessageMalformedMentions(t *testing.T) {
+	buf := captureLog(t)
+	msg, errs := newMessage(Config{Title: "Build Failed!", Mentions: "Jane Doe|jane@company.com\nNo email|"})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	if want := []Mention{{Name: "Jane Doe", ID: "jane@company.com"}}; !reflect.DeepEqual(msg.Mentions, want) {
+		t.Errorf("mentions = %+v, want %+v", msg.Mentions, want)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("No email|")) {
+		t.Errorf("log = %s, want a warning naming the malformed line", buf)
+	}
+}
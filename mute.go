@@ -0,0 +1,209 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseMuteUntil parses an RFC3339 timestamp or a duration counted from the RFC3339 timestamp
+// from. A duration needs a start, counted from each build it would never end.
+func parseMuteUntil(s, from string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid mute_until: %q, expected an RFC3339 timestamp or a duration like 2h", s)
+	}
+	if strings.TrimSpace(from) == "" {
+		return time.Time{}, fmt.Errorf("mute_until is a duration, set mute_from to the time it is counted from")
+	}
+	start, err := time.Parse(time.RFC3339, strings.TrimSpace(from))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid mute_from: %q, expected an RFC3339 timestamp", from)
+	}
+	return start.Add(d), nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// QuietHours is a daily time window on the given weekdays.
+type QuietHours struct {
+	Days     [7]bool
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+}
+
+// parseDays parses weekday lists and ranges like "*", "Mon-Fri" or "Sat,Sun".
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(strings.ToLower(s), ",") {
+		if part == "*" {
+			for i := range days {
+				days[i] = true
+			}
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, ok := weekdays[bounds[0]]
+		if !ok {
+			return days, fmt.Errorf("invalid weekday: %s", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, ok = weekdays[bounds[1]]; !ok {
+				return days, fmt.Errorf("invalid weekday: %s", bounds[1])
+			}
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+// parseClock parses a HH:MM time of the day, from 00:00 to 23:59.
+func parseClock(s string) (time.Duration, error) {
+	a := strings.Split(s, ":")
+	if len(a) != 2 {
+		return 0, fmt.Errorf("invalid time: %s, expected HH:MM", s)
+	}
+	h, herr := strconv.Atoi(a[0])
+	m, merr := strconv.Atoi(a[1])
+	if herr != nil || merr != nil || h < 0 || h > 23 || m < 0 || m > 59 {
+		return 0, fmt.Errorf("invalid time: %s, expected HH:MM from 00:00 to 23:59", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// parsesQuietHours parses lines of "<weekdays> <HH:MM>-<HH:MM> [time zone]",
+// eg. "Mon-Fri 22:00-06:00 Europe/Berlin". Windows ending before they start span midnight,
+// windows ending when they start last a whole day.
+func parsesQuietHours(s string) ([]QuietHours, error) {
+	var qs []QuietHours
+	for i, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("invalid mute_schedule in line %d, expected <weekdays> <HH:MM>-<HH:MM> [time zone]", i+1)
+		}
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mute_schedule in line %d: %s", i+1, err)
+		}
+		window := strings.SplitN(fields[1], "-", 2)
+		if len(window) != 2 {
+			return nil, fmt.Errorf("invalid mute_schedule in line %d, expected <HH:MM>-<HH:MM>", i+1)
+		}
+		start, err := parseClock(window[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mute_schedule in line %d: %s", i+1, err)
+		}
+		end, err := parseClock(window[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mute_schedule in line %d: %s", i+1, err)
+		}
+		loc := time.UTC
+		if len(fields) == 3 {
+			if loc, err = time.LoadLocation(fields[2]); err != nil {
+				return nil, fmt.Errorf("invalid mute_schedule in line %d: %s", i+1, err)
+			}
+		}
+		qs = append(qs, QuietHours{Days: days, Start: start, End: end, Location: loc})
+	}
+	return qs, nil
+}
+
+// mutedUntil returns the end of the quiet hours window containing t, if any.
+func (q QuietHours) mutedUntil(t time.Time) (time.Time, bool) {
+	t = t.In(q.Location)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, q.Location)
+	clock := t.Sub(midnight)
+
+	if q.Start < q.End {
+		if q.Days[t.Weekday()] && clock >= q.Start && clock < q.End {
+			return midnight.Add(q.End), true
+		}
+		return time.Time{}, false
+	}
+	// The window spans midnight, it belongs to the day it starts on.
+	if q.Days[t.Weekday()] && clock >= q.Start {
+		return midnight.AddDate(0, 0, 1).Add(q.End), true
+	}
+	yesterday := (t.Weekday() + 6) % 7
+	if q.Days[yesterday] && clock < q.End {
+		return midnight.Add(q.End), true
+	}
+	return time.Time{}, false
+}
+
+// checkMute returns until when the step is muted by mute_until or mute_schedule at now.
+func checkMute(c Config, now time.Time) (time.Time, bool, error) {
+	if c.MuteUntil != "" {
+		until, err := parseMuteUntil(c.MuteUntil, c.MuteFrom)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if now.Before(until) {
+			return until, true, nil
+		}
+	}
+
+	qs, err := parsesQuietHours(c.MuteSchedule)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	for _, q := range qs {
+		if until, ok := q.mutedUntil(now); ok {
+			return until, true, nil
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+// skipMuted returns until when the message is muted at now and whether it is not sent. Dry runs
+// and, with mute_exempt_failures, failed builds are sent while muted.
+func skipMuted(c Config, success bool, now time.Time) (time.Time, bool, error) {
+	until, muted, err := checkMute(c, now)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return until, muted && !c.DryRun && (success || !c.MuteExemptFailures), nil
+}
@@ -0,0 +1,207 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMuteUntil(t *testing.T) {
+	tests := []struct {
+		name    string
+		until   string
+		from    string
+		want    string
+		wantErr string
+	}{
+		{"timestamp", "2024-05-04T18:00:00+02:00", "", "2024-05-04T16:00:00Z", ""},
+		{"duration", " 2h ", "2024-05-04T16:00:00Z", "2024-05-04T18:00:00Z", ""},
+		{"duration without a start", "2h", "", "", "set mute_from"},
+		{"invalid start", "2h", "yesterday", "", "invalid mute_from"},
+		{"invalid", "tomorrow", "", "", "invalid mute_until"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMuteUntil(tt.until, tt.from)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseMuteUntil(%q, %q) error = %v, want %q", tt.until, tt.from, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMuteUntil(%q, %q) error = %v", tt.until, tt.from, err)
+			}
+			if s := got.UTC().Format(time.RFC3339); s != tt.want {
+				t.Errorf("parseMuteUntil(%q, %q) = %s, want %s", tt.until, tt.from, s, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"00:00", 0, false},
+		{"06:30", 6*time.Hour + 30*time.Minute, false},
+		{"23:59", 23*time.Hour + 59*time.Minute, false},
+		{"24:00", 0, true},
+		{"12:60", 0, true},
+		{"-1:00", 0, true},
+		{"1200", 0, true},
+		{"ab:cd", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseClock(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseClock(%q) = %v, %v, want %v, error %t", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    [7]bool
+		wantErr bool
+	}{
+		{"*", [7]bool{true, true, true, true, true, true, true}, false},
+		{"Mon-Fri", [7]bool{false, true, true, true, true, true, false}, false},
+		{"Sat,Sun", [7]bool{true, false, false, false, false, false, true}, false},
+		{"Fri-Mon", [7]bool{true, true, false, false, false, true, true}, false},
+		{"Someday", [7]bool{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDays(tt.s)
+		if (err != nil) != tt.wantErr || !tt.wantErr && got != tt.want {
+			t.Errorf("parseDays(%q) = %v, %v, want %v, error %t", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParsesQuietHoursErrors(t *testing.T) {
+	for _, s := range []string{
+		"Mon-Fri",
+		"Mon-Fri 22:00",
+		"Mon-Fri 22:00-24:00",
+		"Someday 22:00-06:00",
+		"Mon-Fri 22:00-06:00 Mars/Olympus",
+		"Mon-Fri 22:00-06:00 UTC extra",
+	} {
+		if _, err := parsesQuietHours(s); err == nil {
+			t.Errorf("parsesQuietHours(%q) error = nil, want an error", s)
+		}
+	}
+}
+
+func TestCheckMute(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip(err)
+	}
+	// The fake clock is Tuesday 2024-05-07 23:30 in Berlin.
+	now := time.Date(2024, 5, 7, 23, 30, 0, 0, berlin)
+	tests := []struct {
+		name      string
+		conf      Config
+		wantMuted bool
+		wantUntil time.Time
+	}{
+		{"not muted", Config{}, false, time.Time{}},
+		{"until later", Config{MuteUntil: "2024-05-08T00:00:00+02:00"}, true, time.Date(2024, 5, 8, 0, 0, 0, 0, berlin)},
+		{"until passed", Config{MuteUntil: "2024-05-07T23:00:00+02:00"}, false, time.Time{}},
+		{"duration running", Config{MuteUntil: "2h", MuteFrom: "2024-05-07T22:00:00+02:00"}, true, time.Date(2024, 5, 8, 0, 0, 0, 0, berlin)},
+		{"duration over", Config{MuteUntil: "1h", MuteFrom: "2024-05-07T22:00:00+02:00"}, false, time.Time{}},
+		{"window spanning midnight", Config{MuteSchedule: "Mon-Fri 22:00-06:00 Europe/Berlin"}, true, time.Date(2024, 5, 8, 6, 0, 0, 0, berlin)},
+		{"window of another day", Config{MuteSchedule: "Sat,Sun 22:00-06:00 Europe/Berlin"}, false, time.Time{}},
+		{"window in UTC", Config{MuteSchedule: "* 20:00-22:00"}, true, time.Date(2024, 5, 7, 22, 0, 0, 0, time.UTC)},
+		{"whole day", Config{MuteSchedule: "Tue 00:00-00:00 Europe/Berlin"}, true, time.Date(2024, 5, 8, 0, 0, 0, 0, berlin)},
+		{"second window", Config{MuteSchedule: "Sat 08:00-09:00\nTue 23:00-23:45 Europe/Berlin"}, true, time.Date(2024, 5, 7, 23, 45, 0, 0, berlin)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, muted, err := checkMute(tt.conf, now)
+			if err != nil {
+				t.Fatalf("checkMute() error = %v", err)
+			}
+			if muted != tt.wantMuted || !until.Equal(tt.wantUntil) {
+				t.Errorf("checkMute() = %s, %t, want %s, %t", until, muted, tt.wantUntil, tt.wantMuted)
+			}
+		})
+	}
+}
+
+func TestQuietHoursAfterMidnight(t *testing.T) {
+	qs, err := parsesQuietHours("Fri 22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Saturday 2024-05-11 05:00 is within the window started on Friday.
+	until, ok := qs[0].mutedUntil(time.Date(2024, 5, 11, 5, 0, 0, 0, time.UTC))
+	if want := time.Date(2024, 5, 11, 6, 0, 0, 0, time.UTC); !ok || !until.Equal(want) {
+		t.Errorf("mutedUntil() = %s, %t, want %s, true", until, ok, want)
+	}
+	// Sunday 05:00 is not, the window does not start on Saturdays.
+	if _, ok := qs[0].mutedUntil(time.Date(2024, 5, 12, 5, 0, 0, 0, time.UTC)); ok {
+		t.Errorf("mutedUntil() of Sunday = true, want false")
+	}
+}
+
+func TestSkipMuted(t *testing.T) {
+	now := time.Date(2024, 5, 7, 12, 0, 0, 0, time.UTC)
+	muted := Config{MuteUntil: "2024-05-08T00:00:00Z"}
+	exempt := muted
+	exempt.MuteExemptFailures = true
+	dryRun := muted
+	dryRun.DryRun = true
+	tests := []struct {
+		name    string
+		conf    Config
+		success bool
+		want    bool
+	}{
+		{"success", muted, true, true},
+		{"failure", muted, false, true},
+		{"exempt success", exempt, true, true},
+		{"exempt failure", exempt, false, false},
+		{"dry run", dryRun, true, false},
+		{"not muted", Config{}, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, skip, err := skipMuted(tt.conf, tt.success, now)
+			if err != nil || skip != tt.want {
+				t.Errorf("skipMuted() = %t, %v, want %t", skip, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// outputPath returns the path of an output file, in the deploy dir if it is set.
+func outputPath(name string) string {
+	dir := os.Getenv("BITRISE_DEPLOY_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, name)
+}
+
+// exporter exports the outputs of the step for the subsequent steps.
+type exporter interface {
+	Export(key, value string) error
+}
+
+// envmanExporter exports the outputs with envman, it does nothing if envman is unavailable.
+type envmanExporter struct{}
+
+func (envmanExporter) Export(key, value string) error {
+	if unavailable["envman"] {
+		return nil
+	}
+	if out, err := exec.Command("envman", "add", "--key", key, "--value", value).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to export %s: %s, output: %s", key, err, out)
+	}
+	return nil
+}
+
+// outputs is the exporter of the step outputs.
+var outputs exporter = envmanExporter{}
+
+// exportEnv exports an environment variable for the subsequent steps.
+func exportEnv(key, value string) error {
+	return outputs.Export(key, value)
+}
+
+// exportDelivery exports whether the message was sent, the last response of the webhook and the
+// identity of the message if the Graph API created it.
+func exportDelivery(sent bool, report *RunReport) {
+	if sent && report.MessageLink == "" {
+		// Webhooks do not return the identity of the posted message, so there is nothing to link to.
+		logger.Debugf("TEAMS_MESSAGE_LINK is empty: the response does not identify the message\n")
+	}
+	status := ""
+	if report.ResponseStatus != 0 {
+		status = strconv.Itoa(report.ResponseStatus)
+	}
+	for _, e := range [][2]string{
+		{"TEAMS_MESSAGE_SENT", strconv.FormatBool(sent)},
+		{"TEAMS_MESSAGE_DEGRADED", strconv.FormatBool(report.Degraded != "")},
+		{"TEAMS_RESPONSE_STATUS", status},
+		{"TEAMS_RESPONSE_BODY", truncateBytes(report.ResponseBody, maxEnvValueLength)},
+		{"TEAMS_MESSAGE_ID", report.MessageID},
+		{"TEAMS_MESSAGE_LINK", report.MessageLink},
+	} {
+		if err := exportEnv(e[0], e[1]); err != nil {
+			logger.Warnf("%s", err)
+		}
+	}
+}
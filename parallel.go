@@ -0,0 +1,237 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errCancelled is the error of a delivery which was cancelled as another webhook failed.
+var errCancelled = errors.New("cancelled as another webhook failed")
+
+// targetResult is the outcome of the delivery to one of the webhooks.
+type targetResult struct {
+	Name     string
+	Report   RunReport
+	Duration time.Duration
+	// RoundTrip is the duration of the last attempt.
+	RoundTrip time.Duration
+	Err       error
+}
+
+// String formats the result as a line of the delivery summary.
+func (t targetResult) String() string {
+	status := "sent"
+	switch {
+	case errors.Is(t.Err, errCancelled):
+		status = "cancelled"
+	case t.Err != nil && t.Report.ResponseStatus != 0:
+		status = fmt.Sprintf("failed, status %d", t.Report.ResponseStatus)
+	case t.Err != nil:
+		status = "failed"
+	case t.Report.Degraded != "":
+		status = "sent without " + t.Report.Degraded
+	}
+	return fmt.Sprintf("- %s: %s, attempts: %d, duration: %s", t.Name, status, t.Report.Attempts, t.Duration.Round(time.Millisecond))
+}
+
+// fields returns the result as the fields of a log line.
+func (t targetResult) fields() logFields {
+	fields := logFields{
+		"target":          t.Name,
+		"attempts":        t.Report.Attempts,
+		"response_status": t.Report.ResponseStatus,
+		"duration_ms":     t.Duration.Milliseconds(),
+		"status":          "sent",
+	}
+	if t.Err != nil {
+		fields["status"] = "failed"
+		fields["error"] = redactURLError(t.Err).Error()
+	}
+	if errors.Is(t.Err, errCancelled) {
+		fields["status"] = "cancelled"
+	}
+	if t.Report.Degraded != "" {
+		fields["degraded"] = t.Report.Degraded
+	}
+	return fields
+}
+
+// deliverAll calls deliver for each of the n webhooks, with at most parallel deliveries at the
+// same time, and returns their errors in the order of the webhooks. If failFast is set, a
+// permanent error cancels the context of the deliveries, those not started yet fail with
+// errCancelled.
+func deliverAll(n, parallel int, failFast bool, deliver func(ctx context.Context, i int) error) []error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	errs := make([]error, n)
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < parallel && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if ctx.Err() != nil {
+					errs[i] = permanent(errCancelled)
+					continue
+				}
+				errs[i] = deliver(ctx, i)
+				if failFast && errs[i] != nil && !isTransient(errs[i]) {
+					cancel()
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return errs
+}
+
+// sleepContext returns a sleep function which returns early once the context is cancelled.
+func sleepContext(ctx context.Context) func(time.Duration) {
+	return func(d time.Duration) {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// deliverySummary formats the results of the deliveries, one line per webhook.
+func deliverySummary(results []targetResult) string {
+	lines := make([]string, len(results))
+	for i, r := range results {
+		lines[i] = r.String()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// resultFields returns the results of the deliveries as the fields of a log line.
+func resultFields(results []targetResult) []logFields {
+	fields := make([]logFields, len(results))
+	for i, r := range results {
+		fields[i] = r.fields()
+	}
+	return fields
+}
+
+// delivery is how a message is posted to the webhooks.
+type delivery struct {
+	URLs   []string
+	Header http.Header
+	// Key is the idempotency key sent in the configured header, if any.
+	Key   string
+	Audit auditLog
+}
+
+// deliver posts the message to the webhooks with their Sender, up to max_parallel_requests at
+// the same time. Every webhook is retried on its own and a rejected card is degraded if
+// configured. It returns the result of every webhook in their order and logs the delivery
+// summary if there are several.
+func deliver(conf Config, msg Message, d delivery) []targetResult {
+	wait := time.Duration(conf.RetryWaitSeconds) * time.Second
+	results := make([]targetResult, len(d.URLs))
+	for i, url := range d.URLs {
+		results[i].Name = redactedHost(url)
+		if len(d.URLs) > 1 {
+			results[i].Name = fmt.Sprintf("webhook %d (%s)", i+1, results[i].Name)
+		}
+	}
+	errs := deliverAll(len(d.URLs), conf.MaxParallelRequests, conf.FailFast, func(ctx context.Context, i int) error {
+		url, result := d.URLs[i], &results[i]
+		result.Report.CorrelationID = conf.CorrelationID
+		sender := newSender(url, time.Duration(conf.TimeoutSeconds)*time.Second)
+		if len(d.URLs) > 1 {
+			sender.Target = i + 1
+		}
+		sender.Header = d.Header
+		sender.SigningSecret, sender.SignatureHeader = string(conf.SigningSecret), conf.SignatureHeader
+		start := time.Now()
+		defer func() { result.Duration = time.Since(start) }()
+
+		// Every webhook is retried on its own, its report records only its attempts.
+		r := &result.Report
+		post := func(msg Message, key string) error {
+			return withRetry(result.Name, conf.RetryCount, wait, sleepContext(ctx), func() error {
+				if ctx.Err() != nil {
+					return permanent(errCancelled)
+				}
+				r.Attempts++
+				attempt := time.Now()
+				err := sender.postMessage(ctx, conf, msg, key, r)
+				result.RoundTrip = time.Since(attempt)
+				if err != nil && ctx.Err() != nil {
+					err = permanent(errCancelled)
+				}
+				d.Audit.record(time.Now(), redactedHost(url), r, err)
+				logger.Record("info", "Attempt", attemptFields(redactedHost(url), r, err), nil)
+				return err
+			})
+		}
+		err := post(msg, d.Key)
+		if err != nil && conf.DegradeOnRejection && r.ResponseStatus == http.StatusBadRequest {
+			for n, v := range reducedVariants(msg, maxReducedVariants) {
+				logger.Warnf("%s: the card was rejected (%s), retrying without %s.", result.Name, err, v.Dropped)
+				if err = post(v.Msg, variantKey(d.Key, n+1)); err == nil {
+					logger.Warnf("%s: the card was sent without %s.", result.Name, v.Dropped)
+					r.Degraded = v.Dropped
+					break
+				}
+				if r.ResponseStatus != http.StatusBadRequest {
+					break
+				}
+			}
+		}
+		return err
+	})
+	for i, err := range errs {
+		results[i].Err = err
+	}
+
+	if len(d.URLs) > 1 {
+		logger.Record("info", "Delivery summary", logFields{"targets": resultFields(results)}, func() {
+			logger.Printf("Delivery summary:\n%s", deliverySummary(results))
+		})
+	}
+	return results
+}
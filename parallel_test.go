@@ -0,0 +1,231 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeliverAll(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int
+		parallel int
+	}{
+		{"serial", 5, 1},
+		{"parallel", 8, 3},
+		{"more workers than webhooks", 2, 5},
+		{"parallel below one is serial", 3, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var running, peak int32
+			var mu sync.Mutex
+			seen := map[int]int{}
+			errs := deliverAll(tt.n, tt.parallel, false, func(ctx context.Context, i int) error {
+				r := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if r <= p || atomic.CompareAndSwapInt32(&peak, p, r) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				seen[i]++
+				mu.Unlock()
+				if i%2 == 1 {
+					return fmt.Errorf("webhook %d failed", i)
+				}
+				return nil
+			})
+
+			if len(errs) != tt.n {
+				t.Fatalf("len(errs) = %d, want %d", len(errs), tt.n)
+			}
+			for i, err := range errs {
+				if seen[i] != 1 {
+					t.Errorf("webhook %d delivered %d times, want once", i, seen[i])
+				}
+				if want := i%2 == 1; (err != nil) != want {
+					t.Errorf("errs[%d] = %v, want error %v", i, err, want)
+				}
+			}
+			max := tt.parallel
+			if max < 1 {
+				max = 1
+			}
+			if int(peak) > max {
+				t.Errorf("%d deliveries at the same time, want at most %d", peak, max)
+			}
+		})
+	}
+}
+
+func TestDeliverAllFailFast(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           error
+		wantCancelled bool
+	}{
+		{"permanent error cancels", permanent(errors.New("bad request")), true},
+		{"transient error does not cancel", transient(errors.New("throttled")), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := deliverAll(4, 1, true, func(ctx context.Context, i int) error {
+				if i == 0 {
+					return tt.err
+				}
+				return nil
+			})
+			for i, err := range errs[1:] {
+				if got := errors.Is(err, errCancelled); got != tt.wantCancelled {
+					t.Errorf("errs[%d] = %v, want cancelled %v", i+1, err, tt.wantCancelled)
+				}
+			}
+		})
+	}
+}
+
+func TestDeliverAllFailFastCancelsRunning(t *testing.T) {
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	errs := deliverAll(2, 2, true, func(ctx context.Context, i int) error {
+		if i == 0 {
+			<-started
+			return permanent(errors.New("bad request"))
+		}
+		close(started)
+		select {
+		case <-ctx.Done():
+			close(cancelled)
+			return permanent(ctx.Err())
+		case <-time.After(5 * time.Second):
+			return nil
+		}
+	})
+	select {
+	case <-cancelled:
+	default:
+		t.Errorf("the running delivery was not cancelled, errs = %v", errs)
+	}
+}
+
+func TestSleepContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	sleepContext(ctx)(time.Minute)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("sleep of a cancelled context took %s", d)
+	}
+}
+
+func TestDeliverParallel(t *testing.T) {
+	captureLog(t)
+	var urls []string
+	for i := 0; i < 4; i++ {
+		status := http.StatusOK
+		if i == 2 {
+			status = http.StatusBadRequest
+		}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(5 * time.Millisecond)
+			w.WriteHeader(status)
+			w.Write([]byte("1"))
+		}))
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+	}
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	conf := Config{MaxParallelRequests: 3, TimeoutSeconds: 5}
+	results := deliver(conf, Message{Title: "t"}, delivery{URLs: urls, Audit: auditLog{Path: path}})
+	for i, r := range results {
+		if wantErr := i == 2; (r.Err != nil) != wantErr {
+			t.Errorf("results[%d].Err = %v, want error %v", i, r.Err, wantErr)
+		}
+		if r.Report.Attempts != 1 {
+			t.Errorf("results[%d] made %d attempts, want 1", i, r.Report.Attempts)
+		}
+	}
+	if got := results[2].Report.ResponseStatus; got != http.StatusBadRequest {
+		t.Errorf("results[2].Report.ResponseStatus = %d, want 400", got)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(b, []byte("\n")); n != len(urls) {
+		t.Errorf("the audit log has %d records, want %d", n, len(urls))
+	}
+}
+
+func TestDeliverDegradesWithItsOwnKeys(t *testing.T) {
+	captureLog(t)
+	var mu sync.Mutex
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		mu.Unlock()
+		if bytes.Contains(b, []byte("potentialAction")) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("1"))
+	}))
+	defer srv.Close()
+
+	conf := Config{DegradeOnRejection: true, IdempotencyKeyHeader: "Idempotency-Key", TimeoutSeconds: 5}
+	msg := Message{Title: "t", Sections: []Section{{ActivityTitle: "a", Actions: []Action{{Type: "OpenUri", Name: "Build"}}}}}
+	results := deliver(conf, msg, delivery{URLs: []string{srv.URL}, Key: "key"})
+
+	if err := results[0].Err; err != nil {
+		t.Fatalf("deliver() error = %v", err)
+	}
+	if want := []string{"key", "key-reduced-1"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("idempotency keys = %v, want %v", keys, want)
+	}
+	if got, want := results[0].Report.Degraded, "1 buttons"; got != want {
+		t.Errorf("Degraded = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// linkPattern matches a complete markdown link at the start of a string.
+var linkPattern = regexp.MustCompile(`^\[[^\]]*\]\([^)]*\)`)
+
+// truncateMarkdown shortens s to at most n bytes without splitting a multi-byte character or
+// a markdown link, a link which doesn't fit is dropped. A code fence is never left open, the cut
+// goes through truncateText then, which closes it.
+func truncateMarkdown(s string, n int) string {
+	cut := truncateBytes(s, n)
+	if cut == s {
+		return s
+	}
+	if i := strings.LastIndex(cut, "["); i >= 0 && !linkPattern.MatchString(cut[i:]) {
+		cut = cut[:i]
+	}
+	if openFence(cut) < 0 {
+		return cut
+	}
+	// truncateText counts characters, the ellipsis and the closing fence take more bytes.
+	for m := utf8.RuneCountInString(cut); m > 0; m-- {
+		if t := truncateText(s, m); len(t) <= n {
+			return t
+		}
+	}
+	return ""
+}
+
+// textField is a text of the message which may be truncated.
+type textField struct {
+	Name  string
+	Value *string
+}
+
+// textFields returns the texts of the message which may be truncated: the texts of the
+// sections and the values of their facts.
+func textFields(msg *Message) []textField {
+	var fs []textField
+	for i := range msg.Sections {
+		s := &msg.Sections[i]
+		fs = append(fs,
+			textField{fmt.Sprintf("text of section %d", i+1), &s.ActivityText},
+			textField{fmt.Sprintf("text of section %d", i+1), &s.Text},
+		)
+		for j := range s.Facts {
+			fs = append(fs, textField{fmt.Sprintf("value of the %q fact", s.Facts[j].Name), &s.Facts[j].Value})
+		}
+	}
+	return fs
+}
+
+// fitPayload truncates the longest texts of the message until its payload is at most limit
+// bytes, logging what was cut. It fails if the payload doesn't fit even without the texts.
+func fitPayload(conf Config, msg *Message, limit int) error {
+	// The suffix is appended to the texts shortened to fit the payload limit.
+	truncatedSuffix := label(locale.NoteTruncated)
+	bare := *msg
+	bare.Sections = make([]Section, len(msg.Sections))
+	for i, s := range msg.Sections {
+		s.Facts = append([]Fact(nil), s.Facts...)
+		bare.Sections[i] = s
+	}
+	for _, f := range textFields(&bare) {
+		*f.Value = ""
+	}
+	if b, err := marshalPayload(conf, bare); err != nil {
+		return err
+	} else if len(b) > limit {
+		return fmt.Errorf("the payload is %d bytes without its texts, the limit is %d bytes", len(b), limit)
+	}
+
+	for {
+		b, err := marshalPayload(conf, *msg)
+		if err != nil {
+			return err
+		}
+		if len(b) <= limit {
+			return nil
+		}
+
+		var longest *textField
+		for _, f := range textFields(msg) {
+			f := f
+			if strings.TrimSuffix(*f.Value, truncatedSuffix) != "" && (longest == nil || len(*f.Value) > len(*longest.Value)) {
+				longest = &f
+			}
+		}
+		if longest == nil {
+			return nil
+		}
+
+		text := strings.TrimSuffix(strings.TrimSuffix(*longest.Value, truncatedSuffix), "\n")
+		n := len(*longest.Value) - (len(b) - limit) - len(truncatedSuffix)
+		if n < 0 {
+			n = 0
+		}
+		cut := truncateMarkdown(text, n)
+		logger.Warnf("The payload is %d bytes, over the limit of %d bytes: the %s is cut from %d to %d bytes.", len(b), limit, longest.Name, len(text), len(cut))
+		if strings.HasSuffix(cut, "```") {
+			// A closing fence followed by text on its line would not close the block.
+			cut += "\n"
+		}
+		*longest.Value = cut + truncatedSuffix
+		if cut == "" {
+			*longest.Value = ""
+		}
+	}
+}
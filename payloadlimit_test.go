@@ -0,0 +1,98 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+func TestTruncateMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		n    int
+		want string
+	}{
+		{"short enough", "abc", 3, "abc"},
+		{"bytes", "abcdef", 4, "abcd"},
+		{"multi-byte character kept whole", "aä", 2, "a"},
+		{"link dropped", "see [docs](https://example.com)", 12, "see "},
+		{"fence closed", "log:\n```\nline 1\nline 2\nline 3\n```", 26, "log:\n```\nline 1\n…\n```"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateMarkdown(tt.in, tt.n)
+			if got != tt.want {
+				t.Errorf("truncateMarkdown(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+			}
+			if len(got) > tt.n {
+				t.Errorf("truncateMarkdown(%q, %d) is %d bytes", tt.in, tt.n, len(got))
+			}
+		})
+	}
+}
+
+func TestFitPayloadClosesFences(t *testing.T) {
+	trace := "```\n" + strings.Repeat("at com.example.Frame.method(Frame.java:42)\n", 200) + "```"
+	msg := Message{
+		Type:     "MessageCard",
+		Title:    "Build Failed!",
+		Sections: []Section{{ActivityText: "Tests failed"}, {Title: "Stack trace", Text: trace}},
+	}
+	conf := Config{CardFormat: "messagecard"}
+	const limit = 2000
+	if err := fitPayload(conf, &msg, limit); err != nil {
+		t.Fatalf("fitPayload() error = %v", err)
+	}
+	b, err := marshalPayload(conf, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) > limit {
+		t.Errorf("payload is %d bytes, over the limit of %d", len(b), limit)
+	}
+	text := msg.Sections[1].Text
+	if len(text) >= len(trace) {
+		t.Fatalf("stack trace was not truncated")
+	}
+	if openFence(text) >= 0 {
+		t.Errorf("stack trace is sent with its fence open: %q", text[len(text)-60:])
+	}
+	if !strings.HasSuffix(text, "```\n"+label(locale.NoteTruncated)) {
+		t.Errorf("truncation note not after the closing fence: %q", text[len(text)-60:])
+	}
+}
+
+func TestFitPayloadFailsWithoutTexts(t *testing.T) {
+	msg := Message{Type: "MessageCard", Title: strings.Repeat("x", 500)}
+	if err := fitPayload(Config{}, &msg, 100); err == nil {
+		t.Error("fitPayload() expected an error when the payload doesn't fit without its texts")
+	}
+}
@@ -0,0 +1,390 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// errStop ends the pipeline before the message is sent, the stage returning it has recorded the
+// status of the run.
+var errStop = errors.New("the message is not sent")
+
+// sendPipeline builds the message of the build and sends it.
+type sendPipeline struct {
+	conf   Config
+	report *RunReport
+	urls   []string
+	header http.Header
+
+	msg Message
+	// ackToken is the token of the Acknowledge button, if any.
+	ackToken string
+	// digestRecord collects the message in the digest file, digestRead is the size of the
+	// digest sent with it.
+	digestRecord []byte
+	digestRead   int
+}
+
+// stages returns the stages building the message, in the order they run.
+func (p *sendPipeline) stages() []func(*sendPipeline) error {
+	return []func(*sendPipeline) error{
+		(*sendPipeline).checkInputs,
+		(*sendPipeline).newMessage,
+		(*sendPipeline).addAckButton,
+		(*sendPipeline).dropPostActions,
+		(*sendPipeline).addLogSections,
+		(*sendPipeline).checkImages,
+		(*sendPipeline).qualityGate,
+		(*sendPipeline).skipStaleBuild,
+		(*sendPipeline).emojiCompatibility,
+		(*sendPipeline).addBanner,
+		(*sendPipeline).addHeroImage,
+		(*sendPipeline).addDigest,
+		// The content policy and the sanitizing run after every stage adding content, so
+		// they cover the whole message.
+		(*sendPipeline).contentPolicy,
+		(*sendPipeline).sanitize,
+		(*sendPipeline).fitPayload,
+	}
+}
+
+// run runs the stages and sends the message, it returns the exit code of the step.
+func (p *sendPipeline) run() int {
+	for _, stage := range p.stages() {
+		if err := stage(p); err == errStop {
+			return 0
+		} else if err != nil {
+			logger.Errorf("Error: %s", err)
+			return 1
+		}
+	}
+
+	// The summaries are exported whatever the outcome is, the dry run and the collected builds
+	// show what would have been sent.
+	p.export()
+	if p.conf.DryRun {
+		return dryRun(p.conf, p.msg, p.report)
+	}
+	if p.conf.DigestFile != "" && p.conf.DigestMode == "collect" {
+		if err := collectDigest(p.conf.DigestFile, p.digestRecord); err != nil {
+			logger.Errorf("Error: failed to collect the message in the digest file: %s", err)
+			return 1
+		}
+		logger.Printf("The message is collected in the digest file, it is sent by the step in digest_mode send.")
+		p.report.Status = "collected"
+		return 0
+	}
+	return p.deliver()
+}
+
+func (p *sendPipeline) checkInputs() error {
+	backfillGitInputs(&p.conf, os.Getenv)
+	if p.conf.InputFormat != "json" {
+		return nil
+	}
+	_, _, _, err := jsonInputs(selectInputs(p.conf))
+	if err == nil {
+		_, err = jsonSections(p.conf.Sections)
+	}
+	return err
+}
+
+func (p *sendPipeline) newMessage() error {
+	var issues []error
+	p.msg, issues = newMessage(p.conf)
+	for _, issue := range issues {
+		if p.conf.DryRun {
+			logger.Warnf("%s", issue)
+		} else {
+			logger.Debugf("%s\n", issue)
+		}
+	}
+	if len(p.msg.Mentions) > 0 && p.conf.CardFormat != "adaptivecard" {
+		logger.Warnf("Mentions are supported by Adaptive Cards only, set card_format to adaptivecard to notify the mentioned users.")
+	}
+	return nil
+}
+
+func (p *sendPipeline) addAckButton() error {
+	switch {
+	case success || p.conf.AckURL == "":
+		return nil
+	case p.conf.CardFormat == "adaptivecard":
+		logger.Warnf("The Acknowledge button of ack_url posts a request, which Adaptive Cards can't do in Teams, it is omitted. Set card_format to messagecard and delivery_method to webhook to show it.")
+		return nil
+	}
+	var err error
+	p.ackToken, err = addAckAction(&p.msg, p.conf.AckURL)
+	return err
+}
+
+func (p *sendPipeline) dropPostActions() error {
+	if p.conf.CardFormat == "adaptivecard" {
+		for _, name := range dropHTTPPOSTActions(&p.msg) {
+			logger.Warnf("Button %q posts a request, which Adaptive Cards can't do in Teams, it is omitted. Set card_format to messagecard to keep it.", name)
+		}
+	}
+	return nil
+}
+
+// addLogSections adds the release notes, the changelog and the stack trace.
+func (p *sendPipeline) addLogSections() error {
+	if p.conf.ReleaseNotesPath != "" {
+		section, err := releaseNotesSection(p.conf.ReleaseNotesPath, p.conf.ReleaseNotesRequired)
+		if err != nil {
+			return err
+		}
+		if section != nil {
+			p.msg.Sections = append(p.msg.Sections, *section)
+		}
+	}
+	if p.conf.ShowChangelog {
+		timeout := time.Duration(p.conf.SubshellTimeoutSeconds) * time.Second
+		if section := changelogSection(os.Getenv("BITRISE_SOURCE_DIR"), p.conf.ChangelogCommitCount, p.conf.ChangelogFormat == "full", timeout, execCommand); section != nil {
+			p.msg.Sections = append(p.msg.Sections, *section)
+		}
+	}
+	if p.conf.StackTrace != "" {
+		p.msg.Sections = append(p.msg.Sections, stackTraceSection(p.conf.StackTrace, p.conf.StackTraceFrames))
+	}
+	return nil
+}
+
+func (p *sendPipeline) checkImages() error {
+	if p.conf.VerifyImageURLs {
+		verifyMessageImages(&http.Client{Transport: transport}, &p.msg, imageVerifyTimeout, p.conf.DropUnverifiedImages)
+	}
+	return nil
+}
+
+func (p *sendPipeline) qualityGate() error {
+	if err := applyQualityGate(p.conf, &p.msg); err != nil {
+		return err
+	}
+	// The verdict of the quality gate is a fact too, so the facts are split once it is added.
+	p.msg.Sections = splitFacts(p.msg.Sections, p.conf.MaxFactsPerSection)
+	return nil
+}
+
+func (p *sendPipeline) skipStaleBuild() error {
+	if !checkStaleBuild(p.conf, &p.msg, os.Getenv("BITRISE_BUILD_TRIGGER_TIMESTAMP"), time.Now()) {
+		return nil
+	}
+	if err := exportEnv("TEAMS_MESSAGE_STATUS", "skipped"); err != nil {
+		logger.Warnf("%s", err)
+	}
+	p.report.Status = "skipped, stale build"
+	return errStop
+}
+
+func (p *sendPipeline) emojiCompatibility() error {
+	applyEmojiCompatibilityToMessage(&p.msg, p.conf.EmojiCompatibility)
+	return nil
+}
+
+func (p *sendPipeline) contentPolicy() error {
+	if p.conf.ContentPolicy == "restricted" {
+		restrictContent(&p.msg, parsesDenylist(p.conf.ContentPolicyDenylist))
+	}
+	return nil
+}
+
+func (p *sendPipeline) addBanner() error {
+	if p.conf.BannerSource != "" {
+		if banner := bannerSection(p.conf.BannerSource); banner != nil {
+			p.msg.Sections = append([]Section{*banner}, p.msg.Sections...)
+		}
+	}
+	return nil
+}
+
+func (p *sendPipeline) addHeroImage() error {
+	if hero := heroImage(&http.Client{Transport: transport}, selectInputs(p.conf).HeroImageURL); hero != nil {
+		p.msg.Sections = append([]Section{{HeroImage: hero}}, p.msg.Sections...)
+	}
+	return nil
+}
+
+func (p *sendPipeline) addDigest() error {
+	if p.conf.DigestFile == "" {
+		return nil
+	}
+	if p.conf.DigestMode == "collect" {
+		var err error
+		p.digestRecord, err = digestRecord(p.msg, success, currentDigestBuild(os.Getenv, time.Now()))
+		return err
+	}
+	if p.conf.DigestMode != "send" {
+		return nil
+	}
+	entries, n, err := readDigest(p.conf.DigestFile)
+	if err != nil {
+		return fmt.Errorf("failed to read the digest file: %s", err)
+	}
+	if len(entries) == 0 {
+		logger.Warnf("No notification is collected in the digest file, only this build is reported.")
+	}
+	if summary := digestSummary(entries); summary != nil {
+		p.msg.Sections = append(p.msg.Sections, *summary)
+	}
+	appendDigest(&p.msg, entries)
+	p.digestRead = n
+	return nil
+}
+
+func (p *sendPipeline) sanitize() error {
+	// The sections added by the stages are sanitized too, so this runs once on the final message.
+	sanitizeMessage(&p.msg, p.conf.EscapeMarkdown)
+	if p.conf.ShortenURLs {
+		shortenMessageURLs(&p.msg, p.conf.ShortenURLsLength, p.conf.EscapeMarkdown)
+	}
+	return nil
+}
+
+func (p *sendPipeline) fitPayload() error {
+	p.report.CardFormat = "MessageCard"
+	if p.conf.CardFormat == "adaptivecard" {
+		p.report.CardFormat = "AdaptiveCard"
+	}
+	if p.conf.MaxPayloadKB > 0 {
+		if err := fitPayload(p.conf, &p.msg, p.conf.MaxPayloadKB*1024); err != nil {
+			return err
+		}
+	}
+	p.report.countContent(p.msg)
+	return nil
+}
+
+// export exports the summaries of the final message.
+func (p *sendPipeline) export() {
+	if err := exportMarkdown(p.msg); err != nil {
+		logger.Warnf("Failed to export the markdown summary: %s", err)
+	}
+	if err := exportContentHash(p.msg, p.conf.ContentHashExclude, p.volatileValues()); err != nil {
+		logger.Warnf("Failed to export the content hash: %s", err)
+	}
+}
+
+// volatileValues returns the values of the message which change with every build.
+func (p *sendPipeline) volatileValues() []string {
+	return []string{strings.TrimSpace(os.Getenv("BITRISE_BUILD_URL")), p.ackToken}
+}
+
+// deliver sends the message to every webhook and returns the exit code of the step.
+func (p *sendPipeline) deliver() int {
+	conf, msg, report := p.conf, p.msg, p.report
+	if pr := os.Getenv("BITRISE_PULL_REQUEST"); conf.PRComment && pr != "" {
+		provider, err := newCommentProvider(os.Getenv("GIT_REPOSITORY_URL"), conf.PRCommentAPIURL, string(conf.PRCommentToken), time.Duration(conf.TimeoutSeconds)*time.Second)
+		if err == nil {
+			err = postPRComment(provider, pr, renderMarkdown(msg))
+		}
+		if err != nil {
+			logger.Warnf("Failed to comment on the pull request: %s", err)
+		}
+	}
+
+	key, err := idempotencyKey(conf)
+	if err != nil {
+		logger.Errorf("Error: %s", err)
+		return 1
+	}
+
+	// The key is read before sending, the payload is signed once it is known which one was sent.
+	var signingKey interface{}
+	if conf.PayloadSigningKeyPath != "" {
+		if signingKey, err = readPEMKey(conf.PayloadSigningKeyPath); err != nil {
+			logger.Errorf("Error: %s", err)
+			return 1
+		}
+	}
+
+	audit := auditLog{
+		Path:        conf.AuditLogPath,
+		Detail:      conf.AuditDetail,
+		TriggeredBy: os.Getenv("BITRISE_TRIGGERED_BY"),
+		BuildSlug:   os.Getenv("BITRISE_BUILD_SLUG"),
+	}
+	if audit.TriggeredBy == "" {
+		audit.TriggeredBy = conf.AuthorName
+	}
+
+	results := deliver(conf, msg, delivery{URLs: p.urls, Header: p.header, Key: key, Audit: audit})
+	sent := 0
+	for _, result := range results {
+		report.merge(result.Report)
+		err := result.Err
+		if err == nil {
+			sent++
+			continue
+		}
+		switch {
+		case errors.Is(err, errCancelled):
+			logger.Warnf("%s: %s", result.Name, err)
+		case conf.FailOnError:
+			logger.Errorf("Error: %s: %s", result.Name, err)
+		default:
+			logger.Warnf("%s: %s", result.Name, err)
+		}
+		logger.Debugf("Last response: status %d, body: %s\n", result.Report.ResponseStatus, orDash(result.Report.ResponseBody))
+	}
+
+	exportDelivery(sent > 0, report)
+	if sent > 0 && p.ackToken != "" {
+		// The token is exported only for a card which was sent, it can't be acknowledged otherwise.
+		if err := exportEnv("TEAMS_ACK_TOKEN", p.ackToken); err != nil {
+			logger.Warnf("%s", err)
+		}
+	}
+	if signingKey != nil {
+		if err := archiveSignedPayload(signingKey, sentPayload(results)); err != nil {
+			logger.Errorf("Error: %s", err)
+			return 1
+		}
+	}
+	code, status := deliveryResult(sent, len(p.urls), conf.FailOnError, conf.FailOnPartialError)
+	report.Status = status
+	if status == "sent" {
+		if err := clearDigest(conf.DigestFile, p.digestRead); err != nil {
+			logger.Warnf("%s", err)
+		}
+	}
+	switch {
+	case status == "sent":
+		logger.Donef("\nMessage successfully sent! 🚀\n")
+	case code == 0 && sent > 0:
+		logger.Warnf("The message could not be sent to every webhook.")
+	case code == 0:
+		logger.Warnf("The message could not be sent, the step does not fail as fail_on_error is disabled.")
+	}
+	return code
+}
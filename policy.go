@@ -0,0 +1,137 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+var (
+	emailPattern    = regexp.MustCompile(`<?[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}>?`)
+	artifactPattern = regexp.MustCompile(`(?i)(/artifacts?/|build-storage|\.(ipa|apk|aab|zip|dsym)(\?|$))`)
+)
+
+// restrictedFact reports whether the fact name matches one of the denylist patterns.
+func restrictedFact(name string, denylist []string) bool {
+	for _, p := range denylist {
+		if ok, _ := path.Match(strings.ToLower(p), strings.ToLower(strings.TrimSpace(name))); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parsesDenylist parses the newline separated fact name patterns.
+func parsesDenylist(s string) (ps []string) {
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			ps = append(ps, line)
+		}
+	}
+	return
+}
+
+// removeEmails removes the email addresses from s and reports whether it found any.
+func removeEmails(s string) (string, bool) {
+	r := strings.TrimSpace(emailPattern.ReplaceAllString(s, ""))
+	return r, r != strings.TrimSpace(s)
+}
+
+// restrictContent strips the content which must not leave the organization: log excerpts,
+// artifact links, email addresses and the facts matching the denylist. It runs on the final
+// message and notes in the message that details were withheld.
+func restrictContent(msg *Message, denylist []string) {
+	withheld := false
+	strip := func(s string) string {
+		r, found := removeEmails(s)
+		if found {
+			withheld = true
+			return r
+		}
+		return s
+	}
+
+	var sections []Section
+	for _, s := range msg.Sections {
+		if s.Excerpt {
+			withheld = true
+			continue
+		}
+		s.ActivityTitle = strip(s.ActivityTitle)
+		s.ActivityText = strip(s.ActivityText)
+		s.Text = strip(s.Text)
+
+		var fs []Fact
+		for _, f := range s.Facts {
+			if restrictedFact(f.Name, denylist) {
+				withheld = true
+				continue
+			}
+			f.Value = strip(f.Value)
+			fs = append(fs, f)
+		}
+		s.Facts = fs
+
+		var as []Action
+		for _, a := range s.Actions {
+			artifact := false
+			for _, t := range a.Targets {
+				if artifactPattern.MatchString(t.URI) {
+					artifact = true
+				}
+			}
+			if artifact {
+				withheld = true
+				continue
+			}
+			as = append(as, a)
+		}
+		s.Actions = as
+		sections = append(sections, s)
+	}
+	msg.Sections = sections
+
+	if withheld && len(msg.Sections) > 0 {
+		// The note tells the readers that the content was restricted.
+		s := &msg.Sections[mainSection(msg.Sections)]
+		s.ActivityText = strings.TrimSpace(s.ActivityText + "\n\n" + label(locale.NoteWithheld))
+	}
+}
+
+// mainSection returns the index of the section of the build, the first one which is neither a
+// hero image nor a section generated by the step, eg. the banner.
+func mainSection(sections []Section) int {
+	for i, s := range sections {
+		if s.HeroImage == nil && !s.Formatted {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,142 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRestrictContent(t *testing.T) {
+	withheld := "_Some details are withheld in this channel._"
+	msg := Message{Title: "Build Failed!", Sections: []Section{
+		{HeroImage: &Image{URL: "https://example.com/hero.png"}},
+		{Title: "Announcement", Text: "Ask ops@example.com", Formatted: true},
+		{
+			ActivityTitle: "Jane <jane@example.com>",
+			ActivityText:  "Fix the login, reviewed by joe@example.com",
+			Facts: []Fact{
+				{Name: "Branch", Value: "main"},
+				{Name: "Committer", Value: "jane@example.com"},
+				{Name: "API Token", Value: "secret"},
+				{Name: "build number", Value: "42"},
+			},
+			Actions: []Action{
+				{Name: "Open", Targets: []Target{{OS: "default", URI: "https://app.bitrise.io/build/1"}}},
+				{Name: "Download", Targets: []Target{{OS: "default", URI: "https://example.com/app.ipa"}}},
+				{Name: "Artifacts", Targets: []Target{{OS: "default", URI: "https://app.bitrise.io/artifacts/1"}}},
+			},
+		},
+		{Title: "Release notes", Text: "- Fixed the login", Formatted: true, Excerpt: true},
+		{Title: "Changelog", Text: "- abc123 Fix the login", Formatted: true, Excerpt: true},
+		{Title: "Stack trace", Text: "```\nat Login.fix\n```", Formatted: true, Excerpt: true},
+		{Title: "Digest", Text: "Built by joe@example.com", Facts: []Fact{{Name: "Token", Value: "x"}}},
+	}}
+	restrictContent(&msg, []string{"*token*", "Build Number"})
+
+	want := Message{Title: "Build Failed!", Sections: []Section{
+		{HeroImage: &Image{URL: "https://example.com/hero.png"}},
+		{Title: "Announcement", Text: "Ask", Formatted: true},
+		{
+			ActivityTitle: "Jane",
+			ActivityText:  "Fix the login, reviewed by\n\n" + withheld,
+			Facts: []Fact{
+				{Name: "Branch", Value: "main"},
+				{Name: "Committer", Value: ""},
+			},
+			Actions: []Action{
+				{Name: "Open", Targets: []Target{{OS: "default", URI: "https://app.bitrise.io/build/1"}}},
+			},
+		},
+		{Title: "Digest", Text: "Built by"},
+	}}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("restrictContent() =\n%+v\nwant\n%+v", msg, want)
+	}
+}
+
+func TestRestrictContentNothingWithheld(t *testing.T) {
+	msg := Message{Sections: []Section{{ActivityText: "Fix the login", Facts: []Fact{{Name: "Branch", Value: "main"}}}}}
+	restrictContent(&msg, []string{"*token*"})
+
+	if got := msg.Sections[0].ActivityText; got != "Fix the login" {
+		t.Errorf("ActivityText = %q, want no note", got)
+	}
+}
+
+func TestRestrictedFact(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"API Token", true},
+		{" token ", true},
+		{"Branch", false},
+		{"Build number", true},
+	}
+	for _, tt := range tests {
+		if got := restrictedFact(tt.name, []string{"*TOKEN*", "build number"}); got != tt.want {
+			t.Errorf("restrictedFact(%q) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestContentPolicyCoversAddedSections(t *testing.T) {
+	captureOutputs(t)
+	dir := t.TempDir()
+	t.Setenv("BITRISE_DEPLOY_DIR", dir)
+	banner := filepath.Join(dir, "banner.md")
+	if err := ioutil.WriteFile(banner, []byte("Ask ops@example.com"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p := &sendPipeline{conf: Config{
+		DryRun:        true,
+		Subject:       "Fix the login",
+		BannerSource:  banner,
+		StackTrace:    "at Login.fix",
+		ContentPolicy: "restricted",
+	}, report: &RunReport{}}
+
+	if code := p.run(); code != 0 {
+		t.Fatalf("run() = %d, want 0", code)
+	}
+	b, err := marshalPayload(p.conf, p.msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"ops@example.com", "Login.fix"} {
+		if strings.Contains(string(b), s) {
+			t.Errorf("the payload contains %q: %s", s, b)
+		}
+	}
+	if !strings.Contains(string(b), "withheld") {
+		t.Errorf("the payload does not note that details were withheld: %s", b)
+	}
+}
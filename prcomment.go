@@ -0,0 +1,214 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// commentMarker identifies the pull request comment created by the step.
+const commentMarker = "<!-- bitrise-step-send-microsoft-teams-message -->"
+
+// repoURLPattern matches https and ssh git remote URLs and captures the host and the path.
+var repoURLPattern = regexp.MustCompile(`^(?:https?://(?:[^@/]+@)?|ssh://(?:[^@/]+@)?|[^@/]+@)([^/:]+)(?::\d+)?[:/](.+?)(?:\.git)?/?$`)
+
+// prComment is a comment of a pull request.
+type prComment struct {
+	ID   string
+	Body string
+}
+
+// commentProvider posts comments to the pull requests of a git hosting service.
+type commentProvider interface {
+	comments(pr string) ([]prComment, error)
+	create(pr, body string) error
+	update(pr, id, body string) error
+}
+
+// apiClient sends authenticated JSON requests to an API.
+type apiClient struct {
+	client  *http.Client
+	baseURL string
+	header  http.Header
+}
+
+func (c apiClient) do(method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, vs := range c.header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s %s: %s, response: %s", method, path, resp.Status, b)
+	}
+	if out != nil {
+		return json.Unmarshal(b, out)
+	}
+	return nil
+}
+
+type githubProvider struct {
+	api  apiClient
+	repo string
+}
+
+type githubComment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+func (p githubProvider) comments(pr string) ([]prComment, error) {
+	var cs []githubComment
+	if err := p.api.do("GET", fmt.Sprintf("/repos/%s/issues/%s/comments?per_page=100", p.repo, pr), nil, &cs); err != nil {
+		return nil, err
+	}
+	var r []prComment
+	for _, c := range cs {
+		r = append(r, prComment{ID: fmt.Sprint(c.ID), Body: c.Body})
+	}
+	return r, nil
+}
+
+func (p githubProvider) create(pr, body string) error {
+	return p.api.do("POST", fmt.Sprintf("/repos/%s/issues/%s/comments", p.repo, pr), githubComment{Body: body}, nil)
+}
+
+func (p githubProvider) update(pr, id, body string) error {
+	return p.api.do("PATCH", fmt.Sprintf("/repos/%s/issues/comments/%s", p.repo, id), githubComment{Body: body}, nil)
+}
+
+type gitlabProvider struct {
+	api     apiClient
+	project string
+}
+
+type gitlabNote struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+func (p gitlabProvider) notesPath(pr string) string {
+	return fmt.Sprintf("/projects/%s/merge_requests/%s/notes", url.PathEscape(p.project), pr)
+}
+
+func (p gitlabProvider) comments(pr string) ([]prComment, error) {
+	var ns []gitlabNote
+	if err := p.api.do("GET", p.notesPath(pr)+"?per_page=100", nil, &ns); err != nil {
+		return nil, err
+	}
+	var r []prComment
+	for _, n := range ns {
+		r = append(r, prComment{ID: fmt.Sprint(n.ID), Body: n.Body})
+	}
+	return r, nil
+}
+
+func (p gitlabProvider) create(pr, body string) error {
+	return p.api.do("POST", p.notesPath(pr), gitlabNote{Body: body}, nil)
+}
+
+func (p gitlabProvider) update(pr, id, body string) error {
+	return p.api.do("PUT", p.notesPath(pr)+"/"+id, gitlabNote{Body: body}, nil)
+}
+
+// newCommentProvider selects the provider from the host of the repository URL. The API URL
+// is derived from the host unless apiURL is set. Every API request fails after the timeout.
+func newCommentProvider(repoURL, apiURL, token string, timeout time.Duration) (commentProvider, error) {
+	m := repoURLPattern.FindStringSubmatch(strings.TrimSpace(repoURL))
+	if m == nil {
+		return nil, fmt.Errorf("unsupported repository URL: %s", repoURL)
+	}
+	host, repo := strings.ToLower(m[1]), m[2]
+	api := apiClient{client: &http.Client{Transport: transport, Timeout: timeout}, baseURL: strings.TrimSuffix(apiURL, "/"), header: http.Header{}}
+
+	switch {
+	case strings.Contains(host, "github"):
+		if api.baseURL == "" {
+			api.baseURL = "https://api.github.com"
+			if host != "github.com" {
+				api.baseURL = "https://" + host + "/api/v3"
+			}
+		}
+		api.header.Set("Authorization", "token "+token)
+		api.header.Set("Accept", "application/vnd.github+json")
+		return githubProvider{api: api, repo: repo}, nil
+	case strings.Contains(host, "gitlab"):
+		if api.baseURL == "" {
+			api.baseURL = "https://" + host + "/api/v4"
+		}
+		api.header.Set("PRIVATE-TOKEN", token)
+		return gitlabProvider{api: api, project: repo}, nil
+	default:
+		return nil, fmt.Errorf("pull request comments are not supported for %s", host)
+	}
+}
+
+// postPRComment creates the pull request comment showing the message, or updates the one
+// created for a previous build of the pull request.
+func postPRComment(p commentProvider, pr, markdown string) error {
+	body := commentMarker + "\n" + markdown
+	cs, err := p.comments(pr)
+	if err != nil {
+		return fmt.Errorf("failed to list the comments: %s", err)
+	}
+	for _, c := range cs {
+		if strings.Contains(c.Body, commentMarker) {
+			return p.update(pr, c.ID, body)
+		}
+	}
+	return p.create(pr, body)
+}
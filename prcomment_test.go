@@ -0,0 +1,88 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewCommentProvider(t *testing.T) {
+	tests := []struct {
+		repoURL string
+		wantAPI string
+		wantErr bool
+	}{
+		{"https://github.com/org/app.git", "https://api.github.com", false},
+		{"git@github.example.com:org/app.git", "https://github.example.com/api/v3", false},
+		{"https://gitlab.com/group/app", "https://gitlab.com/api/v4", false},
+		{"https://bitbucket.org/org/app.git", "", true},
+	}
+	for _, tt := range tests {
+		p, err := newCommentProvider(tt.repoURL, "", "token", time.Second)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newCommentProvider(%q) error = %v, want error %v", tt.repoURL, err, tt.wantErr)
+			continue
+		}
+		var api apiClient
+		switch p := p.(type) {
+		case githubProvider:
+			api = p.api
+		case gitlabProvider:
+			api = p.api
+		}
+		if api.baseURL != tt.wantAPI {
+			t.Errorf("newCommentProvider(%q) API = %q, want %q", tt.repoURL, api.baseURL, tt.wantAPI)
+		}
+		if err == nil && api.client.Timeout != time.Second {
+			t.Errorf("newCommentProvider(%q) timeout = %s, want 1s", tt.repoURL, api.client.Timeout)
+		}
+	}
+}
+
+func TestPostPRCommentTimesOut(t *testing.T) {
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	p, err := newCommentProvider("https://github.com/org/app.git", srv.URL, "token", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := postPRComment(p, "1", "Build Succeeded!"); err == nil {
+		t.Error("postPRComment() succeeded, want a timeout")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("postPRComment() returned after %s", d)
+	}
+}
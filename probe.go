@@ -0,0 +1,119 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Health classes of a probed webhook.
+const (
+	webhookHealthy   = "healthy"
+	webhookMissing   = "missing"
+	webhookThrottled = "throttled"
+	webhookUnknown   = "unknown"
+)
+
+// probePayload is rejected by connectors and Workflows as it contains no card,
+// so probing never posts a visible message.
+const probePayload = "{}"
+
+// classifyProbe classifies the webhook from the response to the probe payload.
+// A rejection of the payload itself shows that the endpoint exists.
+func classifyProbe(status int, body string) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return webhookThrottled
+	case status == http.StatusNotFound, status == http.StatusGone,
+		status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return webhookMissing
+	case status >= 200 && status <= 299 && isHTMLResponse("", []byte(body)):
+		return webhookMissing
+	case status >= 200 && status <= 299, status == http.StatusBadRequest:
+		return webhookHealthy
+	case status >= 400 && status <= 499 && strings.Contains(strings.ToLower(body), "required"):
+		return webhookHealthy
+	default:
+		return webhookUnknown
+	}
+}
+
+// healthSeverity orders the health classes from the best to the worst.
+var healthSeverity = map[string]int{webhookHealthy: 0, webhookThrottled: 1, webhookUnknown: 2, webhookMissing: 3}
+
+// probe classifies the webhook from the response to the probe payload.
+func probe(s *Sender) string {
+	resp, err := s.send(context.Background(), []byte(probePayload), http.Header{}, false, true)
+	if err != nil {
+		logger.Errorf("Probe failed: %s", err)
+		return webhookUnknown
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		logger.Warnf("Failed to read response: %s", err)
+	}
+	if err := resp.Body.Close(); err != nil {
+		logger.Warnf("Failed to close response body: %s", err)
+	}
+	logger.Debugf("Probe response: %s, %s\n", resp.Status, body)
+	return classifyProbe(resp.StatusCode, string(body))
+}
+
+// runProbe checks whether the webhooks exist without posting a visible message, exports
+// the worst result and returns the exit code of the step.
+func runProbe(conf Config, urls []string, header http.Header) int {
+	health := webhookHealthy
+	for i, url := range urls {
+		s := newSender(url, time.Duration(conf.TimeoutSeconds)*time.Second)
+		s.Header = header
+		s.SigningSecret, s.SignatureHeader = string(conf.SigningSecret), conf.SignatureHeader
+		h := probe(s)
+		if len(urls) > 1 {
+			logger.Printf("Webhook %d (%s): %s", i+1, redactedHost(url), h)
+		}
+		if healthSeverity[h] > healthSeverity[health] {
+			health = h
+		}
+	}
+
+	if err := exportEnv("TEAMS_WEBHOOK_HEALTH", health); err != nil {
+		logger.Warnf("%s", err)
+	}
+	switch health {
+	case webhookHealthy:
+		logger.Donef("The webhook is healthy.")
+	case webhookThrottled:
+		logger.Warnf("The webhook exists but it is throttled.")
+	default:
+		logger.Errorf("The webhook is %s.", health)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,138 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// recordedResponse reads a response recorded in testdata/probe.
+func recordedResponse(t *testing.T, name string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(readFixture(t, "probe", name+".http"))), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, body
+}
+
+// replay serves the recorded response to every request and records the bodies of the requests.
+func replay(t *testing.T, name string, requests *[]string) *httptest.Server {
+	resp, body := recordedResponse(t, name)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		*requests = append(*requests, string(b))
+		for k, vs := range resp.Header {
+			w.Header()[k] = vs
+		}
+		w.WriteHeader(resp.StatusCode)
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+var probeFixtures = []struct {
+	fixture string
+	want    string
+}{
+	{"connector-summary-required", webhookHealthy},
+	{"connector-bad-payload", webhookHealthy},
+	{"connector-removed", webhookMissing},
+	{"connector-not-found", webhookMissing},
+	{"connector-throttled", webhookThrottled},
+	{"connector-configuration-page", webhookMissing},
+	{"workflow-accepted", webhookHealthy},
+	{"workflow-property-required", webhookHealthy},
+	{"workflow-unauthorized", webhookMissing},
+	{"workflow-server-error", webhookUnknown},
+}
+
+func TestClassifyProbe(t *testing.T) {
+	for _, tt := range probeFixtures {
+		resp, body := recordedResponse(t, tt.fixture)
+		if got := classifyProbe(resp.StatusCode, string(body)); got != tt.want {
+			t.Errorf("classifyProbe(%s) = %s, want %s", tt.fixture, got, tt.want)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	for _, tt := range probeFixtures {
+		t.Run(tt.fixture, func(t *testing.T) {
+			captureLog(t)
+			var requests []string
+			srv := replay(t, tt.fixture, &requests)
+			if got := probe(newSender(srv.URL, 0)); got != tt.want {
+				t.Errorf("probe(%s) = %s, want %s", tt.fixture, got, tt.want)
+			}
+			if len(requests) != 1 || requests[0] != probePayload {
+				t.Errorf("requests %q, want the probe payload once", requests)
+			}
+		})
+	}
+}
+
+func TestRunProbe(t *testing.T) {
+	tests := []struct {
+		name       string
+		fixtures   []string
+		wantHealth string
+		wantCode   int
+	}{
+		{"healthy", []string{"connector-summary-required", "workflow-accepted"}, webhookHealthy, 0},
+		{"throttled", []string{"workflow-accepted", "connector-throttled"}, webhookThrottled, 0},
+		{"worst wins", []string{"connector-removed", "connector-throttled", "workflow-server-error"}, webhookMissing, 1},
+		{"unknown", []string{"workflow-server-error"}, webhookUnknown, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			exported := captureOutputs(t)
+			var urls []string
+			for _, f := range tt.fixtures {
+				var requests []string
+				urls = append(urls, replay(t, f, &requests).URL)
+			}
+			if code := runProbe(Config{TimeoutSeconds: 5}, urls, http.Header{}); code != tt.wantCode {
+				t.Errorf("runProbe() = %d, want %d", code, tt.wantCode)
+			}
+			if got := exported["TEAMS_WEBHOOK_HEALTH"]; got != tt.wantHealth {
+				t.Errorf("TEAMS_WEBHOOK_HEALTH = %q, want %q", got, tt.wantHealth)
+			}
+		})
+	}
+}
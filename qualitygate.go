@@ -0,0 +1,161 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// Condition is a requirement of the quality gate on the value of a fact.
+type Condition struct {
+	Metric    string
+	Operator  string
+	Threshold string
+}
+
+var gateOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func validOperator(op string) bool {
+	for _, o := range gateOperators {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// parsesConditions parses lines of metric|operator|threshold, empty lines are omitted.
+func parsesConditions(s string) ([]Condition, error) {
+	var cs []Condition
+	for i, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		a := strings.Split(line, "|")
+		if len(a) != 3 {
+			return nil, fmt.Errorf("invalid quality gate condition in line %d, expected metric|operator|threshold", i+1)
+		}
+		c := Condition{Metric: strings.TrimSpace(a[0]), Operator: strings.TrimSpace(a[1]), Threshold: strings.TrimSpace(a[2])}
+		if c.Metric == "" || !validOperator(c.Operator) {
+			return nil, fmt.Errorf("invalid quality gate condition in line %d, operators: %s", i+1, strings.Join(gateOperators, " "))
+		}
+		cs = append(cs, c)
+	}
+	return cs, nil
+}
+
+// parseNumber parses a number optionally followed by a percent sign.
+func parseNumber(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%")), 64)
+	return f, err == nil
+}
+
+// compare evaluates the operator numerically if both sides are numbers, otherwise as strings.
+func compare(value, op, threshold string) bool {
+	v, vok := parseNumber(value)
+	t, tok := parseNumber(threshold)
+	if vok && tok {
+		switch op {
+		case "==":
+			return v == t
+		case "!=":
+			return v != t
+		case "<":
+			return v < t
+		case "<=":
+			return v <= t
+		case ">":
+			return v > t
+		case ">=":
+			return v >= t
+		}
+	}
+	switch op {
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	}
+	return false
+}
+
+// findFact returns the value of the first fact with the given name, ignoring case.
+func findFact(sections []Section, name string) (string, bool) {
+	for _, s := range sections {
+		for _, f := range s.Facts {
+			if strings.EqualFold(strings.TrimSpace(f.Name), name) {
+				return strings.TrimSpace(f.Value), true
+			}
+		}
+	}
+	return "", false
+}
+
+// evaluateGate checks the conditions against the facts of the message and returns the
+// description of the failed ones. A missing metric fails its condition.
+func evaluateGate(sections []Section, cs []Condition) []string {
+	var failed []string
+	for _, c := range cs {
+		value, ok := findFact(sections, c.Metric)
+		switch {
+		case !ok:
+			failed = append(failed, fmt.Sprintf("%s missing", c.Metric))
+		case !compare(value, c.Operator, c.Threshold):
+			failed = append(failed, fmt.Sprintf("%s %s (expected %s %s)", c.Metric, value, c.Operator, c.Threshold))
+		}
+	}
+	return failed
+}
+
+// applyQualityGate adds the verdict of the quality gate as the first fact of the message.
+func applyQualityGate(c Config, msg *Message) error {
+	cs, err := parsesConditions(c.QualityGate)
+	if err != nil || len(cs) == 0 || len(msg.Sections) == 0 {
+		return err
+	}
+
+	verdict := Fact{Name: label(locale.FactQualityGate), Value: label(locale.QualityGatePassed)}
+	if failed := evaluateGate(msg.Sections, cs); len(failed) > 0 {
+		verdict.Value = label(locale.QualityGateFailed) + ": " + strings.Join(failed, ", ")
+		if c.QualityGateSetsColor && c.ThemeColorOnError != "" {
+			msg.ThemeColor = c.ThemeColorOnError
+		}
+	}
+	msg.Sections[0].Facts = append([]Fact{verdict}, msg.Sections[0].Facts...)
+	return nil
+}
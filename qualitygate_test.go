@@ -0,0 +1,82 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEvaluateGate(t *testing.T) {
+	sections := []Section{{Facts: []Fact{{Name: "Coverage", Value: "82.5%"}, {Name: "Lint", Value: "clean"}}}}
+	tests := []struct {
+		gate string
+		want []string
+	}{
+		{"coverage|>=|80", nil},
+		{"Coverage|<|80%", []string{"Coverage 82.5% (expected < 80%)"}},
+		{"lint|==|clean", nil},
+		{"Lint|!=|clean", []string{"Lint clean (expected != clean)"}},
+		{"Crashes|==|0", []string{"Crashes missing"}},
+	}
+	for _, tt := range tests {
+		cs, err := parsesConditions(tt.gate)
+		if err != nil {
+			t.Fatalf("parsesConditions(%q) error = %v", tt.gate, err)
+		}
+		if got := evaluateGate(sections, cs); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("evaluateGate(%q) = %q, want %q", tt.gate, got, tt.want)
+		}
+	}
+}
+
+func TestParsesConditionsInvalid(t *testing.T) {
+	for _, s := range []string{"coverage|>=", "coverage|=>|80", "|>=|80"} {
+		if _, err := parsesConditions(s); err == nil {
+			t.Errorf("parsesConditions(%q): expected an error", s)
+		}
+	}
+}
+
+func TestQualityGateFactIsSplit(t *testing.T) {
+	c := Config{QualityGate: "coverage|>=|80", MaxFactsPerSection: 2}
+	msg := Message{Sections: []Section{{Facts: []Fact{{Name: "Coverage", Value: "90"}, {Name: "Branch", Value: "main"}}}}}
+	if err := applyQualityGate(c, &msg); err != nil {
+		t.Fatalf("applyQualityGate() error = %v", err)
+	}
+	msg.Sections = splitFacts(msg.Sections, c.MaxFactsPerSection)
+
+	for i, s := range msg.Sections {
+		if len(s.Facts) > c.MaxFactsPerSection {
+			t.Errorf("section %d has %d facts, want at most %d", i, len(s.Facts), c.MaxFactsPerSection)
+		}
+	}
+	if f := msg.Sections[0].Facts[0]; !strings.Contains(f.Value, "PASSED") {
+		t.Errorf("first fact = %+v, want the verdict", f)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// maxReleaseNotesLength is the number of characters of the release notes kept in the card.
+const maxReleaseNotesLength = 8000
+
+var (
+	headingPattern        = regexp.MustCompile(`^\s{0,3}#{1,6}\s+(.*?)\s*#*\s*$`)
+	tableSeparatorPattern = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+)
+
+// isTableRow reports whether the line is a row of a markdown table.
+func isTableRow(line string) bool {
+	l := strings.TrimSpace(line)
+	return strings.HasPrefix(l, "|") && strings.HasSuffix(l, "|") && len(l) > 1
+}
+
+// flattenTableRow joins the cells of a markdown table row.
+func flattenTableRow(line string) string {
+	l := strings.Trim(strings.TrimSpace(line), "|")
+	var cells []string
+	for _, c := range strings.Split(l, "|") {
+		if c = strings.TrimSpace(c); c != "" {
+			cells = append(cells, c)
+		}
+	}
+	return "- " + strings.Join(cells, " — ")
+}
+
+// convertMarkdown converts markdown to the subset supported by Teams: headings are
+// demoted to bold text and tables are flattened to lists, lists and links are kept.
+// It returns whether a table was flattened.
+func convertMarkdown(md string) (string, bool) {
+	var lines []string
+	flattened := false
+	for _, line := range strings.Split(strings.Replace(md, "\r\n", "\n", -1), "\n") {
+		switch {
+		case headingPattern.MatchString(line):
+			lines = append(lines, "**"+headingPattern.FindStringSubmatch(line)[1]+"**")
+		case isTableRow(line) && tableSeparatorPattern.MatchString(line):
+			flattened = true
+		case isTableRow(line):
+			lines = append(lines, flattenTableRow(line))
+			flattened = true
+		default:
+			lines = append(lines, line)
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), flattened
+}
+
+// openFence returns the byte offset of the code fence left open at the end of s, or -1.
+func openFence(s string) int {
+	open := -1
+	offset := 0
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if open < 0 {
+				open = offset
+			} else {
+				open = -1
+			}
+		}
+		offset += len(line)
+	}
+	return open
+}
+
+// truncateText shortens s to at most n characters, appending an ellipsis if it was cut.
+// A code fence is never left open: the text is cut at the end of a line of the fenced
+// block, which is then closed. If n is too short to hold the closing fence, the block is dropped.
+func truncateText(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	cut := string(r[:n-1])
+	open := openFence(cut)
+	if open < 0 {
+		return cut + "…"
+	}
+
+	const closing = "\n…\n```"
+	if n < utf8.RuneCountInString(closing) {
+		return strings.TrimRight(cut[:open], "\n") + "…"
+	}
+	block := string(r[:n-utf8.RuneCountInString(closing)])
+	if openFence(block) != open {
+		// The cut is too close to the opening fence to keep any of the block.
+		return strings.TrimRight(cut[:open], "\n") + "…"
+	}
+	if i := strings.LastIndex(block, "\n"); i > open {
+		block = block[:i]
+	}
+	return block + closing
+}
+
+// releaseNotesSection reads the markdown release notes from path. A missing file is an error
+// if required is set, otherwise no section is returned.
+func releaseNotesSection(path string, required bool) (*Section, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		logger.Warnf("Release notes file does not exist: %s", path)
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read release notes: %s", err)
+	}
+
+	text, flattened := convertMarkdown(string(b))
+	if flattened {
+		logger.Warnf("Tables of the release notes are flattened to lists, Teams doesn't render markdown tables.")
+	}
+	if text == "" {
+		return nil, nil
+	}
+	return &Section{Title: label(locale.SectionReleaseNotes), Text: truncateText(text, maxReleaseNotesLength), Formatted: true, Excerpt: true}, nil
+}
@@ -0,0 +1,179 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		n    int
+		want string
+	}{
+		{"short enough", "abc", 3, "abc"},
+		{"cut with an ellipsis", "abcdef", 4, "abc…"},
+		{"multi-byte characters", "äöüäöü", 4, "äöü…"},
+		{"zero", "abc", 0, ""},
+		{"negative", "abc", -1, ""},
+		{"fence closed", "intro\n```\nline 1\nline 2\nline 3\n```", 24, "intro\n```\nline 1\n…\n```"},
+		{"fence dropped when too close", "intro\n```\nline 1\n```", 12, "intro…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := truncateText(tt.in, tt.n); got != tt.want {
+				t.Errorf("truncateText(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncateTextShortLimitsInFence(t *testing.T) {
+	for _, s := range []string{"```\nfenced code\n```", "a\n```\nfenced code\n```", "abcd\n```\nfenced code\n```"} {
+		for n := 0; n <= 6; n++ {
+			got := truncateText(s, n)
+			if l := utf8.RuneCountInString(got); l > n {
+				t.Errorf("truncateText(%q, %d) = %q, %d characters", s, n, got, l)
+			}
+			if openFence(got) >= 0 {
+				t.Errorf("truncateText(%q, %d) = %q, the fence is left open", s, n, got)
+			}
+		}
+	}
+}
+
+func TestTruncateTextNeverLeavesFenceOpen(t *testing.T) {
+	s := "Failure:\n```\n" + strings.Repeat("at frame\n", 50) + "```\nDone"
+	for n := 0; n <= utf8.RuneCountInString(s); n++ {
+		got := truncateText(s, n)
+		if l := utf8.RuneCountInString(got); l > n {
+			t.Fatalf("truncateText(s, %d) is %d characters", n, l)
+		}
+		if openFence(got) >= 0 {
+			t.Fatalf("truncateText(s, %d) = %q, the fence is left open", n, got)
+		}
+	}
+}
+
+func TestConvertMarkdownFixtures(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		flattened bool
+	}{
+		{"table", true},
+		{"nested-lists", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			md, err := ioutil.ReadFile(filepath.Join("testdata", "releasenotes", tt.fixture+".md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden, err := ioutil.ReadFile(filepath.Join("testdata", "releasenotes", tt.fixture+".golden"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, flattened := convertMarkdown(string(md))
+			if want := strings.TrimSuffix(string(golden), "\n"); got != want {
+				t.Errorf("convertMarkdown(%s.md) =\n%s\nwant\n%s", tt.fixture, got, want)
+			}
+			if flattened != tt.flattened {
+				t.Errorf("convertMarkdown(%s.md) flattened = %v, want %v", tt.fixture, flattened, tt.flattened)
+			}
+		})
+	}
+}
+
+func TestConvertMarkdownLineEndings(t *testing.T) {
+	got, _ := convertMarkdown("### Fixes\r\n\r\n- Crash on start\r\n    #### not a heading\r\n")
+	if want := "**Fixes**\n\n- Crash on start\n    #### not a heading"; got != want {
+		t.Errorf("convertMarkdown() = %q, want %q", got, want)
+	}
+}
+
+func TestReleaseNotesSection(t *testing.T) {
+	dir := t.TempDir()
+	notes := filepath.Join(dir, "CHANGELOG_SECTION.md")
+	if err := ioutil.WriteFile(notes, []byte("## Fixes\n\n- Crash on start\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	blank := filepath.Join(dir, "blank.md")
+	if err := ioutil.WriteFile(blank, []byte("\n \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		path     string
+		required bool
+		wantText string
+		wantErr  bool
+	}{
+		{"notes", notes, false, "**Fixes**\n\n- Crash on start", false},
+		{"missing", filepath.Join(dir, "missing.md"), false, "", false},
+		{"missing required", filepath.Join(dir, "missing.md"), true, "", true},
+		{"blank", blank, true, "", false},
+		{"directory", dir, false, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			s, err := releaseNotesSection(tt.path, tt.required)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("releaseNotesSection(%s, %v) error = %v, want error %v", tt.name, tt.required, err, tt.wantErr)
+			}
+			if tt.wantText == "" {
+				if s != nil {
+					t.Errorf("releaseNotesSection(%s, %v) = %+v, want no section", tt.name, tt.required, s)
+				}
+				return
+			}
+			if s == nil || s.Text != tt.wantText || s.Title != "Release notes" || !s.Formatted || !s.Excerpt {
+				t.Errorf("releaseNotesSection(%s, %v) = %+v, want a formatted excerpt %q", tt.name, tt.required, s, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestReleaseNotesSectionTruncated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.md")
+	if err := ioutil.WriteFile(path, []byte(strings.Repeat("- item\n", maxReleaseNotesLength)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := releaseNotesSection(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := utf8.RuneCountInString(s.Text); n != maxReleaseNotesLength || !strings.HasSuffix(s.Text, "…") {
+		t.Errorf("release notes of %d characters ending %q, want %d ending with an ellipsis", n, s.Text[len(s.Text)-8:], maxReleaseNotesLength)
+	}
+}
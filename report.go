@@ -0,0 +1,187 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/colorstring"
+)
+
+// RunReport collects the details of a run, which are summarized at the end of the run.
+type RunReport struct {
+	Host          string
+	CorrelationID string
+	CardFormat    string
+	PayloadSize   int
+	Facts         int
+	Buttons       int
+	Images        int
+	Warnings      int
+	Attempts      int
+	// ResponseStatus and ResponseBody are those of the last attempt, they are empty if it got
+	// no response.
+	ResponseStatus int
+	ResponseBody   string
+	// Degraded lists the elements dropped from the card accepted after a rejection.
+	Degraded string
+	// Payload is the body of the last request, before its compression.
+	Payload []byte
+	// MessageID and MessageLink identify the message created by the Graph API.
+	MessageID   string
+	MessageLink string
+	Duration    time.Duration
+	Status      string
+}
+
+// fields returns the summary of the run as the fields of a log line.
+func (r RunReport) fields() logFields {
+	return logFields{
+		"host":           r.Host,
+		"correlation_id": r.CorrelationID,
+		"card_format":    r.CardFormat,
+		"payload_size":   r.PayloadSize,
+		"facts":          r.Facts,
+		"buttons":        r.Buttons,
+		"images":         r.Images,
+		"warnings":       r.Warnings,
+		"attempts":       r.Attempts,
+		"duration_ms":    r.Duration.Milliseconds(),
+		"status":         r.Status,
+	}
+}
+
+// attemptFields returns the result of a delivery attempt as the fields of a log line.
+func attemptFields(host string, r *RunReport, err error) logFields {
+	fields := logFields{
+		"attempt":         r.Attempts,
+		"host":            host,
+		"payload_size":    r.PayloadSize,
+		"response_status": r.ResponseStatus,
+	}
+	if err != nil {
+		fields["error"] = redactURLError(err).Error()
+	}
+	return fields
+}
+
+// merge adds the attempts of the delivery to a webhook to the report. The response and the
+// message identity are those of the last webhook having them.
+func (r *RunReport) merge(t RunReport) {
+	r.Attempts += t.Attempts
+	if t.PayloadSize != 0 {
+		r.PayloadSize = t.PayloadSize
+	}
+	if t.ResponseStatus != 0 {
+		r.ResponseStatus, r.ResponseBody = t.ResponseStatus, t.ResponseBody
+	}
+	if t.MessageID != "" {
+		r.MessageID, r.MessageLink = t.MessageID, t.MessageLink
+	}
+	if t.Degraded != "" {
+		r.Degraded = t.Degraded
+	}
+}
+
+// countContent records the number of facts, buttons and images of the message.
+func (r *RunReport) countContent(msg Message) {
+	r.Facts, r.Buttons, r.Images = 0, 0, 0
+	for _, s := range msg.Sections {
+		r.Facts += len(s.Facts)
+		r.Buttons += len(s.Actions)
+		r.Images += len(s.Images)
+		if s.HeroImage != nil {
+			r.Images++
+		}
+	}
+}
+
+// redactedHost returns the scheme and the host of the URL, its path is a credential.
+func redactedHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "(invalid URL)"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// redactURLError replaces the URL in the error of a request, or of parsing a URL, with its
+// redacted host, as the webhook URL is a credential. Errors which may contain the webhook URL
+// must go through it before they are logged.
+func redactURLError(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		ue.URL = redactedHost(ue.URL)
+	}
+	return err
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// String formats the summary block of the run.
+func (r RunReport) String() string {
+	return fmt.Sprintf(colorstring.Bluef("Summary:\n")+
+		"- Host: %s\n"+
+		"- Correlation ID: %s\n"+
+		"- Card format: %s\n"+
+		"- Payload size: %d bytes\n"+
+		"- Facts: %d, buttons: %d, images: %d\n"+
+		"- Warnings: %d\n"+
+		"- Attempts: %d\n"+
+		"- Duration: %s\n"+
+		"- Status: %s\n",
+		orDash(r.Host), orDash(r.CorrelationID), orDash(r.CardFormat), r.PayloadSize, r.Facts, r.Buttons, r.Images, r.Warnings, r.Attempts,
+		r.Duration.Round(time.Millisecond), r.Status)
+}
+
+// warningCounter counts the warnings written to the log.
+type warningCounter struct {
+	w      io.Writer
+	report *RunReport
+}
+
+// warningPrefix is the color code starting the warnings.
+var warningPrefix = []byte(strings.SplitN(colorstring.Yellow("|"), "|", 2)[0])
+
+func (c warningCounter) Write(p []byte) (int, error) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if bytes.HasPrefix(p, warningPrefix) {
+		c.report.Warnings++
+	}
+	return c.w.Write(p)
+}
@@ -0,0 +1,124 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/log"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of testdata/summary")
+
+// webhookReplies answers the requests with the given statuses in order, the last one repeated.
+func webhookReplies(statuses ...int) *httptest.Server {
+	n := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := statuses[len(statuses)-1]
+		if n < len(statuses) {
+			status = statuses[n]
+		}
+		n++
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte("1"))
+		} else {
+			w.Write([]byte(http.StatusText(status)))
+		}
+	}))
+}
+
+func TestSummaryGolden(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		wantCode int
+	}{
+		{"success", []int{http.StatusOK}, 0},
+		{"retried", []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK}, 0},
+		{"failed", []int{http.StatusBadRequest}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BITRISE_DEPLOY_DIR", t.TempDir())
+			captureOutputs(t)
+			srv := webhookReplies(tt.statuses...)
+			defer srv.Close()
+
+			// The warnings are counted from the log as by main.
+			report := &RunReport{Status: "failed", Host: "https://contoso.webhook.office.com", CorrelationID: "run-1"}
+			saved := logger
+			logger = textLogger{}
+			log.SetOutWriter(warningCounter{w: ioutil.Discard, report: report})
+			t.Cleanup(func() {
+				logger = saved
+				log.SetOutWriter(os.Stdout)
+			})
+
+			p := &sendPipeline{
+				conf: Config{
+					Title:        "Build Succeeded!",
+					Subject:      "Fix the login",
+					Fields:       "Branch|main\nCoverage|80%",
+					Images:       "Icon|https://example.com/icon.png",
+					Buttons:      "Build|https://app.bitrise.io/build/42",
+					FailOnError:  true,
+					RetryCount:   3,
+					MaxPayloadKB: 25,
+				},
+				report: report,
+				urls:   []string{srv.URL},
+			}
+			if code := p.run(); code != tt.wantCode {
+				t.Fatalf("run() = %d, want %d", code, tt.wantCode)
+			}
+			report.Duration = 1234567 * time.Microsecond
+			got := colorCodes.ReplaceAllString(report.String(), "")
+
+			path := filepath.Join("testdata", "summary", tt.name+".golden")
+			if *updateGolden {
+				if err := ioutil.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("summary =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrPermanent is an error which sending the same message again can't fix.
+type ErrPermanent struct {
+	Err error
+}
+
+func (e *ErrPermanent) Error() string { return e.Err.Error() }
+
+// Unwrap returns the wrapped error.
+func (e *ErrPermanent) Unwrap() error { return e.Err }
+
+// ErrTransient is an error which may go away if the message is sent again.
+type ErrTransient struct {
+	Err error
+}
+
+func (e *ErrTransient) Error() string { return e.Err.Error() }
+
+// Unwrap returns the wrapped error.
+func (e *ErrTransient) Unwrap() error { return e.Err }
+
+func permanent(err error) error { return &ErrPermanent{Err: err} }
+
+func transient(err error) error { return &ErrTransient{Err: err} }
+
+// isTransient reports whether err is classified as transient, unclassified errors are permanent.
+func isTransient(err error) bool {
+	var t *ErrTransient
+	return errors.As(err, &t)
+}
+
+// classifyStatus wraps the error of a failed response according to its status code: throttled
+// requests were not processed, they are transient. Timeouts and server errors may come after
+// the message was processed, they are transient only if the request carries an idempotency
+// key. Every other status is permanent.
+func classifyStatus(code int, err error, idempotent bool) error {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return transient(err)
+	case code != http.StatusRequestTimeout && code < 500:
+		return permanent(err)
+	case idempotent:
+		return transient(err)
+	default:
+		return permanent(fmt.Errorf("%w (the message may have been delivered, it is retried only if idempotency_key_header is set)", err))
+	}
+}
+
+// withRetry calls fn until it succeeds, fails with a permanent error or the retries run out.
+// The wait time doubles after every attempt, the failed attempts are logged with the name.
+func withRetry(name string, retries int, wait time.Duration, sleep func(time.Duration), fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || !isTransient(err) || attempt >= retries {
+			return err
+		}
+		logger.Warnf("%s: attempt %d failed: %s", name, attempt+1, err)
+		logger.Printf("%s: retrying in %s...", name, wait)
+		sleep(wait)
+		wait *= 2
+	}
+}
@@ -0,0 +1,109 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	tests := []struct {
+		code       int
+		idempotent bool
+		want       bool
+	}{
+		{http.StatusBadRequest, true, false},
+		{http.StatusUnauthorized, true, false},
+		{http.StatusNotFound, true, false},
+		{http.StatusRequestEntityTooLarge, true, false},
+		{http.StatusTooManyRequests, false, true},
+		{http.StatusTooManyRequests, true, true},
+		{http.StatusRequestTimeout, false, false},
+		{http.StatusRequestTimeout, true, true},
+		{http.StatusInternalServerError, false, false},
+		{http.StatusInternalServerError, true, true},
+		{http.StatusBadGateway, false, false},
+		{http.StatusBadGateway, true, true},
+		{http.StatusServiceUnavailable, false, false},
+		{http.StatusServiceUnavailable, true, true},
+	}
+	for _, tt := range tests {
+		failed := errors.New("failed")
+		err := classifyStatus(tt.code, failed, tt.idempotent)
+		if got := isTransient(err); got != tt.want {
+			t.Errorf("classifyStatus(%d, %t) transient = %v, want %v", tt.code, tt.idempotent, got, tt.want)
+		}
+		if !errors.Is(err, failed) {
+			t.Errorf("classifyStatus(%d, %t) = %v, want it to wrap the error", tt.code, tt.idempotent, err)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	errTransient := transient(errors.New("throttled"))
+	errPermanent := permanent(errors.New("bad request"))
+	errUnclassified := errors.New("unknown")
+	tests := []struct {
+		name      string
+		retries   int
+		results   []error
+		wantCalls int
+		wantWaits []time.Duration
+		wantErr   error
+	}{
+		{"first attempt succeeds", 3, []error{nil}, 1, nil, nil},
+		{"succeeds after retries", 3, []error{errTransient, errTransient, nil}, 3, []time.Duration{time.Second, 2 * time.Second}, nil},
+		{"retries run out", 2, []error{errTransient, errTransient, errTransient, nil}, 3, []time.Duration{time.Second, 2 * time.Second}, errTransient},
+		{"permanent error", 3, []error{errPermanent, nil}, 1, nil, errPermanent},
+		{"unclassified error", 3, []error{errUnclassified, nil}, 1, nil, errUnclassified},
+		{"no retries", 0, []error{errTransient, nil}, 1, nil, errTransient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			calls := 0
+			var waits []time.Duration
+			err := withRetry("test", tt.retries, time.Second, func(d time.Duration) { waits = append(waits, d) }, func() error {
+				calls++
+				return tt.results[calls-1]
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("calls = %d, want %d", calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(waits, tt.wantWaits) {
+				t.Errorf("waits = %v, want %v", waits, tt.wantWaits)
+			}
+			if err != tt.wantErr {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSampledBoundaries(t *testing.T) {
+	tests := []struct {
+		percent int
+		want    bool
+	}{
+		{-10, false},
+		{0, false},
+		{100, true},
+		{150, true},
+	}
+	for _, tt := range tests {
+		for _, slug := range []string{"", "a1b2c3d4e5f6", "0f1e2d3c4b5a"} {
+			if got := sampled(slug, tt.percent); got != tt.want {
+				t.Errorf("sampled(%q, %d) = %v, want %v", slug, tt.percent, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestSampledIsDeterministic(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		slug := fmt.Sprintf("build-%d", i)
+		first := sampled(slug, 37)
+		for j := 0; j < 10; j++ {
+			if got := sampled(slug, 37); got != first {
+				t.Fatalf("sampled(%q, 37) = %v, then %v", slug, first, got)
+			}
+		}
+	}
+}
+
+func TestSampledPercentage(t *testing.T) {
+	const builds = 10000
+	for _, percent := range []int{1, 10, 50, 90, 99} {
+		n := 0
+		for i := 0; i < builds; i++ {
+			slug := fmt.Sprintf("%016x", i*7919)
+			if sampled(slug, percent) {
+				n++
+				if !sampled(slug, percent+1) {
+					t.Errorf("sampled(%q, %d) = true, but false at %d percent", slug, percent, percent+1)
+				}
+			}
+		}
+		if got := n * 100 / builds; got < percent-3 || got > percent+3 {
+			t.Errorf("%d percent sampling notified %d of %d builds", percent, n, builds)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+// markdownEscaper escapes the characters Teams renders as markdown.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`, `#`, `\#`, `>`, `\>`, `~`, `\~`,
+)
+
+// blankLinesPattern matches more than two consecutive blank lines.
+var blankLinesPattern = regexp.MustCompile(`\n(?:[ \t]*\n){3,}`)
+
+// normalizeText replaces the \n escapes with newlines, the \t escapes and the tabs with four
+// spaces and the CRLF line endings with LF, and collapses more than two blank lines to two.
+// An escaped backslash is kept, so \\n stays as it is. Normalized text is not changed again.
+func normalizeText(s string) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == 'n':
+			b.WriteByte('\n')
+			i++
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == 't':
+			b.WriteString("    ")
+			i++
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '\\':
+			b.WriteString(`\\`)
+			i++
+		case s[i] == '\t':
+			b.WriteString("    ")
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return blankLinesPattern.ReplaceAllString(b.String(), "\n\n\n")
+}
+
+// sanitizeText normalizes line endings to \n and strips the other ASCII control characters,
+// which make Teams reject the card. If escape is set the markdown specials are escaped.
+func sanitizeText(s string, escape bool) string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\r':
+			return '\n'
+		case r == '\n':
+			return r
+		case r < 0x20 || r == 0x7f:
+			return -1
+		}
+		return r
+	}, s)
+	if escape {
+		s = markdownEscaper.Replace(s)
+	}
+	return s
+}
+
+// sanitizeMessage sanitizes the titles and texts, the facts and the button titles of the
+// sections of the message. The texts and the fact values are normalized first. The control
+// characters are only stripped from the formatted sections.
+func sanitizeMessage(msg *Message, escape bool) {
+	for i := range msg.Sections {
+		s := &msg.Sections[i]
+		if s.Formatted {
+			sanitizeFormattedSection(s)
+			continue
+		}
+		s.ActivityTitle = sanitizeText(s.ActivityTitle, escape)
+		s.ActivityText = sanitizeText(normalizeText(s.ActivityText), escape)
+		s.Title = sanitizeText(s.Title, escape)
+		s.Text = sanitizeText(normalizeText(s.Text), escape)
+		for j := range s.Facts {
+			s.Facts[j].Name = sanitizeText(s.Facts[j].Name, escape)
+			s.Facts[j].Value = sanitizeText(normalizeText(s.Facts[j].Value), escape && !s.Facts[j].Formatted)
+		}
+		for j := range s.Actions {
+			s.Actions[j].Name = sanitizeText(s.Actions[j].Name, escape)
+		}
+	}
+}
+
+// sanitizeFormattedSection strips the control characters from the markdown of a formatted
+// section, like sanitizeText without escaping.
+func sanitizeFormattedSection(s *Section) {
+	s.ActivityTitle = sanitizeText(s.ActivityTitle, false)
+	s.ActivityText = sanitizeText(s.ActivityText, false)
+	s.Title = sanitizeText(s.Title, false)
+	s.Text = sanitizeText(s.Text, false)
+	for j := range s.Facts {
+		s.Facts[j].Name = sanitizeText(s.Facts[j].Name, false)
+		s.Facts[j].Value = sanitizeText(s.Facts[j].Value, false)
+	}
+	for j := range s.Actions {
+		s.Actions[j].Name = sanitizeText(s.Actions[j].Name, false)
+	}
+}
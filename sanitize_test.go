@@ -0,0 +1,147 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import "testing"
+
+func TestSanitizeMessage(t *testing.T) {
+	msg := Message{Sections: []Section{
+		{ActivityText: "fix_*all*\\nbugs\x07", Facts: []Fact{{Name: "a_b", Value: "x*y"}, {Name: "code", Value: "`a_b`", Formatted: true}}},
+		{Title: "Stack trace", Text: "```\nat foo_bar(*)\x00\n```", Formatted: true},
+	}}
+	sanitizeMessage(&msg, true)
+
+	if got, want := msg.Sections[0].ActivityText, "fix\\_\\*all\\*\nbugs"; got != want {
+		t.Errorf("ActivityText = %q, want %q", got, want)
+	}
+	if got, want := msg.Sections[0].Facts[0], (Fact{Name: `a\_b`, Value: `x\*y`}); got != want {
+		t.Errorf("Facts[0] = %+v, want %+v", got, want)
+	}
+	if got, want := msg.Sections[0].Facts[1].Value, "`a_b`"; got != want {
+		t.Errorf("formatted fact = %q, want %q", got, want)
+	}
+	if got, want := msg.Sections[1].Text, "```\nat foo_bar(*)\n```"; got != want {
+		t.Errorf("formatted section = %q, want %q", got, want)
+	}
+}
+
+func TestSanitizeMessageDigestEscapedOnce(t *testing.T) {
+	legacy := Message{Title: "UI tests", Sections: []Section{{ActivityText: "a_b"}}}
+	sanitizeMessage(&legacy, true)
+	collected := digestEntry{Version: digestVersion, Title: "Unit tests", Sections: []digestSection{newDigestSection(Section{ActivityText: "e_f"})}}
+
+	msg := Message{Sections: []Section{{ActivityText: "c_d"}}}
+	appendDigest(&msg, []digestEntry{
+		{Title: legacy.Title, Sections: []digestSection{{Section: legacy.Sections[0]}}},
+		collected,
+	})
+	sanitizeMessage(&msg, true)
+
+	for i, want := range []string{`c\_d`, `a\_b`, `e\_f`} {
+		if got := msg.Sections[i].ActivityText; got != want {
+			t.Errorf("Sections[%d].ActivityText = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestShortenMessageURLsSkipsFormattedSections(t *testing.T) {
+	long := "https://example.com/a/very/long/path/to/the/artifact.ipa"
+	msg := Message{Sections: []Section{
+		{ActivityText: long},
+		{Text: long, ActivityText: long, Formatted: true},
+	}}
+	shortenMessageURLs(&msg, 20, false)
+
+	if got, want := msg.Sections[0].ActivityText, "[artifact.ipa]("+long+")"; got != want {
+		t.Errorf("ActivityText = %q, want %q", got, want)
+	}
+	if got := msg.Sections[1].ActivityText; got != long {
+		t.Errorf("formatted ActivityText = %q, want it unchanged", got)
+	}
+}
+
+func TestNormalizeText(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"clean", "Fix the login\n\nRetry on 401", "Fix the login\n\nRetry on 401"},
+		{"CRLF", "Fix the login\r\nRetry on 401\r\n", "Fix the login\nRetry on 401\n"},
+		{"escaped newline", `Fix the login\nRetry on 401`, "Fix the login\nRetry on 401"},
+		{"escaped tab", `step:\tbuild`, "step:    build"},
+		{"tab", "step:\tbuild", "step:    build"},
+		{"escaped backslash", `C:\\new\\tools`, `C:\\new\\tools`},
+		{"trailing backslash", `C:\`, `C:\`},
+		{"two blank lines kept", "a\n\n\nb", "a\n\n\nb"},
+		{"three blank lines collapsed", "a\n\n\n\nb", "a\n\n\nb"},
+		{"more blank lines collapsed", "a\n\n\n\n\n\nb", "a\n\n\nb"},
+		{"blank lines with spaces", "a\n \n\t\n  \n\nb", "a\n\n\nb"},
+		{"unicode", "Käse 🧀\r\nÜber", "Käse 🧀\nÜber"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalizeText(tt.in)
+			if got != tt.want {
+				t.Errorf("normalizeText(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+			if again := normalizeText(got); again != got {
+				t.Errorf("normalizeText(%q) = %q, want it unchanged", got, again)
+			}
+		})
+	}
+}
+
+func TestNormalizeWindowsAgentText(t *testing.T) {
+	// The subject and a fact as exported by `git log` through PowerShell on a self-hosted
+	// Windows agent.
+	subject := "Merge pull request #42 from team/fix-login\r\n\r\n\r\n\r\n\r\nFix the login\\n\\tRetry on 401\r\n" +
+		"Artifacts in \\\\buildserver\\\\drops\r\n"
+	fact := "src\\\\Login.cs\r\n\tsrc\\\\Retry.cs"
+	msg := Message{Sections: []Section{
+		{ActivityText: subject, Facts: []Fact{{Name: "Files", Value: fact}}},
+		{Title: "Details", Text: subject},
+	}}
+	sanitizeMessage(&msg, false)
+
+	wantSubject := "Merge pull request #42 from team/fix-login\n\n\nFix the login\n    Retry on 401\n" +
+		"Artifacts in \\\\buildserver\\\\drops\n"
+	if got := msg.Sections[0].ActivityText; got != wantSubject {
+		t.Errorf("subject = %q, want %q", got, wantSubject)
+	}
+	if got, want := msg.Sections[0].Facts[0].Value, "src\\\\Login.cs\n    src\\\\Retry.cs"; got != want {
+		t.Errorf("fact value = %q, want %q", got, want)
+	}
+	if got := msg.Sections[1].Text; got != wantSubject {
+		t.Errorf("section text = %q, want %q", got, wantSubject)
+	}
+}
+
+func TestNewMessageNormalizesSubject(t *testing.T) {
+	msg, _ := newMessage(Config{Subject: "Fix the login\r\n\\tRetry on 401"})
+	if got, want := msg.Sections[0].ActivityText, "Fix the login\n    Retry on 401"; got != want {
+		t.Errorf("subject = %q, want %q", got, want)
+	}
+}
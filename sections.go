@@ -0,0 +1,115 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sectionSeparator separates the sections of the sections input.
+const sectionSeparator = "---"
+
+// parsesSections parses the sections separated by --- lines. In a section, the lines starting
+// with title:, fact: name|value and image: title|url set those, the other lines are the
+// text. Sections without content are omitted.
+func parsesSections(s string) (ss []Section, errs []error) {
+	var blocks [][]string
+	block := []string{}
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) == sectionSeparator {
+			blocks = append(blocks, block)
+			block = []string{}
+			continue
+		}
+		block = append(block, line)
+	}
+	blocks = append(blocks, block)
+
+	for i, lines := range blocks {
+		var sec Section
+		var text []string
+		for _, line := range lines {
+			key, value := "", line
+			if a := strings.SplitN(line, ":", 2); len(a) == 2 {
+				key, value = strings.TrimSpace(a[0]), strings.TrimSpace(a[1])
+			}
+			switch key {
+			case "title":
+				sec.Title = value
+			case "fact", "image":
+				p := strings.SplitN(value, "|", 2)
+				if len(p) != 2 || p[0] == "" || p[1] == "" {
+					errs = append(errs, fmt.Errorf("sections section %d: %s is not a title|value pair, it is omitted", i+1, line))
+				} else if key == "fact" {
+					sec.Facts = append(sec.Facts, Fact{Name: p[0], Value: p[1]})
+				} else if !isWebURL(p[1]) {
+					errs = append(errs, fmt.Errorf("sections section %d: image is not an http(s) URL, it is omitted: %s", i+1, p[1]))
+				} else {
+					sec.Images = append(sec.Images, Image{Title: p[0], URL: p[1]})
+				}
+			default:
+				text = append(text, line)
+			}
+		}
+		sec.Text = strings.TrimSpace(strings.Join(text, "\n"))
+		if sec.Title != "" || sec.Text != "" || len(sec.Facts) > 0 || len(sec.Images) > 0 {
+			ss = append(ss, sec)
+		}
+	}
+	return
+}
+
+// jsonSection is a section of the json input format.
+type jsonSection struct {
+	Title  string     `json:"title"`
+	Text   string     `json:"text"`
+	Facts  []Fact     `json:"facts"`
+	Images []jsonLink `json:"images"`
+}
+
+// jsonSections parses the sections of the json input format. Sections without content are omitted.
+func jsonSections(s string) ([]Section, error) {
+	var js []jsonSection
+	if err := decodeJSONInput("sections", s, &js); err != nil {
+		return nil, err
+	}
+	var ss []Section
+	for i, j := range js {
+		sec := Section{Title: j.Title, Text: j.Text, Facts: j.Facts}
+		for _, img := range j.Images {
+			if img.Title == "" || !isWebURL(img.URL) {
+				return nil, fmt.Errorf("sections item %d has an image without a title or an http(s) URL", i+1)
+			}
+			sec.Images = append(sec.Images, Image{Title: img.Title, URL: img.URL})
+		}
+		if sec.Title != "" || sec.Text != "" || len(sec.Facts) > 0 || len(sec.Images) > 0 {
+			ss = append(ss, sec)
+		}
+	}
+	return ss, nil
+}
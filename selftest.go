@@ -0,0 +1,95 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// selftestMessage returns the canned card of the selftest operation, it does not depend on
+// any message input.
+func selftestMessage(now time.Time) Message {
+	return Message{
+		Context:    "https://schema.org/extension",
+		Type:       "MessageCard",
+		ThemeColor: "0078d7",
+		Title:      label(locale.SelftestTitle),
+		Summary:    label(locale.SelftestTitle),
+		Sections: []Section{{
+			ActivityText: label(locale.SelftestText),
+			Facts:        []Fact{{Name: label(locale.FactSentAt), Value: now.UTC().Format(time.RFC3339)}},
+		}},
+	}
+}
+
+// runSelftest posts the selftest card to the webhooks with deliver, like the messages, so the
+// headers, the signature, the proxy, the idempotency key and the retries are exercised too. It
+// logs the response status and the round trip time of the last attempt to every webhook and
+// returns the exit code of the step, which fails if the card could not be posted to one of them.
+// In a dry run the card is only printed.
+func runSelftest(conf Config, urls []string, header http.Header, report *RunReport) int {
+	msg := selftestMessage(time.Now())
+	report.countContent(msg)
+	if conf.DryRun {
+		return dryRun(conf, msg, report)
+	}
+	key, err := idempotencyKey(conf)
+	if err != nil {
+		logger.Errorf("Error: %s", err)
+		return 1
+	}
+	// The selftest card is not a notification, it is not recorded in the audit log.
+	results := deliver(conf, msg, delivery{URLs: urls, Header: header, Key: key})
+
+	sent := 0
+	for _, result := range results {
+		report.merge(result.Report)
+		status := "-"
+		if code := result.Report.ResponseStatus; code != 0 {
+			status = strconv.Itoa(code)
+		}
+		roundTrip := result.RoundTrip.Round(time.Millisecond)
+		if result.Err != nil {
+			logger.Errorf("Selftest of %s failed: %s (status %s, round trip %s)", result.Name, result.Err, status, roundTrip)
+			continue
+		}
+		sent++
+		logger.Donef("Selftest of %s passed: status %s, round trip %s", result.Name, status, roundTrip)
+	}
+
+	exportDelivery(sent > 0, report)
+	if sent < len(urls) {
+		report.Status = "selftest failed"
+		return 1
+	}
+	report.Status = "selftest passed"
+	return 0
+}
@@ -0,0 +1,129 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// webURLPattern matches the http(s) URLs of a text, up to the first white space, quote,
+// backtick or angle bracket.
+var webURLPattern = regexp.MustCompile("https?://[^\\s<>\"'`]+")
+
+// markdownUnescaper reverts markdownEscaper in the URLs of an escaped text.
+var markdownUnescaper = strings.NewReplacer(
+	`\\`, `\`, "\\`", "`", `\*`, `*`, `\_`, `_`, `\[`, `[`, `\]`, `]`, `\#`, `#`, `\>`, `>`, `\~`, `~`,
+)
+
+// trimURL removes the trailing punctuation and the closing parenthesis which are not part of
+// the URL, eg. of "(see https://example.com/a_(b))." only the inner parentheses are kept.
+func trimURL(u string) string {
+	for u != "" {
+		last := u[len(u)-1]
+		switch {
+		case strings.IndexByte(".,;:!?", last) >= 0:
+			u = u[:len(u)-1]
+		case last == ')' && strings.Count(u, ")") > strings.Count(u, "("):
+			u = u[:len(u)-1]
+		default:
+			return u
+		}
+	}
+	return u
+}
+
+// urlDisplayText returns the last segment of the path of the URL, or its host.
+func urlDisplayText(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if name := path.Base(u.Path); name != "." && name != "/" {
+		return name
+	}
+	return u.Host
+}
+
+// inMarkdownLink reports whether the URL after the text is the text or the destination of a
+// markdown link, or an autolink.
+func inMarkdownLink(before string) bool {
+	return strings.HasSuffix(before, "](") || strings.HasSuffix(before, "[") || strings.HasSuffix(before, "<")
+}
+
+// inCode reports whether the text after the given text is in a code block or a code span.
+func inCode(before string) bool {
+	line := before[strings.LastIndex(before, "\n")+1:]
+	return openFence(before) >= 0 || strings.Count(line, "`")%2 == 1
+}
+
+// shortenURLs replaces the URLs of the text longer than n characters with markdown links
+// showing the last segment of their path. The URLs of markdown links and of code blocks are
+// kept. escaped is set if the text was escaped by markdownEscaper, the link then points to the
+// unescaped URL.
+func shortenURLs(s string, n int, escaped bool) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range webURLPattern.FindAllStringIndex(s, -1) {
+		start := m[0]
+		raw := trimURL(s[start:m[1]])
+		end := start + len(raw)
+		target := raw
+		if escaped {
+			target = markdownUnescaper.Replace(raw)
+		}
+		if utf8.RuneCountInString(target) <= n || inMarkdownLink(s[:start]) || inCode(s[:start]) {
+			continue
+		}
+		name := markdownEscaper.Replace(urlDisplayText(target))
+		b.WriteString(s[last:start])
+		b.WriteString("[" + name + "](" + target + ")")
+		last = end
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// shortenMessageURLs shortens the URLs of the subject and of the fact values, the URLs of the
+// buttons and the images are never changed. The facts formatted as code and the formatted
+// sections are kept.
+func shortenMessageURLs(msg *Message, n int, escaped bool) {
+	for i := range msg.Sections {
+		if msg.Sections[i].Formatted {
+			continue
+		}
+		msg.Sections[i].ActivityText = shortenURLs(msg.Sections[i].ActivityText, n, escaped)
+		for j, f := range msg.Sections[i].Facts {
+			if !f.Formatted {
+				msg.Sections[i].Facts[j].Value = shortenURLs(f.Value, n, escaped)
+			}
+		}
+	}
+}
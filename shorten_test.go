@@ -0,0 +1,136 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+const signedURL = "https://bitrise-prod-build-storage.s3.amazonaws.com/builds/42/artifacts/app-release.ipa?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Expires=3600&X-Amz-Signature=0a1b2c3d"
+
+func TestTrimURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"https://example.com/a", "https://example.com/a"},
+		{"https://example.com/a.", "https://example.com/a"},
+		{"https://example.com/a?!,", "https://example.com/a"},
+		{"https://en.wikipedia.org/wiki/Go_(language)", "https://en.wikipedia.org/wiki/Go_(language)"},
+		{"https://en.wikipedia.org/wiki/Go_(language)).", "https://en.wikipedia.org/wiki/Go_(language)"},
+		{"https://example.com/a)", "https://example.com/a"},
+		{"https://example.com/?q=a:b", "https://example.com/?q=a:b"},
+	}
+	for _, tt := range tests {
+		if got := trimURL(tt.in); got != tt.want {
+			t.Errorf("trimURL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestURLDisplayText(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{signedURL, "app-release.ipa"},
+		{"https://example.com/reports/coverage/", "coverage"},
+		{"https://example.com/?build=42", "example.com"},
+		{"https://example.com", "example.com"},
+		{"https://example.com/%zz", "https://example.com/%zz"},
+	}
+	for _, tt := range tests {
+		if got := urlDisplayText(tt.in); got != tt.want {
+			t.Errorf("urlDisplayText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestShortenURLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		n       int
+		escaped bool
+		want    string
+	}{
+		{"short URL kept", "see https://example.com/a", 80, false, "see https://example.com/a"},
+		{"query string", "IPA: " + signedURL, 80, false, "IPA: [app-release.ipa](" + signedURL + ")"},
+		{"exactly the limit", "https://example.com/abc", 23, false, "https://example.com/abc"},
+		{"over the limit", "https://example.com/abcd", 23, false, "[abcd](https://example.com/abcd)"},
+		{"parentheses", "(see https://en.wikipedia.org/wiki/Go_(programming_language)).", 20, false,
+			"(see [Go\\_(programming\\_language)](https://en.wikipedia.org/wiki/Go_(programming_language))).",
+		},
+		{"several URLs", "a https://example.com/one/first.apk and https://example.com/two/second.apk", 20, false,
+			"a [first.apk](https://example.com/one/first.apk) and [second.apk](https://example.com/two/second.apk)",
+		},
+		{"markdown link kept", "[IPA](" + signedURL + ")", 20, false, "[IPA](" + signedURL + ")"},
+		{"autolink kept", "<" + signedURL + ">", 20, false, "<" + signedURL + ">"},
+		{"code span kept", "run `curl " + signedURL + "`", 20, false, "run `curl " + signedURL + "`"},
+		{"code block kept", "```\n" + signedURL + "\n```", 20, false, "```\n" + signedURL + "\n```"},
+		{"escaped text", `see https://example.com/my\_app.ipa?sig=1`, 10, true, `see [my\_app.ipa](https://example.com/my_app.ipa?sig=1)`},
+		{"quoted", `"` + signedURL + `"`, 20, false, `"[app-release.ipa](` + signedURL + `)"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shortenURLs(tt.in, tt.n, tt.escaped); got != tt.want {
+				t.Errorf("shortenURLs(%q, %d, %v) =\n%q\nwant\n%q", tt.in, tt.n, tt.escaped, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShortenMessageURLs(t *testing.T) {
+	msg := Message{Sections: []Section{
+		{
+			ActivityText: "Download " + signedURL,
+			Facts: []Fact{
+				{Name: "IPA", Value: signedURL},
+				{Name: "Command", Value: "`curl " + signedURL + "`", Formatted: true},
+			},
+			Images:  []Image{{URL: signedURL, Title: "QR"}},
+			Actions: []Action{{Type: "OpenUri", Name: "Download", Targets: []Target{{OS: "default", URI: signedURL}}}},
+		},
+		{Title: "Release notes", Text: signedURL, Formatted: true},
+	}}
+	shortenMessageURLs(&msg, 80, false)
+	link := "[app-release.ipa](" + signedURL + ")"
+	want := Message{Sections: []Section{
+		{
+			ActivityText: "Download " + link,
+			Facts: []Fact{
+				{Name: "IPA", Value: link},
+				{Name: "Command", Value: "`curl " + signedURL + "`", Formatted: true},
+			},
+			Images:  []Image{{URL: signedURL, Title: "QR"}},
+			Actions: []Action{{Type: "OpenUri", Name: "Download", Targets: []Target{{OS: "default", URI: signedURL}}}},
+		},
+		{Title: "Release notes", Text: signedURL, Formatted: true},
+	}}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("shortenMessageURLs() = %+v, want %+v", msg, want)
+	}
+}
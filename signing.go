@@ -0,0 +1,200 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// errBadSignature is returned if a payload does not match its signature.
+var errBadSignature = errors.New("the signature does not match the payload")
+
+// readPEMKey reads the private or public key of a PEM file, ed25519 and ECDSA keys are supported.
+func readPEMKey(path string) (interface{}, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %s", err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block found", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block: %s", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid key: %s", path, err)
+	}
+	switch key.(type) {
+	case ed25519.PrivateKey, *ecdsa.PrivateKey, ed25519.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("%s: only ed25519 and ECDSA keys are supported", path)
+	}
+}
+
+// hmacSignature returns the hex HMAC-SHA256 of the body with the secret.
+func hmacSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signPayload signs the payload with the private key: ed25519 signs the payload itself and
+// ECDSA its SHA-256 digest.
+func signPayload(key interface{}, payload []byte) ([]byte, error) {
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return k.Sign(rand.Reader, payload, crypto.Hash(0))
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(payload)
+		return ecdsa.SignASN1(rand.Reader, k, digest[:])
+	default:
+		return nil, errors.New("a private key is required to sign the payload")
+	}
+}
+
+// verifyPayload checks the signature of the payload with a public or private key.
+func verifyPayload(key interface{}, payload, signature []byte) error {
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return verifyPayload(k.Public(), payload, signature)
+	case *ecdsa.PrivateKey:
+		return verifyPayload(&k.PublicKey, payload, signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, payload, signature) {
+			return errBadSignature
+		}
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(k, digest[:], signature) {
+			return errBadSignature
+		}
+	default:
+		return errors.New("unsupported key")
+	}
+	return nil
+}
+
+// archiveSignedPayload writes the payload and its base64 signature next to it into the
+// deploy dir and exports the signature.
+func archiveSignedPayload(key interface{}, payload []byte) error {
+	if payload == nil {
+		return errors.New("failed to sign the payload: no request was sent")
+	}
+	signature, err := signPayload(key, payload)
+	if err != nil {
+		return fmt.Errorf("failed to sign the payload: %s", err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(signature)
+
+	path := outputPath("teams-message-payload.json")
+	if err := writeFileAtomic(path, payload, 0600); err != nil {
+		return fmt.Errorf("failed to write the payload: %s", err)
+	}
+	if err := writeFileAtomic(path+".sig", []byte(encoded+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write the signature: %s", err)
+	}
+	logger.Printf("Signed payload written to %s", path)
+	if err := exportEnv("TEAMS_MESSAGE_PAYLOAD_PATH", path); err != nil {
+		return err
+	}
+	return exportEnv("TEAMS_MESSAGE_PAYLOAD_SIGNATURE", encoded)
+}
+
+// sentPayload returns the body posted to the first webhook which accepted the message, or the
+// last body posted to the first webhook if none did. The reduced cards of a rejected message
+// differ from the message, so the body is taken from the requests.
+func sentPayload(results []targetResult) []byte {
+	for _, r := range results {
+		if r.Err == nil {
+			return r.Report.Payload
+		}
+	}
+	if len(results) == 0 {
+		return nil
+	}
+	return results[0].Report.Payload
+}
+
+// runVerify checks the payload at path against the signature stored next to it and returns
+// the exit code of the step.
+func runVerify(keyPath, path string) int {
+	if keyPath == "" || path == "" {
+		logger.Errorf("Error: payload_signing_key_path and verify_payload_path are required by the verify operation")
+		return 1
+	}
+	key, err := readPEMKey(keyPath)
+	if err != nil {
+		logger.Errorf("Error: %s", err)
+		return 1
+	}
+	payload, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.Errorf("Error: failed to read payload: %s", err)
+		return 1
+	}
+	encoded, err := ioutil.ReadFile(path + ".sig")
+	if err != nil {
+		logger.Errorf("Error: failed to read signature: %s", err)
+		return 1
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		logger.Errorf("Error: invalid signature: %s", err)
+		return 1
+	}
+
+	if err := verifyPayload(key, payload, signature); err != nil {
+		logger.Errorf("Error: %s: %s", path, err)
+		return 1
+	}
+	logger.Donef("The signature of %s is valid.", path)
+	return 0
+}
@@ -0,0 +1,360 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// mapExporter records the exported outputs.
+type mapExporter map[string]string
+
+func (m mapExporter) Export(key, value string) error {
+	m[key] = value
+	return nil
+}
+
+// captureOutputs replaces the exporter of the outputs until the test ends.
+func captureOutputs(t *testing.T) mapExporter {
+	m := mapExporter{}
+	saved := outputs
+	outputs = m
+	t.Cleanup(func() { outputs = saved })
+	return m
+}
+
+func TestSentPayload(t *testing.T) {
+	failed := errors.New("failed")
+	tests := []struct {
+		name    string
+		results []targetResult
+		want    string
+	}{
+		{"no webhook", nil, ""},
+		{"single webhook", []targetResult{{Report: RunReport{Payload: []byte("full")}}}, "full"},
+		{"first accepted", []targetResult{{Err: failed, Report: RunReport{Payload: []byte("one")}}, {Report: RunReport{Payload: []byte("two")}}}, "two"},
+		{"none accepted", []targetResult{{Err: failed, Report: RunReport{Payload: []byte("one")}}, {Err: failed, Report: RunReport{Payload: []byte("two")}}}, "one"},
+	}
+	for _, tt := range tests {
+		if got := string(sentPayload(tt.results)); got != tt.want {
+			t.Errorf("%s: sentPayload() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestArchiveSignedPayload(t *testing.T) {
+	captureLog(t)
+	exported := captureOutputs(t)
+	dir := t.TempDir()
+	t.Setenv("BITRISE_DEPLOY_DIR", dir)
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := []byte(`{"title":"Build Succeeded! (some elements omitted)"}`)
+	if err := archiveSignedPayload(private, payload); err != nil {
+		t.Fatalf("archiveSignedPayload() error = %v", err)
+	}
+	path := filepath.Join(dir, "teams-message-payload.json")
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != string(payload) {
+		t.Errorf("archived payload = %q, %v, want the posted payload", b, err)
+	}
+	b, err := ioutil.ReadFile(path + ".sig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(public, payload, signature) {
+		t.Error("the signature does not match the posted payload")
+	}
+	if exported["TEAMS_MESSAGE_PAYLOAD_PATH"] != path {
+		t.Errorf("TEAMS_MESSAGE_PAYLOAD_PATH = %q, want %q", exported["TEAMS_MESSAGE_PAYLOAD_PATH"], path)
+	}
+
+	if err := archiveSignedPayload(private, nil); err == nil {
+		t.Error("archiveSignedPayload() without a request: expected an error")
+	}
+}
+
+func TestHMACSignature(t *testing.T) {
+	// The test vector of the HMAC-SHA256 Wikipedia article.
+	const want = "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"
+	if got := hmacSignature("key", []byte("The quick brown fox jumps over the lazy dog")); got != want {
+		t.Errorf("hmacSignature() = %s, want %s", got, want)
+	}
+}
+
+// verifyingRelay checks the signature of the requests as a relay would, over the raw body.
+type verifyingRelay struct {
+	secret, header string
+	signed         []bool
+	bodies         [][]byte
+}
+
+func (v *verifyingRelay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raw, _ := ioutil.ReadAll(r.Body)
+	mac := hmac.New(sha256.New, []byte(v.secret))
+	mac.Write(raw)
+	got, err := hex.DecodeString(r.Header.Get(v.header))
+	v.signed = append(v.signed, err == nil && hmac.Equal(got, mac.Sum(nil)))
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		if zr, err := gzip.NewReader(bytes.NewReader(raw)); err == nil {
+			raw, _ = ioutil.ReadAll(zr)
+		}
+	}
+	v.bodies = append(v.bodies, raw)
+	w.Write([]byte("1"))
+}
+
+func TestRequestSignature(t *testing.T) {
+	long := strings.Repeat("All tests passed. ", 2000)
+	tests := []struct {
+		name     string
+		conf     Config
+		wantSent bool
+	}{
+		{"signed", Config{Title: "Build Succeeded!", Subject: "Fix the login", SigningSecret: "s3cret", SignatureHeader: "X-Signature"}, true},
+		{"custom header", Config{Title: "Build Succeeded!", Subject: "Fix the login", SigningSecret: "s3cret", SignatureHeader: "X-Hub-Signature-256"}, true},
+		{"truncated", Config{Title: "Build Succeeded!", Subject: long, MaxPayloadKB: 2, SigningSecret: "s3cret", SignatureHeader: "X-Signature"}, true},
+		{"compressed", Config{Title: "Build Succeeded!", Subject: long, CompressRequest: true, SigningSecret: "s3cret", SignatureHeader: "X-Signature"}, true},
+		{"no secret", Config{Title: "Build Succeeded!", Subject: "Fix the login", SignatureHeader: "X-Signature"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BITRISE_DEPLOY_DIR", t.TempDir())
+			captureOutputs(t)
+			captureLog(t)
+			relay := &verifyingRelay{secret: "s3cret", header: tt.conf.SignatureHeader}
+			srv := httptest.NewServer(relay)
+			defer srv.Close()
+
+			p := &sendPipeline{conf: tt.conf, report: &RunReport{}, urls: []string{srv.URL}}
+			if code := p.run(); code != 0 {
+				t.Fatalf("run() = %d, want 0", code)
+			}
+			if len(relay.signed) != 1 || relay.signed[0] != tt.wantSent {
+				t.Fatalf("valid signatures %v, want [%v]", relay.signed, tt.wantSent)
+			}
+			// The signed body is the payload after all its changes.
+			payload, err := marshalPayload(p.conf, p.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(relay.bodies[0], payload) {
+				t.Errorf("relay received %d bytes, want the %d bytes of the payload", len(relay.bodies[0]), len(payload))
+			}
+			if tt.conf.MaxPayloadKB > 0 && len(relay.bodies[0]) > tt.conf.MaxPayloadKB*1024 {
+				t.Errorf("relay received %d bytes, want the payload truncated to %d KB", len(relay.bodies[0]), tt.conf.MaxPayloadKB)
+			}
+		})
+	}
+}
+
+// writePEM writes the DER bytes as a PEM block of the type into dir and returns its path.
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// signingKeys generates an ed25519 and an ECDSA key pair and writes them into dir, the paths
+// of the private keys are keyed by their name and the public keys by the name plus ".pub".
+func signingKeys(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	paths := map[string]string{}
+	for name, k := range map[string]interface{}{"ed25519": edPrivate, "ecdsa": ecPrivate} {
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths[name] = writePEM(t, dir, name+".pem", "PRIVATE KEY", der)
+		if der, err = x509.MarshalPKIXPublicKey(k.(crypto.Signer).Public()); err != nil {
+			t.Fatal(err)
+		}
+		paths[name+".pub"] = writePEM(t, dir, name+".pub.pem", "PUBLIC KEY", der)
+	}
+	der, err := x509.MarshalECPrivateKey(ecPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths["ecdsa-sec1"] = writePEM(t, dir, "ecdsa-sec1.pem", "EC PRIVATE KEY", der)
+	return paths
+}
+
+func TestReadPEMKey(t *testing.T) {
+	dir := t.TempDir()
+	keys := signingKeys(t, dir)
+	for name, path := range keys {
+		if _, err := readPEMKey(path); err != nil {
+			t.Errorf("readPEMKey(%s) error = %v", name, err)
+		}
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaDER, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbage := filepath.Join(dir, "garbage.pem")
+	if err := ioutil.WriteFile(garbage, []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{"missing file", filepath.Join(dir, "missing.pem"), "failed to read key"},
+		{"no PEM block", garbage, "no PEM block found"},
+		{"unsupported block", writePEM(t, dir, "cert.pem", "CERTIFICATE", []byte("x")), "unsupported PEM block: CERTIFICATE"},
+		{"invalid key", writePEM(t, dir, "invalid.pem", "PRIVATE KEY", []byte("x")), "invalid key"},
+		{"RSA key", writePEM(t, dir, "rsa.pem", "PRIVATE KEY", rsaDER), "only ed25519 and ECDSA keys are supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readPEMKey(tt.path); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("readPEMKey() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignAndVerifyPayload(t *testing.T) {
+	keys := signingKeys(t, t.TempDir())
+	payload := []byte(`{"title":"Build Succeeded!"}`)
+	for _, name := range []string{"ed25519", "ecdsa", "ecdsa-sec1"} {
+		t.Run(name, func(t *testing.T) {
+			private, err := readPEMKey(keys[name])
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The SEC 1 key is the PKCS #8 ECDSA key in another encoding.
+			public, err := readPEMKey(keys[strings.TrimSuffix(name, "-sec1")+".pub"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			signature, err := signPayload(private, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := signPayload(public, payload); err == nil {
+				t.Error("signPayload() with a public key: expected an error")
+			}
+			for _, key := range []interface{}{private, public} {
+				if err := verifyPayload(key, payload, signature); err != nil {
+					t.Errorf("verifyPayload(%T) error = %v", key, err)
+				}
+			}
+			tampered := bytes.Replace(payload, []byte("Succeeded"), []byte("Failed"), 1)
+			if err := verifyPayload(public, tampered, signature); err != errBadSignature {
+				t.Errorf("verifyPayload() of a tampered payload error = %v, want %v", err, errBadSignature)
+			}
+			if err := verifyPayload(public, payload, signature[1:]); err != errBadSignature {
+				t.Errorf("verifyPayload() of a tampered signature error = %v, want %v", err, errBadSignature)
+			}
+		})
+	}
+}
+
+func TestRunVerify(t *testing.T) {
+	captureLog(t)
+	dir := t.TempDir()
+	keys := signingKeys(t, dir)
+	private, err := readPEMKey(keys["ed25519"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := []byte(`{"title":"Build Succeeded!"}`)
+	signature, err := signPayload(private, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "payload.json")
+	write := func(b []byte) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(payload)
+	if err := ioutil.WriteFile(path+".sig", []byte(base64.StdEncoding.EncodeToString(signature)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if code := runVerify(keys["ed25519.pub"], path); code != 0 {
+		t.Errorf("runVerify() = %d, want 0", code)
+	}
+	if code := runVerify(keys["ecdsa.pub"], path); code != 1 {
+		t.Errorf("runVerify() with another key = %d, want 1", code)
+	}
+	if code := runVerify("", path); code != 1 {
+		t.Errorf("runVerify() without a key = %d, want 1", code)
+	}
+	write(append(payload, ' '))
+	if code := runVerify(keys["ed25519.pub"], path); code != 1 {
+		t.Errorf("runVerify() of a tampered payload = %d, want 1", code)
+	}
+}
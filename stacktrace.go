@@ -0,0 +1,71 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// maxStackTraceLength is the number of characters of the stack trace kept in the card.
+const maxStackTraceLength = 4000
+
+var (
+	ansiPattern = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+	// framePattern matches the frames of Java/Kotlin ("at com.example.Foo.bar(Foo.java:12)")
+	// and Swift/Objective-C ("3   App   0x0000000102f4 main + 40") stack traces.
+	framePattern = regexp.MustCompile(`^\s*(at\s+\S|\d+\s+\S+\s+0x[0-9a-fA-F]+)`)
+)
+
+// trimFrames keeps the lines of the stack trace up to its first n frames and notes the number
+// of omitted lines.
+func trimFrames(trace string, n int) string {
+	lines := strings.Split(trace, "\n")
+	frames := 0
+	for i, line := range lines {
+		if !framePattern.MatchString(line) {
+			continue
+		}
+		if frames++; frames > n {
+			return strings.Join(lines[:i], "\n") + fmt.Sprintf("\n... (%d lines omitted)", len(lines)-i)
+		}
+	}
+	return trace
+}
+
+// stackTraceSection returns the section showing the top frames of the stack trace in a code block.
+func stackTraceSection(trace string, frames int) Section {
+	trace = strings.Replace(ansiPattern.ReplaceAllString(trace, ""), "\r\n", "\n", -1)
+	trace = strings.Replace(strings.TrimSpace(trace), "```", "'''", -1)
+	if frames > 0 {
+		trace = trimFrames(trace, frames)
+	}
+	return Section{Title: label(locale.SectionStackTrace), Text: truncateText("```\n"+trace+"\n```", maxStackTraceLength), Formatted: true, Excerpt: true}
+}
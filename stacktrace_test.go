@@ -0,0 +1,149 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const javaTrace = `java.lang.IllegalStateException: Login failed
+	at com.example.login.LoginViewModel.submit(LoginViewModel.kt:42)
+	at com.example.login.LoginFragment.onClick(LoginFragment.kt:88)
+	at android.view.View.performClick(View.java:7448)
+	at android.view.View$PerformClick.run(View.java:28305)
+Caused by: java.io.IOException: timeout
+	at okhttp3.internal.http2.Http2Stream.waitForIo(Http2Stream.kt:713)
+	... 12 more`
+
+const swiftTrace = `Thread 0 Crashed:
+0   App                           0x0000000102f4c8a4 LoginViewModel.submit() + 120
+1   App                           0x0000000102f4b1f0 LoginViewController.tap(_:) + 64
+2   UIKitCore                     0x00000001a4e0e6a0 -[UIApplication sendAction:to:from:forEvent:] + 96
+3   UIKitCore                     0x00000001a4e0e5b0 -[UIControl sendAction:to:forEvent:] + 80`
+
+func TestTrimFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		trace string
+		n     int
+		want  string
+	}{
+		{
+			"java",
+			javaTrace,
+			2,
+			"java.lang.IllegalStateException: Login failed\n" +
+				"\tat com.example.login.LoginViewModel.submit(LoginViewModel.kt:42)\n" +
+				"\tat com.example.login.LoginFragment.onClick(LoginFragment.kt:88)\n" +
+				"... (5 lines omitted)",
+		},
+		{
+			"java with the cause",
+			javaTrace,
+			5,
+			"java.lang.IllegalStateException: Login failed\n" +
+				"\tat com.example.login.LoginViewModel.submit(LoginViewModel.kt:42)\n" +
+				"\tat com.example.login.LoginFragment.onClick(LoginFragment.kt:88)\n" +
+				"\tat android.view.View.performClick(View.java:7448)\n" +
+				"\tat android.view.View$PerformClick.run(View.java:28305)\n" +
+				"Caused by: java.io.IOException: timeout\n" +
+				"\tat okhttp3.internal.http2.Http2Stream.waitForIo(Http2Stream.kt:713)\n" +
+				"\t... 12 more",
+		},
+		{
+			"swift",
+			swiftTrace,
+			1,
+			"Thread 0 Crashed:\n" +
+				"0   App                           0x0000000102f4c8a4 LoginViewModel.submit() + 120\n" +
+				"... (3 lines omitted)",
+		},
+		{"fewer frames", swiftTrace, 10, swiftTrace},
+		{"no frame", "fatal error: unexpectedly found nil", 1, "fatal error: unexpectedly found nil"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := trimFrames(tt.trace, tt.n); got != tt.want {
+				t.Errorf("trimFrames() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStackTraceSection(t *testing.T) {
+	tests := []struct {
+		name   string
+		trace  string
+		frames int
+		want   string
+	}{
+		{
+			"ansi codes stripped",
+			"\x1b[31mjava.lang.IllegalStateException\x1b[0m: Login failed\r\n\tat \x1b[1mcom.example.Login.submit\x1b[0m(Login.kt:42)\r\n",
+			0,
+			"```\njava.lang.IllegalStateException: Login failed\n\tat com.example.Login.submit(Login.kt:42)\n```",
+		},
+		{
+			"fences replaced",
+			"Error:\n```\n0   App   0x0000000102f4c8a4 main + 40\n```",
+			0,
+			"```\nError:\n'''\n0   App   0x0000000102f4c8a4 main + 40\n'''\n```",
+		},
+		{
+			"frames trimmed",
+			swiftTrace,
+			2,
+			"```\nThread 0 Crashed:\n" +
+				"0   App                           0x0000000102f4c8a4 LoginViewModel.submit() + 120\n" +
+				"1   App                           0x0000000102f4b1f0 LoginViewController.tap(_:) + 64\n" +
+				"... (2 lines omitted)\n```",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := stackTraceSection(tt.trace, tt.frames)
+			if s.Text != tt.want {
+				t.Errorf("Text =\n%q\nwant\n%q", s.Text, tt.want)
+			}
+			if s.Title != "Stack trace" || !s.Formatted || !s.Excerpt {
+				t.Errorf("section = %+v, want a formatted Stack trace excerpt", s)
+			}
+		})
+	}
+}
+
+func TestStackTraceSectionTruncated(t *testing.T) {
+	trace := strings.Repeat("\tat com.example.Deep.recurse(Deep.kt:1)\n", 500)
+	s := stackTraceSection(trace, 0)
+	if n := len([]rune(s.Text)); n > maxStackTraceLength {
+		t.Errorf("Text is %d characters, want at most %d", n, maxStackTraceLength)
+	}
+	if strings.Count(s.Text, "```")%2 != 0 || !strings.HasPrefix(s.Text, "```\n") {
+		t.Errorf("Text does not hold a closed code block: ...%s", s.Text[len(s.Text)-80:])
+	}
+}
@@ -0,0 +1,102 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// Stage is the result of a pipeline stage.
+type Stage struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Duration string `json:"duration"`
+}
+
+// stageEmojis maps the stage status words to the emoji representing them.
+var stageEmojis = map[string]string{
+	"success": "✅",
+	"failed":  "❌",
+	"skipped": "⬜",
+}
+
+// unknownStageEmoji represents a stage with an unrecognized status.
+const unknownStageEmoji = "❔"
+
+func stageEmoji(status string) string {
+	if e, ok := stageEmojis[strings.ToLower(strings.TrimSpace(status))]; ok {
+		return e
+	}
+	return unknownStageEmoji
+}
+
+// parsesStages parses either a JSON array of stages or lines of name|status|duration,
+// where the duration is optional.
+func parsesStages(s string) (ss []Stage) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		if err := json.Unmarshal([]byte(s), &ss); err == nil {
+			return
+		}
+	}
+	for _, line := range strings.Split(s, "\n") {
+		a := strings.SplitN(line, "|", 3)
+		if len(a) < 2 || a[0] == "" || a[1] == "" {
+			continue
+		}
+		st := Stage{Name: a[0], Status: a[1]}
+		if len(a) == 3 {
+			st.Duration = a[2]
+		}
+		ss = append(ss, st)
+	}
+	return
+}
+
+// stageBar renders the stages as a sequence of emojis.
+func stageBar(ss []Stage) string {
+	var es []string
+	for _, st := range ss {
+		es = append(es, stageEmoji(st.Status))
+	}
+	return strings.Join(es, " ")
+}
+
+// stagesSection lists the stages with their results and durations.
+func stagesSection(ss []Stage) Section {
+	var fs []Fact
+	for _, st := range ss {
+		value := stageEmoji(st.Status)
+		if st.Duration != "" {
+			value += " " + st.Duration
+		}
+		fs = append(fs, Fact{Name: st.Name, Value: value})
+	}
+	return Section{Title: label(locale.SectionStages), Facts: fs}
+}
@@ -0,0 +1,115 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsesStages(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want []Stage
+	}{
+		{"lines", "Build|success|1m 2s\nTest|failed\nDeploy|skipped", []Stage{
+			{Name: "Build", Status: "success", Duration: "1m 2s"},
+			{Name: "Test", Status: "failed"},
+			{Name: "Deploy", Status: "skipped"},
+		}},
+		{"JSON", `[{"name":"Build","status":"success","duration":"5s"},{"name":"Test","status":"failed"}]`, []Stage{
+			{Name: "Build", Status: "success", Duration: "5s"},
+			{Name: "Test", Status: "failed"},
+		}},
+		{"malformed lines skipped", "Build\n|success\nTest|\nLint|success", []Stage{{Name: "Lint", Status: "success"}}},
+		{"invalid JSON parsed as lines", "[Build|success", []Stage{{Name: "[Build", Status: "success"}}},
+		{"duration with a pipe", "Build|success|1m|2s", []Stage{{Name: "Build", Status: "success", Duration: "1m|2s"}}},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parsesStages(tt.in); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsesStages(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStageBar(t *testing.T) {
+	tests := []struct {
+		stages []Stage
+		want   string
+	}{
+		{[]Stage{{Status: "success"}, {Status: "failed"}, {Status: "skipped"}}, "✅ ❌ ⬜"},
+		{[]Stage{{Status: " SUCCESS "}, {Status: "Failed"}}, "✅ ❌"},
+		{[]Stage{{Status: "aborted"}, {Status: ""}}, "❔ ❔"},
+		{nil, ""},
+	}
+	for _, tt := range tests {
+		if got := stageBar(tt.stages); got != tt.want {
+			t.Errorf("stageBar(%v) = %q, want %q", tt.stages, got, tt.want)
+		}
+	}
+}
+
+func TestStagesSection(t *testing.T) {
+	got := stagesSection([]Stage{{Name: "Build", Status: "success", Duration: "5s"}, {Name: "Test", Status: "timeout"}})
+	want := Section{Title: "Stages", Facts: []Fact{{Name: "Build", Value: "✅ 5s"}, {Name: "Test", Value: "❔"}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("stagesSection() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCheckStages(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{"Build|success\nTest|failed|3s", false},
+		{`[{"name":"Build","status":"success"}]`, false},
+		{`[{"name":"Build",]`, true},
+		{"Build", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if errs := checkStages(tt.in); (len(errs) > 0) != tt.wantErr {
+			t.Errorf("checkStages(%q) = %v, want errors %v", tt.in, errs, tt.wantErr)
+		}
+	}
+}
+
+func TestNewMessageStages(t *testing.T) {
+	msg, _ := newMessage(Config{Subject: "Fix the login", Stages: "Build|success\nTest|failed|3s"})
+	if got, want := msg.Sections[0].ActivityText, "Fix the login\n\n✅ ❌"; got != want {
+		t.Errorf("activity text = %q, want %q", got, want)
+	}
+	last := msg.Sections[len(msg.Sections)-1]
+	if want := stagesSection(parsesStages("Build|success\nTest|failed|3s")); !reflect.DeepEqual(last, want) {
+		t.Errorf("last section = %+v, want %+v", last, want)
+	}
+}
@@ -0,0 +1,78 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// buildAge computes the age of the build from its unix trigger timestamp.
+func buildAge(timestamp string, now time.Time) (time.Duration, error) {
+	sec, err := strconv.ParseInt(strings.TrimSpace(timestamp), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid build trigger timestamp: %q", timestamp)
+	}
+	return now.Sub(time.Unix(sec, 0)), nil
+}
+
+// formatAge formats the age in whole hours, or in minutes below an hour.
+func formatAge(d time.Duration) string {
+	if d < time.Hour {
+		return fmt.Sprintf("%d minutes", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%d hours", int(d.Hours()))
+}
+
+// checkStaleBuild annotates the message if the build is older than the configured maximum age.
+// It returns true if the message should not be sent instead.
+func checkStaleBuild(c Config, msg *Message, timestamp string, now time.Time) bool {
+	if c.MaxBuildAgeMinutes <= 0 {
+		return false
+	}
+	age, err := buildAge(timestamp, now)
+	if err != nil {
+		logger.Debugf("Stale build guard disabled: %s\n", err)
+		return false
+	}
+	if age <= time.Duration(c.MaxBuildAgeMinutes)*time.Minute {
+		return false
+	}
+
+	if c.OnStaleBuild == "skip" {
+		logger.Warnf("The build was triggered %s ago, the message is not sent.", formatAge(age))
+		return true
+	}
+	if len(msg.Sections) > 0 {
+		note := fmt.Sprintf(label(locale.NoteStale), formatAge(age))
+		msg.Sections[0].ActivityText = strings.TrimSpace(note + "\n\n" + msg.Sections[0].ActivityText)
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildAge(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		timestamp string
+		want      time.Duration
+		wantErr   bool
+	}{
+		{"1699999400", 10 * time.Minute, false},
+		{" 1699999400\n", 10 * time.Minute, false},
+		{"1700000000", 0, false},
+		{"", 0, true},
+		{"yesterday", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := buildAge(tt.timestamp, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("buildAge(%q) error = %v, want error %v", tt.timestamp, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("buildAge(%q) = %s, want %s", tt.timestamp, got, tt.want)
+		}
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{59 * time.Minute, "59 minutes"},
+		{time.Hour, "1 hours"},
+		{26*time.Hour + 59*time.Minute, "26 hours"},
+	}
+	for _, tt := range tests {
+		if got := formatAge(tt.d); got != tt.want {
+			t.Errorf("formatAge(%s) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestCheckStaleBuild(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	triggered := func(ago time.Duration) string { return strconv.FormatInt(now.Add(-ago).Unix(), 10) }
+	tests := []struct {
+		name      string
+		conf      Config
+		timestamp string
+		wantSkip  bool
+		wantNote  bool
+	}{
+		{"guard disabled", Config{OnStaleBuild: "skip"}, triggered(48 * time.Hour), false, false},
+		{"fresh build", Config{MaxBuildAgeMinutes: 60, OnStaleBuild: "skip"}, triggered(59 * time.Minute), false, false},
+		{"at the limit", Config{MaxBuildAgeMinutes: 60, OnStaleBuild: "skip"}, triggered(time.Hour), false, false},
+		{"stale build annotated", Config{MaxBuildAgeMinutes: 60, OnStaleBuild: "annotate"}, triggered(3 * time.Hour), false, true},
+		{"stale build skipped", Config{MaxBuildAgeMinutes: 60, OnStaleBuild: "skip"}, triggered(3 * time.Hour), true, false},
+		{"invalid timestamp", Config{MaxBuildAgeMinutes: 60, OnStaleBuild: "skip"}, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			msg := Message{Sections: []Section{{ActivityText: "Tests passed"}}}
+			if got := checkStaleBuild(tt.conf, &msg, tt.timestamp, now); got != tt.wantSkip {
+				t.Errorf("checkStaleBuild() = %v, want %v", got, tt.wantSkip)
+			}
+			text := msg.Sections[0].ActivityText
+			if got := strings.Contains(text, "3 hours"); got != tt.wantNote {
+				t.Errorf("ActivityText = %q, want the note %v", text, tt.wantNote)
+			}
+			if !strings.HasSuffix(text, "Tests passed") {
+				t.Errorf("ActivityText = %q, the subject is lost", text)
+			}
+		})
+	}
+}
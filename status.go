@@ -0,0 +1,99 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/maguhiro/bitrise-step-send-microsoft-teams-message/locale"
+)
+
+// resolveBuildStatus determines whether the build is successful and describes the source of
+// the decision. The resolution order is:
+//
+//  1. the build_status input, unless it is "auto"
+//  2. a failure reported by $BITRISE_BUILD_STATUS or $STEPLIB_BUILD_STATUS
+//  3. a success reported by $BITRISE_BUILD_STATUS or $STEPLIB_BUILD_STATUS
+//  4. a success, if neither of them is set, as no step has failed
+//
+// Any value of the envs other than "0" is a failure.
+func resolveBuildStatus(input string, getenv func(string) string) (bool, string) {
+	switch input {
+	case "success":
+		return true, "build_status input"
+	case "failed":
+		return false, "build_status input"
+	}
+
+	keys := []string{"BITRISE_BUILD_STATUS", "STEPLIB_BUILD_STATUS"}
+	for _, key := range keys {
+		if v := strings.TrimSpace(getenv(key)); v != "" && v != "0" {
+			return false, fmt.Sprintf("$%s=%s", key, v)
+		}
+	}
+	for _, key := range keys {
+		if v := strings.TrimSpace(getenv(key)); v == "0" {
+			return true, fmt.Sprintf("$%s=%s", key, v)
+		}
+	}
+	return true, "no build status env is set"
+}
+
+// resolveLayout determines whether the message is laid out as a success. The precedence is:
+//
+//  1. force_success_layout or force_error_layout, they can't be both set
+//  2. the build status, as resolved by resolveBuildStatus
+func resolveLayout(forceSuccess, forceError, buildSuccess bool) (bool, error) {
+	switch {
+	case forceSuccess && forceError:
+		return false, errors.New("force_success_layout and force_error_layout can't be both enabled")
+	case forceSuccess:
+		return true, nil
+	case forceError:
+		return false, nil
+	}
+	return buildSuccess, nil
+}
+
+// buildStatusLabel returns the key of the label of the build status.
+func buildStatusLabel(success bool) string {
+	if success {
+		return locale.StatusSucceeded
+	}
+	return locale.StatusFailed
+}
+
+// withTitlePrefix returns the title starting with the prefix of the build status. A title
+// which already starts with it, or without any text, is returned unchanged.
+func withTitlePrefix(title, prefix string) string {
+	mark := strings.TrimSpace(prefix)
+	if mark == "" || strings.TrimSpace(title) == "" || strings.HasPrefix(strings.TrimSpace(title), mark) {
+		return title
+	}
+	return prefix + title
+}
@@ -0,0 +1,269 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveBuildStatusTruthTable(t *testing.T) {
+	// Every combination of the envs, "" is an unset env.
+	tests := []struct {
+		bitrise, steplib string
+		wantSuccess      bool
+		wantSource       string
+	}{
+		{"", "", true, "no build status env is set"},
+		{"", "0", true, "$STEPLIB_BUILD_STATUS=0"},
+		{"", "1", false, "$STEPLIB_BUILD_STATUS=1"},
+		{"0", "", true, "$BITRISE_BUILD_STATUS=0"},
+		{"0", "0", true, "$BITRISE_BUILD_STATUS=0"},
+		{"0", "1", false, "$STEPLIB_BUILD_STATUS=1"},
+		{"1", "", false, "$BITRISE_BUILD_STATUS=1"},
+		{"1", "0", false, "$BITRISE_BUILD_STATUS=1"},
+		{"1", "1", false, "$BITRISE_BUILD_STATUS=1"},
+		{" 0\n", "", true, "$BITRISE_BUILD_STATUS=0"},
+		{"2", "0", false, "$BITRISE_BUILD_STATUS=2"},
+		{" ", "1", false, "$STEPLIB_BUILD_STATUS=1"},
+	}
+	for _, tt := range tests {
+		getenv := func(key string) string {
+			return map[string]string{"BITRISE_BUILD_STATUS": tt.bitrise, "STEPLIB_BUILD_STATUS": tt.steplib}[key]
+		}
+		for _, input := range []string{"auto", ""} {
+			success, source := resolveBuildStatus(input, getenv)
+			if success != tt.wantSuccess || source != tt.wantSource {
+				t.Errorf("resolveBuildStatus(%q) with BITRISE_BUILD_STATUS=%q, STEPLIB_BUILD_STATUS=%q = %v, %q, want %v, %q",
+					input, tt.bitrise, tt.steplib, success, source, tt.wantSuccess, tt.wantSource)
+			}
+		}
+		// The input overrides the envs.
+		for input, want := range map[string]bool{"success": true, "failed": false} {
+			success, source := resolveBuildStatus(input, getenv)
+			if success != want || source != "build_status input" {
+				t.Errorf("resolveBuildStatus(%q) with BITRISE_BUILD_STATUS=%q, STEPLIB_BUILD_STATUS=%q = %v, %q, want %v, %q",
+					input, tt.bitrise, tt.steplib, success, source, want, "build_status input")
+			}
+		}
+	}
+}
+
+func TestResolveLayout(t *testing.T) {
+	tests := []struct {
+		forceSuccess, forceError, buildSuccess bool
+		want, wantErr                          bool
+	}{
+		{false, false, true, true, false},
+		{false, false, false, false, false},
+		{true, false, false, true, false},
+		{true, false, true, true, false},
+		{false, true, true, false, false},
+		{false, true, false, false, false},
+		{true, true, true, false, true},
+		{true, true, false, false, true},
+	}
+	for _, tt := range tests {
+		got, err := resolveLayout(tt.forceSuccess, tt.forceError, tt.buildSuccess)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("resolveLayout(%v, %v, %v) = %v, %v, want %v, error %v",
+				tt.forceSuccess, tt.forceError, tt.buildSuccess, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestLayoutPrecedence(t *testing.T) {
+	// The explicit force wins over the build_status input, which wins over the envs.
+	tests := []struct {
+		name                     string
+		forceSuccess, forceError bool
+		input, env               string
+		wantLayout, wantStatus   bool
+	}{
+		{"envs", false, false, "auto", "1", false, false},
+		{"input over envs", false, false, "success", "1", true, true},
+		{"force success over input", true, false, "failed", "0", true, false},
+		{"force success over envs", true, false, "auto", "1", true, false},
+		{"force error over input", false, true, "success", "0", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, _ := resolveBuildStatus(tt.input, func(string) string { return tt.env })
+			layout, err := resolveLayout(tt.forceSuccess, tt.forceError, status)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if layout != tt.wantLayout || status != tt.wantStatus {
+				t.Errorf("layout, status = %v, %v, want %v, %v", layout, status, tt.wantLayout, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestForcedLayoutMessage(t *testing.T) {
+	conf := Config{
+		Title:               "Deploy finished",
+		TitleOnError:        "Build Failed!",
+		ThemeColor:          "00ff00",
+		ThemeColorOnError:   "ff0000",
+		Buttons:             "Release|https://example.com/release",
+		ButtonsOnError:      "Logs|https://example.com/logs",
+		Subject:             "Deployed 1.2.0",
+		ShowBuildStatusFact: true,
+	}
+	tests := []struct {
+		name           string
+		layout, status bool
+		wantTitle      string
+		wantColor      string
+		wantButton     string
+		wantStatusFact string
+	}{
+		{"success", true, true, "Deploy finished", "00ff00", "Release", "Succeeded"},
+		{"forced success of a failed build", true, false, "Deploy finished", "00ff00", "Release", "Failed"},
+		{"forced error of a successful build", false, true, "Build Failed!", "ff0000", "Logs", "Succeeded"},
+		{"failure", false, false, "Build Failed!", "ff0000", "Logs", "Failed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			savedLayout, savedSuccess := layoutSuccess, success
+			layoutSuccess, success = tt.layout, tt.status
+			t.Cleanup(func() { layoutSuccess, success = savedLayout, savedSuccess })
+
+			msg, errs := newMessage(conf)
+			if len(errs) > 0 {
+				t.Fatal(errs)
+			}
+			s := msg.Sections[0]
+			if msg.Title != tt.wantTitle || msg.ThemeColor != tt.wantColor || len(s.Actions) != 1 || s.Actions[0].Name != tt.wantButton {
+				t.Errorf("title %q, color %q, buttons %+v, want %q, %q, %q", msg.Title, msg.ThemeColor, s.Actions, tt.wantTitle, tt.wantColor, tt.wantButton)
+			}
+			want := []Fact{{Name: "Build status", Value: tt.wantStatusFact}}
+			if !reflect.DeepEqual(s.Facts, want) {
+				t.Errorf("facts %+v, want %+v", s.Facts, want)
+			}
+		})
+	}
+}
+
+func TestWithTitlePrefix(t *testing.T) {
+	tests := []struct {
+		title, prefix, want string
+	}{
+		{"Build Succeeded!", "✅ ", "✅ Build Succeeded!"},
+		{"✅ Build Succeeded!", "✅ ", "✅ Build Succeeded!"},
+		{"✅Build Succeeded!", "✅ ", "✅Build Succeeded!"},
+		{"  ✅ Build Succeeded!", "✅ ", "  ✅ Build Succeeded!"},
+		{"✅ Build Failed!", "❌ ", "❌ ✅ Build Failed!"},
+		{"Build Succeeded!", "", "Build Succeeded!"},
+		{"Build Succeeded!", "  ", "Build Succeeded!"},
+		{"", "✅ ", ""},
+		{" \n", "✅ ", " \n"},
+	}
+	for _, tt := range tests {
+		if got := withTitlePrefix(tt.title, tt.prefix); got != tt.want {
+			t.Errorf("withTitlePrefix(%q, %q) = %q, want %q", tt.title, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestNewMessageTitlePrefix(t *testing.T) {
+	prefixed := Config{
+		Title:                "Build Succeeded!",
+		TitleOnError:         "Build Failed!",
+		EnableTitlePrefix:    true,
+		TitlePrefixOnSuccess: "✅ ",
+		TitlePrefixOnError:   "❌ ",
+	}
+	disabled := prefixed
+	disabled.EnableTitlePrefix = false
+	tests := []struct {
+		name   string
+		conf   Config
+		status bool
+		want   string
+	}{
+		{"success", prefixed, true, "✅ Build Succeeded!"},
+		{"failure", prefixed, false, "❌ Build Failed!"},
+		{"disabled", disabled, true, "Build Succeeded!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			savedLayout, savedSuccess := layoutSuccess, success
+			layoutSuccess, success = tt.status, tt.status
+			t.Cleanup(func() { layoutSuccess, success = savedLayout, savedSuccess })
+
+			msg, _ := newMessage(tt.conf)
+			if msg.Title != tt.want || msg.Summary != tt.want {
+				t.Errorf("title, summary = %q, %q, want %q", msg.Title, msg.Summary, tt.want)
+			}
+		})
+	}
+}
+
+func TestTitlePrefixSummaryTruncation(t *testing.T) {
+	const family = "\U0001F468\u200D\U0001F469\u200D\U0001F467"
+	// 99 characters fit in the summary, the prefix makes them 101.
+	title := strings.Repeat("a", 94) + family
+	conf := Config{Title: title, TitlePrefixOnSuccess: "✅ "}
+
+	if msg, _ := newMessage(conf); msg.Summary != title {
+		t.Errorf("summary without a prefix = %q, want the title", msg.Summary)
+	}
+	conf.EnableTitlePrefix = true
+	msg, _ := newMessage(conf)
+	if want := "✅ " + strings.Repeat("a", 94) + "…"; msg.Summary != want {
+		t.Errorf("summary = %q, want %q without a split emoji", msg.Summary, want)
+	}
+	if msg.Title != "✅ "+title {
+		t.Errorf("title = %q, want the prefixed title", msg.Title)
+	}
+
+	conf.Title = strings.Repeat("a", 93) + family
+	if msg, _ := newMessage(conf); msg.Summary != "✅ "+conf.Title {
+		t.Errorf("summary = %q, want the prefixed title filling the summary", msg.Summary)
+	}
+}
+
+func TestTitlePrefixSurvivesSanitizing(t *testing.T) {
+	captureLog(t)
+	srv := webhookReplies(http.StatusOK)
+	defer srv.Close()
+	p := &sendPipeline{
+		conf:   Config{Title: "Build Succeeded!", EnableTitlePrefix: true, TitlePrefixOnSuccess: "✅ "},
+		report: &RunReport{},
+		urls:   []string{srv.URL},
+	}
+	if code := p.run(); code != 0 {
+		t.Fatalf("run() = %d, want 0", code)
+	}
+	if p.msg.Title != "✅ Build Succeeded!" {
+		t.Errorf("sent title = %q, want the prefix kept", p.msg.Title)
+	}
+}
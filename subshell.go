@@ -0,0 +1,194 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// findSubshell returns the start and the end offset of the first $(...) command substitution
+// in s, or -1. Parentheses inside quotes do not count.
+func findSubshell(s string) (int, int) {
+	start := strings.Index(s, "$(")
+	if start < 0 {
+		return -1, -1
+	}
+	depth := 0
+	var quote byte
+	for i := start + 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '\\':
+			i++
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth--; depth == 0 {
+				return start, i + 1
+			}
+		}
+	}
+	return -1, -1
+}
+
+// runShellCommand runs the command with sh, so quotes, pipes, redirections and env
+// expansions work as in a script, and returns its output without the trailing newlines.
+// The command is killed after the timeout, 0 disables it.
+func runShellCommand(command string, timeout time.Duration) (string, error) {
+	return execCommand(timeout, "sh", "-c", command)
+}
+
+// execCommand runs the binary with the args and returns its output without the trailing
+// newlines. The command is killed after the timeout, 0 disables it. The errors describe the
+// command by the script of sh, or by the binary and its args.
+func execCommand(timeout time.Duration, name string, args ...string) (string, error) {
+	command := strings.Join(append([]string{name}, args...), " ")
+	if name == "sh" && len(args) == 2 && args[0] == "-c" {
+		command = args[1]
+	}
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	// The children of sh may keep the output open after sh was killed.
+	cmd.WaitDelay = time.Second
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("command `%s` timed out after %s", command, timeout)
+	}
+	if err != nil && stderr.Len() > 0 {
+		return "", fmt.Errorf("command `%s` failed: %w, output: %s", command, err, strings.TrimSpace(stderr.String()))
+	} else if err != nil {
+		return "", fmt.Errorf("command `%s` failed: %w", command, err)
+	}
+	return strings.TrimRight(string(out), "\n"), nil
+}
+
+// cachedShellCommands returns a runner which runs every distinct command once with the
+// timeout and returns the cached output of the commands which already ran.
+func cachedShellCommands(run func(string, time.Duration) (string, error), timeout time.Duration) func(string) (string, error) {
+	type result struct {
+		out string
+		err error
+	}
+	cache := map[string]result{}
+	return func(command string) (string, error) {
+		if r, ok := cache[command]; ok {
+			logger.Debugf("Command substitution cached: %s\n", command)
+			return r.out, r.err
+		}
+		logger.Debugf("Command substitution run: %s\n", command)
+		out, err := run(command, timeout)
+		cache[command] = result{out, err}
+		return out, err
+	}
+}
+
+// ignoreShellErrors returns a runner which substitutes an empty output for the commands which
+// failed, logging a warning if warn is set.
+func ignoreShellErrors(run func(string) (string, error), warn bool) func(string) (string, error) {
+	return func(command string) (string, error) {
+		out, err := run(command)
+		if err != nil && warn {
+			logger.Warnf("%s, its output is left empty", err)
+		} else if err != nil {
+			logger.Debugf("%s, its output is left empty\n", err)
+		}
+		if err != nil {
+			return "", nil
+		}
+		return out, nil
+	}
+}
+
+// resolveSubshellCommands replaces the $(...) command substitutions in s with the output of
+// their command, which is passed to the shell untouched.
+func resolveSubshellCommands(s string, run func(string) (string, error)) (string, error) {
+	var b strings.Builder
+	for {
+		start, end := findSubshell(s)
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		out, err := run(s[start+2 : end-1])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[:start])
+		b.WriteString(out)
+		s = s[end:]
+	}
+}
+
+// applySubshells resolves the command substitutions in the inputs listed in subshell_inputs.
+// An input containing the value of an env from the commit with a substitution is left as is,
+// so a commit message can't run commands. It runs before the late-bound env references are
+// resolved, so the values of the envs are never executed.
+func applySubshells(c *Config, run func(string) (string, error), getenv func(string) string) error {
+	inputs, err := optedInInputs(c, "subshell_inputs", parseInputList(c.SubshellInputs))
+	if err != nil {
+		return err
+	}
+	for _, key := range parseInputList(c.SubshellInputs) {
+		f := inputs[key]
+		if !strings.Contains(f.String(), "$(") {
+			continue
+		}
+		if env := untrustedSource(f.String(), "$(", getenv); env != "" {
+			logger.Warnf("Input %s contains $%s, which has a command substitution, its commands are not run.", key, env)
+			continue
+		}
+		if unavailable["sh"] {
+			return errors.New("sh is required by the command substitutions in the inputs")
+		}
+		resolved, err := resolveSubshellCommands(f.String(), run)
+		if err != nil {
+			return fmt.Errorf("input %s: %s", key, err)
+		}
+		f.SetString(resolved)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplySubshells(t *testing.T) {
+	echo := func(command string) (string, error) { return "<" + command + ">", nil }
+	noEnv := func(string) string { return "" }
+
+	tests := []struct {
+		name    string
+		conf    Config
+		getenv  func(string) string
+		want    Config
+		wantErr string
+	}{
+		{
+			name: "only the listed inputs are resolved",
+			conf: Config{SubshellInputs: "title", Title: "a $(x)", TitleOnError: "b $(y)"},
+			want: Config{SubshellInputs: "title", Title: "a <x>", TitleOnError: "b $(y)"},
+		},
+		{
+			name: "the subject is never resolved",
+			conf: Config{Subject: "Fix $(id -un > /tmp/pwned)"},
+			want: Config{Subject: "Fix $(id -un > /tmp/pwned)"},
+		},
+		{
+			name:    "the subject can't be listed",
+			conf:    Config{SubshellInputs: "title, subject"},
+			wantErr: "subject comes from the commit",
+		},
+		{
+			name:    "a secret can't be listed",
+			conf:    Config{SubshellInputs: "webhook_url"},
+			wantErr: "webhook_url is a secret",
+		},
+		{
+			name:    "an unknown input is an error",
+			conf:    Config{SubshellInputs: "titel"},
+			wantErr: "titel is not a text input",
+		},
+		{
+			name: "an expanded branch name is not run",
+			conf: Config{SubshellInputs: "fields", Fields: "Branch|x$(id)"},
+			getenv: func(key string) string {
+				if key == "BITRISE_GIT_BRANCH" {
+					return "x$(id)"
+				}
+				return ""
+			},
+			want: Config{SubshellInputs: "fields", Fields: "Branch|x$(id)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := tt.getenv
+			if getenv == nil {
+				getenv = noEnv
+			}
+			conf := tt.conf
+			err := applySubshells(&conf, echo, getenv)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("applySubshells() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applySubshells() error = %v", err)
+			}
+			if conf != tt.want {
+				t.Errorf("applySubshells() = %+v, want %+v", conf, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSubshell(t *testing.T) {
+	tests := []struct {
+		in         string
+		start, end int
+	}{
+		{"no command", -1, -1},
+		{"a $(b) c", 2, 6},
+		{"a $(echo ')') c", 2, 13},
+		{"a $(echo $(b)) c", 2, 14},
+		{"unclosed $(b", -1, -1},
+	}
+	for _, tt := range tests {
+		if start, end := findSubshell(tt.in); start != tt.start || end != tt.end {
+			t.Errorf("findSubshell(%q) = %d, %d, want %d, %d", tt.in, start, end, tt.start, tt.end)
+		}
+	}
+}
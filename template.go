@@ -0,0 +1,115 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// templateData is the data of the templates of the inputs.
+type templateData struct {
+	Env     map[string]string
+	Success bool
+}
+
+// newTemplateData returns the envs of environ and the build status.
+func newTemplateData(environ []string, success bool) templateData {
+	data := templateData{Env: map[string]string{}, Success: success}
+	for _, kv := range environ {
+		if a := strings.SplitN(kv, "=", 2); len(a) == 2 {
+			data.Env[a[0]] = a[1]
+		}
+	}
+	return data
+}
+
+// templateFuncs are the helpers of the templates, eg. {{.Env.BITRISE_GIT_BRANCH | default "main"}}.
+var templateFuncs = template.FuncMap{
+	"trunc": func(n int, s string) string { return truncateText(s, n) },
+	"upper": strings.ToUpper,
+	"default": func(d, s string) string {
+		if s == "" {
+			return d
+		}
+		return s
+	},
+}
+
+// renderTemplate renders s as a text/template. The late-bound env references are kept as is.
+func renderTemplate(name, s string, data templateData) (string, error) {
+	src := lateEnvPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		return "{{" + strconv.Quote(ref) + "}}"
+	})
+	t, err := template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(src)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// applyTemplates renders the templates of the text inputs. An invalid template fails if strict is
+// set, otherwise the input is used as is. subject and author_name come from the commit and an
+// input containing the value of a commit env with a template is left as is, so a commit message
+// can't read the envs of the build.
+func applyTemplates(c *Config, data templateData, strict bool, getenv func(string) string) error {
+	for _, in := range []struct {
+		Name  string
+		Value *string
+	}{
+		{"title", &c.Title},
+		{"title_on_error", &c.TitleOnError},
+		{"summary", &c.Summary},
+		{"fields", &c.Fields},
+		{"fields_on_error", &c.FieldsOnError},
+		{"buttons", &c.Buttons},
+		{"buttons_on_error", &c.ButtonsOnError},
+	} {
+		if !strings.Contains(*in.Value, "{{") {
+			continue
+		}
+		if env := untrustedSource(*in.Value, "{{", getenv); env != "" {
+			logger.Warnf("Input %s contains $%s, which has a template, it is used as is.", in.Name, env)
+			continue
+		}
+		s, err := renderTemplate(in.Name, *in.Value, data)
+		if err != nil && strict {
+			return fmt.Errorf("input %s: %s", in.Name, err)
+		} else if err != nil {
+			logger.Warnf("Input %s is used as is, its template is invalid: %s", in.Name, err)
+			continue
+		}
+		*in.Value = s
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"testing"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	data := templateData{Env: map[string]string{"BITRISE_GIT_BRANCH": "feature/login"}, Success: true}
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"env", "Branch {{.Env.BITRISE_GIT_BRANCH}}", "Branch feature/login"},
+		{"missing env", "Tag {{.Env.BITRISE_GIT_TAG}}", "Tag "},
+		{"status", "{{if .Success}}passed{{else}}failed{{end}}", "passed"},
+		{"upper", "{{upper .Env.BITRISE_GIT_BRANCH}}", "FEATURE/LOGIN"},
+		{"default", `{{.Env.BITRISE_GIT_TAG | default "none"}}`, "none"},
+		{"default not used", `{{.Env.BITRISE_GIT_BRANCH | default "none"}}`, "feature/login"},
+		{"trunc", "{{trunc 8 .Env.BITRISE_GIT_BRANCH}}", "feature…"},
+		{"trunc longer than the value", "{{trunc 20 .Env.BITRISE_GIT_BRANCH}}", "feature/login"},
+		{"trunc zero", "[{{trunc 0 .Env.BITRISE_GIT_BRANCH}}]", "[]"},
+		{"trunc negative", "[{{trunc -3 .Env.BITRISE_GIT_BRANCH}}]", "[]"},
+		{"late env kept", "{{env:VERSION|dev}} on {{.Env.BITRISE_GIT_BRANCH}}", "{{env:VERSION|dev}} on feature/login"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderTemplate("title", tt.in, data)
+			if err != nil {
+				t.Fatalf("renderTemplate(%q) error = %v", tt.in, err)
+			}
+			if got != tt.want {
+				t.Errorf("renderTemplate(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncTemplateShortLimitsInFence(t *testing.T) {
+	data := templateData{Env: map[string]string{"LOG": "```\nfailure\n```"}}
+	for _, in := range []string{"{{trunc 0 .Env.LOG}}", "{{trunc 3 .Env.LOG}}", "{{trunc 6 .Env.LOG}}"} {
+		if _, err := renderTemplate("summary", in, data); err != nil {
+			t.Errorf("renderTemplate(%q) error = %v", in, err)
+		}
+	}
+}
+
+func TestApplyTemplates(t *testing.T) {
+	data := templateData{Env: map[string]string{"VERSION": "1.2"}}
+	tests := []struct {
+		name    string
+		conf    Config
+		strict  bool
+		want    Config
+		wantErr bool
+	}{
+		{
+			name: "text inputs",
+			conf: Config{Title: "v{{.Env.VERSION}}", Buttons: "Build {{.Env.VERSION}}|https://example.com"},
+			want: Config{Title: "v1.2", Buttons: "Build 1.2|https://example.com"},
+		},
+		{
+			name: "the commit inputs are not rendered",
+			conf: Config{Subject: "{{.Env.VERSION}}", AuthorName: "{{.Env.VERSION}}"},
+			want: Config{Subject: "{{.Env.VERSION}}", AuthorName: "{{.Env.VERSION}}"},
+		},
+		{
+			name: "invalid template used as is",
+			conf: Config{Title: "v{{.Env.VERSION"},
+			want: Config{Title: "v{{.Env.VERSION"},
+		},
+		{
+			name:    "invalid template fails if strict",
+			conf:    Config{Title: "v{{.Env.VERSION"},
+			strict:  true,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			conf := tt.conf
+			err := applyTemplates(&conf, data, tt.strict, func(string) string { return "" })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyTemplates() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && conf != tt.want {
+				t.Errorf("applyTemplates() = %+v, want %+v", conf, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyTemplatesSkipsExpandedCommitEnvs(t *testing.T) {
+	captureLog(t)
+	getenv := func(key string) string {
+		if key == "BITRISE_GIT_MESSAGE" {
+			return "{{.Env.SECRET_TOKEN}}"
+		}
+		return ""
+	}
+	data := templateData{Env: map[string]string{"SECRET_TOKEN": "s3cr3t"}}
+	conf := Config{Summary: "Message: {{.Env.SECRET_TOKEN}}"}
+	if err := applyTemplates(&conf, data, true, getenv); err != nil {
+		t.Fatalf("applyTemplates() error = %v", err)
+	}
+	if want := "Message: {{.Env.SECRET_TOKEN}}"; conf.Summary != want {
+		t.Errorf("summary = %q, want %q", conf.Summary, want)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// troubleshootingHeaders are the credentials left out of the dumped response headers.
+var troubleshootingHeaders = map[string]bool{"Authorization": true, "Proxy-Authorization": true, "Set-Cookie": true, "Cookie": true}
+
+// dumpAttempt writes the payload of an attempt to request-<attempt>.json and its response, or
+// its error, to response-<attempt>.txt in the dir. The files are kept as build artifacts, so only
+// their owner can read them and the credentials are redacted: the webhook URL, the signing
+// secret, the values of the request headers and the signature header of the response.
+// Failing to write them is only a warning.
+func dumpAttempt(dir, attempt string, s *Sender, payload []byte, resp *http.Response, body string, err error) {
+	secrets := []string{s.URL, redactedHost(s.URL)}
+	if s.SigningSecret != "" {
+		secrets = append(secrets, s.SigningSecret, "*****")
+	}
+	for _, vs := range s.Header {
+		for _, v := range vs {
+			// Shorter values can't be credentials, replacing them would garble the files.
+			if len(v) >= 8 {
+				secrets = append(secrets, v, "*****")
+			}
+		}
+	}
+	redact := strings.NewReplacer(secrets...)
+	var b strings.Builder
+	if resp != nil {
+		fmt.Fprintf(&b, "%s %s\n", resp.Proto, resp.Status)
+		var names []string
+		for name := range resp.Header {
+			if !troubleshootingHeaders[name] && !strings.EqualFold(name, s.SignatureHeader) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, v := range resp.Header[name] {
+				fmt.Fprintf(&b, "%s: %s\n", name, v)
+			}
+		}
+		fmt.Fprintf(&b, "\n%s\n", body)
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "error: %s\n", err)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		logger.Warnf("Failed to create the troubleshooting dir: %s", err)
+		return
+	}
+	for name, content := range map[string]string{
+		"request-" + attempt + ".json": string(payload),
+		"response-" + attempt + ".txt": b.String(),
+	} {
+		if err := writeFileAtomic(filepath.Join(dir, name), []byte(redact.Replace(content)), 0600); err != nil {
+			logger.Warnf("Failed to write %s to the troubleshooting dir: %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpAttemptRedactsCredentials(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "teams-step")
+	const url = "https://example.webhook.office.com/webhookb2/secret-path"
+	s := &Sender{
+		URL:             url,
+		Header:          http.Header{"Authorization": {"Bearer token-1234567"}, "X-Team": {"ios"}},
+		SigningSecret:   "signing-secret",
+		SignatureHeader: "X-Signature",
+	}
+	resp := &http.Response{
+		Proto:  "HTTP/1.1",
+		Status: "400 Bad Request",
+		Header: http.Header{"X-Signature": {"abcdef"}, "Set-Cookie": {"session=1"}, "X-Request-Id": {"42"}},
+	}
+	body := "rejected " + url + " with Bearer token-1234567 and signing-secret"
+	dumpAttempt(dir, "2-1", s, []byte(`{"title":"x"}`), resp, body, errors.New("posting to "+url+" failed"))
+
+	for _, name := range []string{"request-2-1.json", "response-2-1.txt"} {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("%s permissions = %o, want 600", name, perm)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"secret-path", "token-1234567", "signing-secret", "X-Signature", "Set-Cookie"} {
+			if strings.Contains(string(b), secret) {
+				t.Errorf("%s contains %q:\n%s", name, secret, b)
+			}
+		}
+	}
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "response-2-1.txt"))
+	if !strings.Contains(string(b), "X-Request-Id: 42") || !strings.Contains(string(b), "https://example.webhook.office.com") {
+		t.Errorf("response dump lost the non-secret details:\n%s", b)
+	}
+}
@@ -0,0 +1,102 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// isWebURL reports whether s is an absolute http or https URL.
+func isWebURL(s string) bool {
+	u, err := url.Parse(strings.TrimSpace(s))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// checkPairs returns the problems of the lines of a pipe separated input, which are omitted
+// from the message. If urls is set the values must be http or https URLs.
+func checkPairs(input, s string, urls bool) []error {
+	var errs []error
+	for i, line := range strings.Split(s, "\n") {
+		line = trimBlank(line)
+		if line == "" || (urls && isHTTPPOSTButton(line)) {
+			// The HttpPOST buttons are checked by parsesActions.
+			continue
+		}
+		a := strings.SplitN(line, "|", 2)
+		if len(a) == 2 {
+			a[0], a[1] = trimBlank(a[0]), trimBlank(a[1])
+		}
+		switch {
+		case len(a) != 2:
+			errs = append(errs, fmt.Errorf("%s line %d has no | separator, it is omitted: %s", input, i+1, line))
+		case a[0] == "":
+			errs = append(errs, fmt.Errorf("%s line %d has no title, it is omitted: %s", input, i+1, line))
+		case a[1] == "":
+			errs = append(errs, fmt.Errorf("%s line %d has no value, it is omitted: %s", input, i+1, line))
+		case urls && !isWebURL(a[1]):
+			errs = append(errs, fmt.Errorf("%s line %d is not an http(s) URL: %s", input, i+1, a[1]))
+		}
+	}
+	return errs
+}
+
+// checkImages returns the problems of the lines of the images input, which are not reported
+// by checkPairs as an image line may have no title.
+func checkImages(input, s string) []error {
+	var errs []error
+	for i, line := range strings.Split(s, "\n") {
+		if line = trimBlank(line); line == "" {
+			continue
+		}
+		img, ok := parseImageRow(line)
+		switch {
+		case !ok:
+			errs = append(errs, fmt.Errorf("%s line %d has no image URL, it is omitted: %s", input, i+1, line))
+		case !isWebURL(img.URL):
+			errs = append(errs, fmt.Errorf("%s line %d is not an http(s) URL: %s", input, i+1, img.URL))
+		case img.Link != "" && !isWebURL(img.Link):
+			errs = append(errs, fmt.Errorf("%s line %d links to a URL which is not http(s): %s", input, i+1, img.Link))
+		}
+	}
+	return errs
+}
+
+// checkStages returns the problem of the stages input if it looks like JSON but can't be parsed.
+func checkStages(s string) []error {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "[") {
+		return checkPairs("stages", s, false)
+	}
+	var ss []Stage
+	if err := json.Unmarshal([]byte(s), &ss); err != nil {
+		return []error{fmt.Errorf("stages is not a valid JSON list, it is parsed as lines: %s", err)}
+	}
+	return nil
+}
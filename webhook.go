@@ -0,0 +1,215 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholderPattern matches the {name} placeholders of a webhook URL.
+var placeholderPattern = regexp.MustCompile(`\{([A-Za-z0-9_-]+)\}`)
+
+// parseURLParams parses key=value lines, empty lines are omitted.
+func parseURLParams(s string) (map[string]string, error) {
+	params := map[string]string{}
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		a := strings.SplitN(line, "=", 2)
+		if len(a) != 2 || strings.TrimSpace(a[0]) == "" {
+			return nil, fmt.Errorf("invalid webhook URL parameter in line %d, expected key=value", i+1)
+		}
+		params[strings.TrimSpace(a[0])] = strings.TrimSpace(a[1])
+	}
+	return params, nil
+}
+
+// headerNamePattern matches the valid names of the HTTP headers.
+var headerNamePattern = regexp.MustCompile("^[!#$%&'*+.^_`|~0-9A-Za-z-]+$")
+
+// parseRequestHeaders parses the Header-Name: value lines of the request_headers input, empty
+// lines are omitted. The errors never contain the values, which are often credentials.
+func parseRequestHeaders(s string) (http.Header, error) {
+	header := http.Header{}
+	var malformed []string
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		a := strings.SplitN(line, ":", 2)
+		if len(a) != 2 || !headerNamePattern.MatchString(strings.TrimSpace(a[0])) {
+			malformed = append(malformed, fmt.Sprint(i+1))
+			continue
+		}
+		name := http.CanonicalHeaderKey(strings.TrimSpace(a[0]))
+		if name == "Content-Type" {
+			return nil, fmt.Errorf("request_headers line %d sets Content-Type, which is set by the step", i+1)
+		}
+		header.Add(name, strings.TrimSpace(a[1]))
+	}
+	if len(malformed) > 0 {
+		return nil, fmt.Errorf("malformed request_headers lines %s, expected Header-Name: value", strings.Join(malformed, ", "))
+	}
+	return header, nil
+}
+
+// escapeURLParam encodes a value so it is safe both in the path and in the query of a URL.
+func escapeURLParam(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// substituteURLParams fills the {name} placeholders of rawURL with the given parameters.
+// Placeholders without a parameter and parameters without a placeholder are errors.
+func substituteURLParams(rawURL, params string) (string, error) {
+	ps, err := parseURLParams(params)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []string
+	used := map[string]bool{}
+	result := placeholderPattern.ReplaceAllStringFunc(rawURL, func(m string) string {
+		name := m[1 : len(m)-1]
+		value, ok := ps[name]
+		if !ok {
+			missing = append(missing, name)
+			return m
+		}
+		used[name] = true
+		return escapeURLParam(value)
+	})
+
+	var unused []string
+	for name := range ps {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+
+	switch {
+	case len(missing) > 0:
+		return "", fmt.Errorf("webhook URL placeholders without a value in webhook_url_params: %s", strings.Join(missing, ", "))
+	case len(unused) > 0:
+		return "", fmt.Errorf("webhook_url_params not used in the webhook URL: %s", strings.Join(unused, ", "))
+	}
+	return result, nil
+}
+
+// normalizeWebhookURL removes the surrounding whitespace and a single pair of wrapping quotes
+// which are often pasted together with the URL. It returns whether the URL was changed.
+func normalizeWebhookURL(s string) (string, bool) {
+	n := strings.TrimSpace(s)
+	if len(n) >= 2 && (n[0] == '"' || n[0] == '\'') && n[len(n)-1] == n[0] {
+		n = strings.TrimSpace(n[1 : len(n)-1])
+	}
+	return n, n != s
+}
+
+// webhookHostSuffixes are the hosts of the Incoming Webhook connectors and of the Workflows.
+var webhookHostSuffixes = []string{".webhook.office.com", ".outlook.office.com", ".logic.azure.com", ".powerplatform.com"}
+
+// webhookFormatsURL documents the expected formats of the webhook URLs.
+const webhookFormatsURL = "https://learn.microsoft.com/microsoftteams/platform/webhooks-and-connectors/how-to/add-incoming-webhook"
+
+// checkWebhookURL rejects the URLs whose shape shows they are not webhook endpoints. Unless
+// anyHost is set, the URL must be an https URL of a Teams webhook or Workflows host.
+func checkWebhookURL(rawURL string, anyHost bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL: %s", redactURLError(err))
+	}
+	host := strings.ToLower(u.Hostname())
+	path := strings.ToLower(u.Path)
+	if (host == "outlook.office.com" || strings.HasSuffix(host, ".office.com")) &&
+		(strings.HasPrefix(path, "/connector/") || strings.HasPrefix(path, "/connectors/")) {
+		return fmt.Errorf("the webhook URL points to the connector configuration page of %s, "+
+			"copy the URL shown after creating the Incoming Webhook connector instead", host)
+	}
+	if anyHost {
+		return nil
+	}
+	switch {
+	case host == "hooks.slack.com":
+		return fmt.Errorf("the webhook URL is a Slack webhook (%s), create an Incoming Webhook or a Workflow in Teams, see %s", host, webhookFormatsURL)
+	case host == "teams.microsoft.com":
+		return fmt.Errorf("the webhook URL is the link of a Teams channel (%s), not of its webhook, see %s", host, webhookFormatsURL)
+	case u.Scheme != "https":
+		return fmt.Errorf("the webhook URL of %s is not an https URL, see %s, or set allow_any_webhook_host", orDash(host), webhookFormatsURL)
+	}
+	for _, suffix := range webhookHostSuffixes {
+		if host == strings.TrimPrefix(suffix, ".") || strings.HasSuffix(host, suffix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the webhook URL host %s is not a Teams webhook (*.webhook.office.com) or Workflows (*.logic.azure.com) host, "+
+		"see %s, or set allow_any_webhook_host if it is expected", host, webhookFormatsURL)
+}
+
+// splitWebhookURLs splits a list of webhook URLs separated by newlines or pipes.
+func splitWebhookURLs(s string) []string {
+	var urls []string
+	for _, u := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == '|' }) {
+		if strings.TrimSpace(u) != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// resolveWebhookURLs normalizes the listed webhook URLs, substitutes their placeholders and
+// checks them. The webhook_url_params must be used by at least one of the URLs.
+func resolveWebhookURLs(list, params string, anyHost bool) ([]string, error) {
+	urls := splitWebhookURLs(list)
+	for i, u := range urls {
+		// The whitespace around the separators is expected, only the quotes are worth a warning.
+		n, _ := normalizeWebhookURL(u)
+		if strings.TrimSpace(u) != n {
+			logger.Warnf("Surrounding quotes removed from the webhook URL.")
+		}
+		urls[i] = n
+	}
+
+	substituted, err := substituteURLParams(strings.Join(urls, "\n"), params)
+	if err != nil {
+		return nil, err
+	}
+	urls = splitWebhookURLs(substituted)
+	for _, u := range urls {
+		if err := checkWebhookURL(u, anyHost); err != nil {
+			return nil, err
+		}
+	}
+	return urls, nil
+}
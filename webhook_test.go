@@ -0,0 +1,365 @@
+/*
+This file is:
+
+The MIT License (MIT)
+
+Copyright (c) 2014 Bitrise
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// captureLog replaces the logger with a JSON logger writing to the returned buffer until the
+// test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var b bytes.Buffer
+	saved := logger
+	logger = jsonLogger{w: &b}
+	t.Cleanup(func() { logger = saved })
+	return &b
+}
+
+func TestPostMessageClassification(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		header     map[string]string
+		body       string
+		key        string
+		wantErr    bool
+		wantRetry  bool
+		wantStatus int
+	}{
+		{"accepted", http.StatusOK, nil, "1", "", false, false, http.StatusOK},
+		{"workflow accepted", http.StatusAccepted, nil, "", "", false, false, http.StatusAccepted},
+		{"bad request", http.StatusBadRequest, nil, "Bad payload received by generic incoming webhook.", "key", true, false, http.StatusBadRequest},
+		{"throttled", http.StatusTooManyRequests, map[string]string{"Retry-After": "2"}, "Too many requests", "", true, true, http.StatusTooManyRequests},
+		{"server error", http.StatusInternalServerError, nil, "oops", "", true, false, http.StatusInternalServerError},
+		{"server error with a key", http.StatusInternalServerError, nil, "oops", "key", true, true, http.StatusInternalServerError},
+		{"failed delivery", http.StatusOK, nil, "Webhook message delivery failed with error: 502", "", true, true, http.StatusOK},
+		{"failed endpoint", http.StatusOK, nil, "Microsoft Teams endpoint returned HTTP error 429 with ContextId tcid=0", "", true, true, http.StatusOK},
+		{"created", http.StatusCreated, nil, `{"id":"1"}`, "", false, false, http.StatusCreated},
+		{"no content", http.StatusNoContent, nil, "", "", false, false, http.StatusNoContent},
+		{"workflow accepted with a message", http.StatusAccepted, nil, "Webhook message delivery failed", "", false, false, http.StatusAccepted},
+		{"web page", http.StatusOK, map[string]string{"Content-Type": "text/html"}, "<html></html>", "", true, false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureLog(t)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.header {
+					w.Header().Set(k, v)
+				}
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			s := &Sender{Client: srv.Client(), URL: srv.URL}
+			var report RunReport
+			err := s.postMessage(context.Background(), Config{IdempotencyKeyHeader: "Idempotency-Key"}, Message{Title: "t"}, tt.key, &report)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("postMessage() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && isTransient(err) != tt.wantRetry {
+				t.Errorf("isTransient(%v) = %v, want %v", err, isTransient(err), tt.wantRetry)
+			}
+			if report.ResponseStatus != tt.wantStatus {
+				t.Errorf("ResponseStatus = %d, want %d", report.ResponseStatus, tt.wantStatus)
+			}
+			if report.ResponseBody != tt.body {
+				t.Errorf("ResponseBody = %q, want %q", report.ResponseBody, tt.body)
+			}
+		})
+	}
+}
+
+func TestPostMessageRequest(t *testing.T) {
+	captureLog(t)
+	var got *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = ioutil.ReadAll(r.Body)
+		w.Write([]byte("1"))
+	}))
+	defer srv.Close()
+
+	s := &Sender{
+		Client:          srv.Client(),
+		URL:             srv.URL,
+		Header:          http.Header{"X-Team": {"mobile"}},
+		SigningSecret:   "secret",
+		SignatureHeader: "X-Signature",
+	}
+	conf := Config{CorrelationID: "run-1", IdempotencyKeyHeader: "Idempotency-Key"}
+	var report RunReport
+	if err := s.postMessage(context.Background(), conf, Message{Title: "Build Succeeded!"}, "key-1", &report); err != nil {
+		t.Fatalf("postMessage() error = %v", err)
+	}
+
+	if got.Method != http.MethodPost {
+		t.Errorf("method = %s, want POST", got.Method)
+	}
+	for name, want := range map[string]string{
+		"Content-Type":     "application/json; charset=utf-8",
+		"X-Team":           "mobile",
+		"X-Correlation-Id": "run-1",
+		"Idempotency-Key":  "key-1",
+		"X-Signature":      hmacSignature("secret", body),
+	} {
+		if v := got.Header.Get(name); v != want {
+			t.Errorf("header %s = %q, want %q", name, v, want)
+		}
+	}
+	var card map[string]interface{}
+	if err := json.Unmarshal(body, &card); err != nil {
+		t.Fatalf("body is not JSON: %s", err)
+	}
+	if card["title"] != "Build Succeeded!" {
+		t.Errorf("title = %v, want Build Succeeded!", card["title"])
+	}
+	if report.PayloadSize != len(body) {
+		t.Errorf("PayloadSize = %d, want %d", report.PayloadSize, len(body))
+	}
+}
+
+func TestPostMessageNetworkError(t *testing.T) {
+	captureLog(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+
+	s := &Sender{Client: &http.Client{}, URL: url}
+	// The status of a previous attempt.
+	report := RunReport{ResponseStatus: http.StatusBadRequest, ResponseBody: "rejected"}
+	err := s.postMessage(context.Background(), Config{}, Message{Title: "t"}, "", &report)
+	if err == nil {
+		t.Fatal("postMessage() succeeded, want an error")
+	}
+	if !isTransient(err) {
+		t.Errorf("isTransient(%v) = false, a refused connection never delivered the message", err)
+	}
+	if report.ResponseStatus != 0 || report.ResponseBody != "" {
+		t.Errorf("response = %d %q, want none", report.ResponseStatus, report.ResponseBody)
+	}
+}
+
+func TestNormalizeWebhookURL(t *testing.T) {
+	const hook = "https://contoso.webhook.office.com/webhookb2/a-b@c/IncomingWebhook/d/e"
+	tests := []struct {
+		name        string
+		in          string
+		want        string
+		wantChanged bool
+	}{
+		{"clean", hook, hook, false},
+		{"double quotes", `"` + hook + `"`, hook, true},
+		{"single quotes", "'" + hook + "'", hook, true},
+		{"CRLF", hook + "\r\n", hook, true},
+		{"leading spaces", "   " + hook, hook, true},
+		{"quotes and whitespace", " \"" + hook + " \"\n", hook, true},
+		{"only one pair removed", `""` + hook + `""`, `"` + hook + `"`, true},
+		{"mismatched quotes kept", `"` + hook + "'", `"` + hook + "'", false},
+		{"leading quote kept", `"` + hook, `"` + hook, false},
+		{"quotes inside kept", `https://relay.example.com/hook?name="team"&q='x'`, `https://relay.example.com/hook?name="team"&q='x'`, false},
+		{"spaces inside kept", "https://relay.example.com/a%20b c", "https://relay.example.com/a%20b c", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := normalizeWebhookURL(tt.in)
+			if got != tt.want || changed != tt.wantChanged {
+				t.Errorf("normalizeWebhookURL(%q) = %q, %v, want %q, %v", tt.in, got, changed, tt.want, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestResolveWebhookURLsNormalizes(t *testing.T) {
+	const hook = "https://contoso.webhook.office.com/webhookb2/secret-token"
+	tests := []struct {
+		name     string
+		list     string
+		wantWarn bool
+	}{
+		{"clean", hook, false},
+		{"trailing newline", hook + "\r\n", false},
+		{"quotes", `"` + hook + `"`, true},
+		{"list with quotes", hook + "\n'" + hook + "'", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := captureLog(t)
+			urls, err := resolveWebhookURLs(tt.list, "", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, u := range urls {
+				if u != hook {
+					t.Errorf("resolveWebhookURLs(%q) = %q, want %q", tt.list, urls, hook)
+				}
+			}
+			if warned := strings.Contains(log.String(), "quotes removed"); warned != tt.wantWarn {
+				t.Errorf("resolveWebhookURLs(%q) logged %q, want a warning %v", tt.list, log, tt.wantWarn)
+			}
+			if strings.Contains(log.String(), "secret-token") {
+				t.Errorf("resolveWebhookURLs(%q) logged the URL: %s", tt.list, log)
+			}
+		})
+	}
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		anyHost bool
+		wantErr string
+	}{
+		{"connector", "https://contoso.webhook.office.com/webhookb2/a@b/IncomingWebhook/c/d", false, ""},
+		{"legacy connector", "https://outlook.office.com/webhook/a@b/IncomingWebhook/c/d", false, ""},
+		{"workflow", "https://prod-12.westeurope.logic.azure.com:443/workflows/1/triggers/manual/paths/invoke?sig=x", false, ""},
+		{"Power Platform workflow", "https://default1.2e.environment.api.powerplatform.com/powerautomate/automations/direct/workflows/1", false, ""},
+		{"Slack", "https://hooks.slack.com/services/T0/B0/X", false, "Slack webhook (hooks.slack.com)"},
+		{"Teams channel link", "https://teams.microsoft.com/l/channel/19%3a1/General", false, "link of a Teams channel"},
+		{"http", "http://contoso.webhook.office.com/webhookb2/1", false, "not an https URL"},
+		{"other host", "https://relay.example.com/teams", false, "host relay.example.com is not a Teams webhook"},
+		{"lookalike host", "https://webhook.office.com.example.com/webhookb2/1", false, "is not a Teams webhook"},
+		{"any host", "http://relay.example.com/teams", true, ""},
+		{"Slack with any host", "https://hooks.slack.com/services/T0/B0/X", true, ""},
+		{"no host", "contoso.webhook.office.com/webhookb2/1", false, "not an https URL"},
+		{"invalid", "https://contoso.webhook.office.com/%zz", false, "invalid webhook URL"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkWebhookURL(tt.url, tt.anyHost)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("checkWebhookURL(%q, %v) = %v, want nil", tt.url, tt.anyHost, err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("checkWebhookURL(%q, %v) = %v, want an error with %q", tt.url, tt.anyHost, err, tt.wantErr)
+			}
+			if err != nil && strings.Contains(err.Error(), "/webhookb2/") {
+				t.Errorf("checkWebhookURL(%q, %v) = %v, the error contains the path of the URL", tt.url, tt.anyHost, err)
+			}
+		})
+	}
+}
+
+func TestResolveWebhookURLsTrims(t *testing.T) {
+	captureLog(t)
+	const hook = "https://contoso.webhook.office.com/webhookb2/1"
+	for _, list := range []string{hook + "\n", "  " + hook + "  ", hook + "\r\n\n", "\t" + hook + " | " + hook + "\n"} {
+		urls, err := resolveWebhookURLs(list, "", false)
+		if err != nil {
+			t.Errorf("resolveWebhookURLs(%q) error = %v, want the URL trimmed", list, err)
+			continue
+		}
+		for _, u := range urls {
+			if u != hook {
+				t.Errorf("resolveWebhookURLs(%q) = %q, want %q", list, urls, hook)
+			}
+		}
+	}
+	if _, err := resolveWebhookURLs("https://hooks.slack.com/services/T0/B0/X\n", "", false); err == nil {
+		t.Error("resolveWebhookURLs(Slack) error = nil, want the URL rejected")
+	}
+}
+
+func TestParseRequestHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    http.Header
+		wantErr string
+	}{
+		{"empty", "\n \n", http.Header{}, ""},
+		{"headers", "Authorization: Bearer abc.def\n  x-request-source :  bitrise  \n", http.Header{
+			"Authorization":    {"Bearer abc.def"},
+			"X-Request-Source": {"bitrise"},
+		}, ""},
+		{"value with colons", "X-Time: 12:30:00", http.Header{"X-Time": {"12:30:00"}}, ""},
+		{"repeated header", "X-Team: ios\nX-Team: android", http.Header{"X-Team": {"ios", "android"}}, ""},
+		{"empty value", "X-Empty:", http.Header{"X-Empty": {""}}, ""},
+		{"Content-Type", "X-Team: ios\ncontent-type: text/plain", nil, "line 2 sets Content-Type"},
+		{"malformed lines", "Authorization Bearer secret-value\nX-Team: ios\nBad Name: x\n: x", nil, "malformed request_headers lines 1, 3, 4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRequestHeaders(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseRequestHeaders(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+				}
+				if strings.Contains(err.Error(), "secret-value") {
+					t.Errorf("parseRequestHeaders(%q) error = %v, it contains the value", tt.in, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseRequestHeaders(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostMessageRequestHeaders(t *testing.T) {
+	captureLog(t)
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Write([]byte("1"))
+	}))
+	defer srv.Close()
+
+	header, err := parseRequestHeaders("Authorization: Bearer gateway-token\nX-Request-Source: bitrise\nX-Team: ios\nX-Team: android")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Sender{Client: srv.Client(), URL: srv.URL, Header: header}
+	if err := s.postMessage(context.Background(), Config{}, Message{Title: "Build Succeeded!"}, "", &RunReport{}); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string][]string{
+		"Authorization":    {"Bearer gateway-token"},
+		"X-Request-Source": {"bitrise"},
+		"X-Team":           {"ios", "android"},
+		"Content-Type":     {"application/json; charset=utf-8"},
+	} {
+		if !reflect.DeepEqual(got[name], want) {
+			t.Errorf("header %s = %q, want %q", name, got[name], want)
+		}
+	}
+}